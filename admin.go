@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/minio/minio-go/v7/pkg/signer"
+	"golang.org/x/crypto/argon2"
+)
+
+// adminClient calls the few MinIO admin API operations the agent needs on
+// canned policies, on the users of the consumers and on notification targets.
+type adminClient struct {
+	endpoint  *url.URL
+	accessKey string
+	secretKey string
+	region    string
+	http      *http.Client
+}
+
+const adminAPIPrefix = "/minio/admin/v3"
+
+// cannedPolicies returns the policy documents by name.
+func (c *adminClient) cannedPolicies(ctx context.Context) (map[string]json.RawMessage, error) {
+	content, err := c.do(ctx, http.MethodGet, "/list-canned-policies", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	policies := make(map[string]json.RawMessage)
+	if err = json.Unmarshal(content, &policies); err != nil {
+		return nil, fmt.Errorf("cannot parse canned policies: %w", err)
+	}
+	return policies, nil
+}
+
+// addCannedPolicy creates or replaces a canned policy.
+func (c *adminClient) addCannedPolicy(ctx context.Context, name string, document string) error {
+	_, err := c.do(ctx, http.MethodPut, "/add-canned-policy", url.Values{"name": {name}}, []byte(document))
+	return err
+}
+
+// userInfo is what the admin API tells of a user.
+type userInfo struct {
+	PolicyName string `json:"policyName"`
+	Status     string `json:"status"`
+}
+
+// userEnabled is the status of an enabled user.
+const userEnabled = "enabled"
+
+// errNoSuchUser is returned by user for a missing user.
+var errNoSuchUser = errors.New("no such user")
+
+// user reads a user, errNoSuchUser when it does not exist.
+func (c *adminClient) user(ctx context.Context, accessKey string) (*userInfo, error) {
+	content, err := c.do(ctx, http.MethodGet, "/user-info", url.Values{"accessKey": {accessKey}}, nil)
+	var status *adminStatusError
+	if errors.As(err, &status) && status.code == http.StatusNotFound {
+		return nil, errNoSuchUser
+	}
+	if err != nil {
+		return nil, err
+	}
+	var info userInfo
+	if err = json.Unmarshal(content, &info); err != nil {
+		return nil, fmt.Errorf("cannot parse user %s: %w", accessKey, err)
+	}
+	return &info, nil
+}
+
+// addUser creates or updates an enabled user.
+func (c *adminClient) addUser(ctx context.Context, accessKey string, secretKey string) error {
+	request, err := json.Marshal(map[string]string{"secretKey": secretKey, "status": userEnabled})
+	if err != nil {
+		return err
+	}
+	body, err := encryptAdminData(c.secretKey, request)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, http.MethodPut, "/add-user", url.Values{"accessKey": {accessKey}}, body)
+	return err
+}
+
+// setUserPolicy attaches a canned policy to a user, in place of any other.
+func (c *adminClient) setUserPolicy(ctx context.Context, accessKey string, policy string) error {
+	_, err := c.do(ctx, http.MethodPut, "/set-user-or-group-policy", url.Values{
+		"policyName":  {policy},
+		"userOrGroup": {accessKey},
+		"isGroup":     {"false"},
+	}, nil)
+	return err
+}
+
+// targetOnline is the status of a reachable notification target.
+const targetOnline = "online"
+
+// serverInfo is the part of the server information listing the notification
+// targets, by type then id: {"webhook": [{"primary": {"status": "online"}}]}.
+type serverInfo struct {
+	Services struct {
+		Notifications []map[string][]map[string]struct {
+			Status string `json:"status"`
+		} `json:"notifications"`
+	} `json:"services"`
+}
+
+// notificationTargets returns the status of the notification targets of the
+// server by <id>:<type>, e.g. primary:webhook.
+func (c *adminClient) notificationTargets(ctx context.Context) (map[string]string, error) {
+	content, err := c.do(ctx, http.MethodGet, "/info", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var info serverInfo
+	if err = json.Unmarshal(content, &info); err != nil {
+		return nil, fmt.Errorf("cannot parse server information: %w", err)
+	}
+	targets := make(map[string]string)
+	for _, kinds := range info.Services.Notifications {
+		for kind, ids := range kinds {
+			for _, statuses := range ids {
+				for id, status := range statuses {
+					targets[id+":"+kind] = status.Status
+				}
+			}
+		}
+	}
+	return targets, nil
+}
+
+// adminStatusError is an admin API answer other than 200 OK.
+type adminStatusError struct {
+	method  string
+	path    string
+	code    int
+	status  string
+	message []byte
+}
+
+func (e *adminStatusError) Error() string {
+	return fmt.Sprintf("admin %s %s: %s: %s", e.method, e.path, e.status, e.message)
+}
+
+func (c *adminClient) do(ctx context.Context, method string, path string, query url.Values, body []byte) ([]byte, error) {
+	target := *c.endpoint
+	target.Path = adminAPIPrefix + path
+	target.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(hash[:]))
+	req = signer.SignV4(*req, c.accessKey, c.secretKey, "", c.region)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &adminStatusError{method: method, path: path, code: resp.StatusCode, status: resp.Status, message: bytes.TrimSpace(content)}
+	}
+	return content, nil
+}
+
+// The payloads of the user operations are encrypted with the secret key of the
+// caller, as madmin.EncryptData does: a random salt, the id of the cipher and
+// a nonce precede a sio stream sealed with AES-256-GCM under an argon2id key
+// of the secret key.
+const (
+	adminSaltSize       = 32
+	adminNonceSize      = 8
+	adminArgon2idAESGCM = 0x00
+	sioFragmentSize     = 16 * 1024
+	sioFinalFragment    = 0x80
+)
+
+func encryptAdminData(password string, data []byte) ([]byte, error) {
+	header := make([]byte, adminSaltSize+1+adminNonceSize)
+	if _, err := rand.Read(header); err != nil {
+		return nil, err
+	}
+	salt := header[:adminSaltSize]
+	header[adminSaltSize] = adminArgon2idAESGCM
+	nonce := header[adminSaltSize+1:]
+	aead, err := adminAEAD(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	return sealStream(header, aead, nonce, data), nil
+}
+
+func adminAEAD(password string, salt []byte) (cipher.AEAD, error) {
+	key := argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealStream appends data sealed as a sio stream: fragments of 16 KiB, each
+// sealed under the nonce followed by its little-endian sequence number. The
+// associated data of the fragments flags the last one and carries the tag of
+// an empty seal under sequence number 0.
+func sealStream(out []byte, aead cipher.AEAD, nonce []byte, data []byte) []byte {
+	fragmentNonce := make([]byte, aead.NonceSize())
+	copy(fragmentNonce, nonce)
+	var sequence uint32
+	next := func() []byte {
+		binary.LittleEndian.PutUint32(fragmentNonce[len(nonce):], sequence)
+		sequence++
+		return fragmentNonce
+	}
+	associated := aead.Seal(make([]byte, 1, 1+aead.Overhead()), next(), nil, nil)
+	for len(data) > sioFragmentSize {
+		out = aead.Seal(out, next(), data[:sioFragmentSize], associated)
+		data = data[sioFragmentSize:]
+	}
+	associated[0] = sioFinalFragment
+	return aead.Seal(out, next(), data, associated)
+}
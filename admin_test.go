@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestEncryptAdminDataLayout(t *testing.T) {
+	for _, size := range []int{0, 100, sioFragmentSize, 2*sioFragmentSize + 1} {
+		data := []byte(strings.Repeat("x", size))
+		encrypted, err := encryptAdminData("password", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encrypted[adminSaltSize] != adminArgon2idAESGCM {
+			t.Fatalf("cipher id = %d", encrypted[adminSaltSize])
+		}
+		salt := encrypted[:adminSaltSize]
+		nonce := encrypted[adminSaltSize+1 : adminSaltSize+1+adminNonceSize]
+		aead, err := adminAEAD("password", salt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := openStream(aead, nonce, encrypted[adminSaltSize+1+adminNonceSize:])
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatalf("%d bytes: decrypted %d bytes", size, len(decrypted))
+		}
+	}
+}
+
+// openStream reads a sio stream the way madmin.DecryptData does.
+func openStream(aead cipher.AEAD, nonce []byte, sealed []byte) ([]byte, error) {
+	fragmentNonce := make([]byte, aead.NonceSize())
+	copy(fragmentNonce, nonce)
+	var sequence uint32
+	next := func() []byte {
+		binary.LittleEndian.PutUint32(fragmentNonce[len(nonce):], sequence)
+		sequence++
+		return fragmentNonce
+	}
+	associated := aead.Seal(make([]byte, 1, 1+aead.Overhead()), next(), nil, nil)
+	var data []byte
+	for {
+		size := sioFragmentSize + aead.Overhead()
+		if len(sealed) <= size {
+			associated[0] = sioFinalFragment
+			plain, err := aead.Open(nil, next(), sealed, associated)
+			return append(data, plain...), err
+		}
+		plain, err := aead.Open(nil, next(), sealed[:size], associated)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, plain...)
+		sealed = sealed[size:]
+	}
+}
+
+func TestNotificationTargets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != adminAPIPrefix+"/info" || r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"mode":"online","services":{"notifications":[
+			{"webhook":[{"primary":{"status":"online"}},{"audit":{"status":"offline"}}]},
+			{"amqp":[{"events":{"status":"online"}}]}]}}`))
+	}))
+	defer server.Close()
+	endpoint, _ := url.Parse(server.URL)
+	client := &adminClient{endpoint: endpoint, accessKey: "minio", secretKey: "secret", region: DefaultRegion, http: server.Client()}
+	targets, err := client.notificationTargets(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"primary:webhook": "online", "audit:webhook": "offline", "events:amqp": "online"}
+	if len(targets) != len(expected) {
+		t.Fatalf("targets = %v", targets)
+	}
+	for id, status := range expected {
+		if targets[id] != status {
+			t.Errorf("%s = %q, want %q", id, targets[id], status)
+		}
+	}
+}
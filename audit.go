@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	builderv0 "github.com/codefly-dev/core/generated/go/codefly/services/builder/v0"
+	"github.com/minio/minio-go/v7/pkg/policy"
+)
+
+// Severity of an audit finding, in the vocabulary of container scanners so
+// that configuration and image findings sort together.
+type Severity string
+
+const (
+	SeverityCritical Severity = "CRITICAL"
+	SeverityHigh     Severity = "HIGH"
+	SeverityMedium   Severity = "MEDIUM"
+	SeverityLow      Severity = "LOW"
+)
+
+var severityRank = map[Severity]int{
+	SeverityCritical: 0,
+	SeverityHigh:     1,
+	SeverityMedium:   2,
+	SeverityLow:      3,
+}
+
+// Configuration checks of the audit.
+const (
+	DefaultCredentialsCheck = "minio-default-credentials"
+	WeakCredentialsCheck    = "minio-weak-credentials"
+	PublicBucketCheck       = "minio-public-bucket"
+	MissingTLSCheck         = "minio-missing-tls"
+	MissingEncryptionCheck  = "minio-missing-encryption"
+	UnversionedBucketCheck  = "minio-unversioned-critical-bucket"
+	MissingBackupCheck      = "minio-missing-backup"
+	ExportedRootKeysCheck   = "minio-exported-root-keys"
+)
+
+// ConfigurationFinding is a weakness of the service configuration, reported
+// next to the vulnerabilities of the image.
+type ConfigurationFinding struct {
+	Check    string
+	Severity Severity
+	// Resource the finding is about: a bucket, a configuration file, the
+	// deployment.
+	Resource string
+	Message  string
+}
+
+func (f *ConfigurationFinding) String() string {
+	return fmt.Sprintf("%s %s %s: %s", f.Severity, f.Check, f.Resource, f.Message)
+}
+
+// MinSecretKeyLength is the length below which a secret key is weak. MinIO
+// itself only requires 8 characters.
+const MinSecretKeyLength = 16
+
+// defaultAccessKeys and defaultSecretKeys are the credentials of MinIO
+// documentation, examples and former codefly services.
+var (
+	defaultAccessKeys = []string{"minio", "minioadmin", "admin", "root"}
+	defaultSecretKeys = []string{"minio", "minioadmin", "minio123", "password", "secret", "changeme"}
+)
+
+// environmentCredentials are the root credentials of one environment.
+type environmentCredentials struct {
+	Environment string
+	AccessKey   string
+	SecretKey   string
+}
+
+// auditCredentials flags default and weak root credentials. They only rank
+// low in the local environment, which never leaves the workstation.
+func auditCredentials(credentials []*environmentCredentials) []*ConfigurationFinding {
+	var findings []*ConfigurationFinding
+	for _, c := range credentials {
+		severity := SeverityCritical
+		if c.Environment == "local" {
+			severity = SeverityLow
+		}
+		resource := configurationFile(c.Environment, true)
+		if contains(defaultAccessKeys, c.AccessKey) {
+			findings = append(findings, &ConfigurationFinding{
+				Check:    DefaultCredentialsCheck,
+				Severity: severity,
+				Resource: resource,
+				Message:  fmt.Sprintf("root access key %q is a well-known default", c.AccessKey),
+			})
+		}
+		switch {
+		case contains(defaultSecretKeys, c.SecretKey):
+			findings = append(findings, &ConfigurationFinding{
+				Check:    DefaultCredentialsCheck,
+				Severity: severity,
+				Resource: resource,
+				Message:  "root secret key is a well-known default",
+			})
+		case len(c.SecretKey) < MinSecretKeyLength:
+			findings = append(findings, &ConfigurationFinding{
+				Check:    WeakCredentialsCheck,
+				Severity: severity,
+				Resource: resource,
+				Message:  fmt.Sprintf("root secret key has %d characters, expected at least %d", len(c.SecretKey), MinSecretKeyLength),
+			})
+		}
+	}
+	return findings
+}
+
+// auditSettings flags the settings that weaken the deployed service.
+func auditSettings(settings *Settings, buckets []*BucketSettings, claims []*ConsumerClaims) []*ConfigurationFinding {
+	var findings []*ConfigurationFinding
+	critical := 0
+	for _, bucket := range buckets {
+		switch policy.BucketPolicy(bucket.Policy) {
+		case policy.BucketPolicyReadWrite:
+			findings = append(findings, &ConfigurationFinding{
+				Check:    PublicBucketCheck,
+				Severity: SeverityCritical,
+				Resource: "bucket " + bucket.Name,
+				Message:  "anyone can read, overwrite and delete the objects",
+			})
+		case policy.BucketPolicyWriteOnly:
+			findings = append(findings, &ConfigurationFinding{
+				Check:    PublicBucketCheck,
+				Severity: SeverityHigh,
+				Resource: "bucket " + bucket.Name,
+				Message:  "anyone can upload objects",
+			})
+		case policy.BucketPolicyReadOnly:
+			findings = append(findings, &ConfigurationFinding{
+				Check:    PublicBucketCheck,
+				Severity: SeverityMedium,
+				Resource: "bucket " + bucket.Name,
+				Message:  "anyone can list and read the objects",
+			})
+		}
+		if !bucket.Critical {
+			continue
+		}
+		critical++
+		if !bucket.Versioning {
+			findings = append(findings, &ConfigurationFinding{
+				Check:    UnversionedBucketCheck,
+				Severity: SeverityHigh,
+				Resource: "bucket " + bucket.Name,
+				Message:  "critical bucket is not versioned: overwritten and deleted objects are lost",
+			})
+		}
+	}
+	if !settings.TLS {
+		findings = append(findings, &ConfigurationFinding{
+			Check:    MissingTLSCheck,
+			Severity: SeverityMedium,
+			Resource: "deployment",
+			Message:  "the S3 API is served over plain HTTP: credentials and objects travel in clear",
+		})
+	}
+	if !settings.Ephemeral && !settings.StorageEncrypted {
+		findings = append(findings, &ConfigurationFinding{
+			Check:    MissingEncryptionCheck,
+			Severity: SeverityMedium,
+			Resource: "deployment",
+			Message:  "objects are not encrypted at rest: use an encrypted storage class and set storage-encrypted",
+		})
+	}
+	if settings.BackupSchedule == "" && !settings.Ephemeral && len(buckets) > 0 {
+		severity := SeverityLow
+		if critical > 0 {
+			severity = SeverityHigh
+		}
+		findings = append(findings, &ConfigurationFinding{
+			Check:    MissingBackupCheck,
+			Severity: severity,
+			Resource: "deployment",
+			Message:  "no backup schedule: set backup-schedule once the buckets are copied out of the service",
+		})
+	}
+	// Once a dependent claims buckets, no dependent gets the root keys.
+	if consumers := rootKeyConsumers(claims); len(consumers) > 0 {
+		findings = append(findings, &ConfigurationFinding{
+			Check:    ExportedRootKeysCheck,
+			Severity: SeverityMedium,
+			Resource: "connection",
+			Message:  "dependent services receive the root access and secret keys: " + strings.Join(consumers, ", "),
+		})
+	}
+	return findings
+}
+
+// sortFindings puts the most severe findings first.
+func sortFindings(findings []*ConfigurationFinding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severityRank[findings[i].Severity] < severityRank[findings[j].Severity]
+	})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// environmentCredentials reads the root credentials of every environment
+// configured in the service.
+func (s *Service) environmentCredentials() ([]*environmentCredentials, error) {
+	files, err := filepath.Glob(filepath.Join(s.Local("configurations"), "*", ConfigurationName+".secret.env"))
+	if err != nil {
+		return nil, err
+	}
+	var credentials []*environmentCredentials
+	for _, file := range files {
+		environment := filepath.Base(filepath.Dir(file))
+		conf, err := readConfigurationFile(file)
+		if err != nil {
+			return nil, err
+		}
+		values, err := ParseConfiguration(conf, environment)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, &environmentCredentials{
+			Environment: environment,
+			AccessKey:   values[AccessKeyField.Name],
+			SecretKey:   values[SecretKeyField.Name],
+		})
+	}
+	return credentials, nil
+}
+
+// auditConfiguration runs every configuration check.
+func (s *Service) auditConfiguration() ([]*ConfigurationFinding, error) {
+	credentials, err := s.environmentCredentials()
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot read credentials")
+	}
+	findings := auditCredentials(credentials)
+	findings = append(findings, auditSettings(s.Settings, s.bucketSettings(), s.claims)...)
+	sortFindings(findings)
+	return findings, nil
+}
+
+func (f *ConfigurationFinding) audit() *builderv0.AuditFinding {
+	return &builderv0.AuditFinding{
+		Id:       f.Check,
+		Severity: string(f.Severity),
+		Package:  f.Resource,
+		Title:    f.Message,
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7/pkg/policy"
+)
+
+func findingChecks(findings []*ConfigurationFinding) map[string]Severity {
+	checks := make(map[string]Severity)
+	for _, finding := range findings {
+		checks[finding.Check] = finding.Severity
+	}
+	return checks
+}
+
+func TestAuditCredentials(t *testing.T) {
+	findings := auditCredentials([]*environmentCredentials{
+		{Environment: "local", AccessKey: "minio", SecretKey: "generated-secret-key"},
+		{Environment: "production", AccessKey: "storage", SecretKey: "short"},
+	})
+	if len(findings) != 2 {
+		t.Fatalf("findings = %v", findings)
+	}
+	if findings[0].Check != DefaultCredentialsCheck || findings[0].Severity != SeverityLow {
+		t.Errorf("local default access key: %v", findings[0])
+	}
+	if findings[1].Check != WeakCredentialsCheck || findings[1].Severity != SeverityCritical {
+		t.Errorf("production weak secret key: %v", findings[1])
+	}
+}
+
+func TestAuditSettings(t *testing.T) {
+	buckets := []*BucketSettings{
+		{Name: "public", Policy: string(policy.BucketPolicyReadWrite)},
+		{Name: "records", Critical: true},
+	}
+	claims := []*ConsumerClaims{{Consumer: "backend/worker"}}
+	findings := auditSettings(&Settings{}, buckets, claims)
+	checks := findingChecks(findings)
+	for check, severity := range map[string]Severity{
+		PublicBucketCheck:      SeverityCritical,
+		MissingTLSCheck:        SeverityMedium,
+		MissingEncryptionCheck: SeverityMedium,
+		UnversionedBucketCheck: SeverityHigh,
+		MissingBackupCheck:     SeverityHigh,
+		ExportedRootKeysCheck:  SeverityMedium,
+	} {
+		if checks[check] != severity {
+			t.Errorf("%s = %q, want %q", check, checks[check], severity)
+		}
+	}
+	for _, finding := range findings {
+		if finding.Check == ExportedRootKeysCheck && !strings.HasSuffix(finding.Message, ": backend/worker") {
+			t.Errorf("exported root keys: %v", finding)
+		}
+	}
+
+	checks = findingChecks(auditSettings(&Settings{}, []*BucketSettings{{Name: "cache"}}, nil))
+	if checks[MissingBackupCheck] != SeverityLow {
+		t.Errorf("backup of a bucket that is not critical = %q", checks[MissingBackupCheck])
+	}
+
+	hardened := &Settings{TLS: true, StorageEncrypted: true, BackupSchedule: "0 3 * * *"}
+	claims = []*ConsumerClaims{{Consumer: "backend/api", Claims: []*BucketClaim{{Name: "records"}}}}
+	checks = findingChecks(auditSettings(hardened, []*BucketSettings{{Name: "records", Critical: true, Versioning: true}}, claims))
+	if len(checks) != 0 {
+		t.Errorf("hardened settings findings = %v", checks)
+	}
+}
+
+func TestSortFindings(t *testing.T) {
+	findings := []*ConfigurationFinding{{Severity: SeverityLow}, {Severity: SeverityCritical}, {Severity: SeverityMedium}}
+	sortFindings(findings)
+	if findings[0].Severity != SeverityCritical || findings[2].Severity != SeverityLow {
+		t.Errorf("findings = %v", findings)
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+	"github.com/codefly-dev/core/resources"
+	"github.com/codefly-dev/core/wool"
+)
+
+// AuditLogSettings send the MinIO audit log, one record per API call with
+// the caller, the bucket and the object, to a webhook.
+type AuditLogSettings struct {
+	// Endpoint is the codefly service endpoint receiving the records, as
+	// module/service/endpoint. Without it, the local runtime writes them into
+	// the workspace and deployments send none.
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// Path of the webhook on the endpoint.
+	Path string `yaml:"path,omitempty"`
+}
+
+// auditWebhookTarget is the MinIO audit webhook target the service
+// configures, in the MINIO_AUDIT_WEBHOOK_*_CODEFLY variables.
+const auditWebhookTarget = "CODEFLY"
+
+// validateAuditLog checks the audit-log setting.
+func validateAuditLog(settings *AuditLogSettings) error {
+	if settings == nil {
+		return nil
+	}
+	if settings.Endpoint != "" {
+		if _, _, _, err := parseEndpointReference(settings.Endpoint); err != nil {
+			return err
+		}
+	}
+	if settings.Path != "" && !strings.HasPrefix(settings.Path, "/") {
+		return fmt.Errorf("audit-log path %q must start with /", settings.Path)
+	}
+	if settings.Path != "" && settings.Endpoint == "" {
+		return fmt.Errorf("audit-log path needs an endpoint")
+	}
+	return nil
+}
+
+// parseEndpointReference reads module/service/endpoint.
+func parseEndpointReference(reference string) (string, string, string, error) {
+	parts := strings.Split(reference, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", "", fmt.Errorf("endpoint %q must be module/service/endpoint", reference)
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// auditWebhookURL resolves the endpoint of the settings in network mappings,
+// with an access.
+func auditWebhookURL(ctx context.Context, settings *AuditLogSettings, mappings []*basev0.NetworkMapping, access *basev0.NetworkAccess) (string, error) {
+	module, service, name, err := parseEndpointReference(settings.Endpoint)
+	if err != nil {
+		return "", err
+	}
+	for _, mapping := range mappings {
+		endpoint := mapping.Endpoint
+		if endpoint == nil || endpoint.Module != module || endpoint.Service != service || endpoint.Name != name {
+			continue
+		}
+		instance, err := resources.FindNetworkInstanceInNetworkMappings(ctx, mappings, endpoint, access)
+		if err != nil {
+			return "", err
+		}
+		if instance == nil {
+			break
+		}
+		return "http://" + instance.Address + settings.Path, nil
+	}
+	return "", fmt.Errorf("audit-log endpoint %s is not in the network mappings: is the service a dependency?", settings.Endpoint)
+}
+
+// auditWebhookEnvironment enables the audit webhook of MinIO.
+func auditWebhookEnvironment(url string, token string) []*resources.EnvironmentVariable {
+	env := []*resources.EnvironmentVariable{
+		resources.Env("MINIO_AUDIT_WEBHOOK_ENABLE_"+auditWebhookTarget, "on"),
+		resources.Env("MINIO_AUDIT_WEBHOOK_ENDPOINT_"+auditWebhookTarget, url),
+	}
+	if token != "" {
+		env = append(env, resources.Env("MINIO_AUDIT_WEBHOOK_AUTH_TOKEN_"+auditWebhookTarget, token))
+	}
+	return env
+}
+
+// auditLogFile is where the collector writes, in the workspace.
+func (s *Service) auditLogFile() string {
+	return filepath.Join(s.Identity.WorkspacePath, ".codefly", "audit", s.Identity.Module, s.Identity.Name, "audit.ndjson")
+}
+
+func (s *Service) auditCollectorFile() string {
+	return filepath.Join(s.runDirectory(), "audit-collector.json")
+}
+
+// auditCollector receives the audit webhook of MinIO in the agent and appends
+// the records, one JSON document per line, to a file. MinIO authenticates
+// with a generated token.
+//
+// The token and the port are kept in the run directory, readable by the owner
+// only: a container reused by a new agent process keeps sending to the same
+// URL.
+type auditCollector struct {
+	file  string
+	state string
+	token string
+
+	mu     sync.Mutex
+	out    *os.File
+	server *http.Server
+	host   string
+	port   int
+}
+
+// auditCollectorState is the content of the state file.
+type auditCollectorState struct {
+	Token string `json:"token"`
+	Port  int    `json:"port"`
+}
+
+func newAuditCollector(file string, state string) (*auditCollector, error) {
+	c := &auditCollector{file: file, state: state}
+	if content, err := os.ReadFile(state); err == nil {
+		var previous auditCollectorState
+		if json.Unmarshal(content, &previous) == nil && previous.Token != "" {
+			c.token, c.port = previous.Token, previous.Port
+			return c, nil
+		}
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	c.token = hex.EncodeToString(token)
+	return c, nil
+}
+
+// dockerBridgeAddress is the IPv4 address of the host on the docker0 bridge,
+// empty without the bridge.
+func dockerBridgeAddress() string {
+	bridge, err := net.InterfaceByName("docker0")
+	if err != nil {
+		return ""
+	}
+	addresses, _ := bridge.Addrs()
+	for _, address := range addresses {
+		if network, ok := address.(*net.IPNet); ok && network.IP.To4() != nil {
+			return network.IP.String()
+		}
+	}
+	return ""
+}
+
+// collectorAddress is the address the collector listens on for a backend,
+// and the host the server reaches it under. Native servers run on the host.
+// On Linux, containers reach the host through the docker0 bridge; rootless
+// Docker and custom bridges have none, and the collector cannot be reached.
+// Docker Desktop has no bridge on the host, and forwards host.docker.internal
+// to its loopback interface.
+func collectorAddress(backend string) (listen string, host string, err error) {
+	if backend != DockerBackend {
+		return "127.0.0.1", "127.0.0.1", nil
+	}
+	if bridge := dockerBridgeAddress(); bridge != "" {
+		return bridge, bridge, nil
+	}
+	if runtime.GOOS == "linux" {
+		return "", "", fmt.Errorf("the container cannot reach the audit collector without the docker0 bridge: set the endpoint of audit-log")
+	}
+	return "127.0.0.1", "host.docker.internal", nil
+}
+
+// Start listens on the port of a former collector when it is free, on a free
+// port otherwise.
+func (c *auditCollector) Start(backend string) error {
+	if err := os.MkdirAll(filepath.Dir(c.file), 0o700); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(c.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	listen, host, err := collectorAddress(backend)
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(listen, strconv.Itoa(c.port)))
+	if err != nil && c.port != 0 {
+		listener, err = net.Listen("tcp", net.JoinHostPort(listen, "0"))
+	}
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+	c.out = out
+	c.host = host
+	c.port = listener.Addr().(*net.TCPAddr).Port
+	if err = writeAuditCollectorState(c.state, &auditCollectorState{Token: c.token, Port: c.port}); err != nil {
+		_ = listener.Close()
+		_ = out.Close()
+		return err
+	}
+	c.server = &http.Server{Handler: requireBearer(c.token, c), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		_ = c.server.Serve(listener)
+	}()
+	return nil
+}
+
+func writeAuditCollectorState(file string, state *auditCollectorState) error {
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, content, 0o600)
+}
+
+// URL of the collector, for the server it was started for.
+func (c *auditCollector) URL() string {
+	return fmt.Sprintf("http://%s/audit", net.JoinHostPort(c.host, strconv.Itoa(c.port)))
+}
+
+func (c *auditCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	records, err := auditRecords(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err = c.append(records); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// auditRecords reads the JSON documents of a webhook call: one record, a
+// batch as an array, or records one after the other.
+func auditRecords(body io.Reader) ([]json.RawMessage, error) {
+	var records []json.RawMessage
+	decoder := json.NewDecoder(body)
+	for {
+		var value json.RawMessage
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if trimmed := bytes.TrimSpace(value); len(trimmed) > 0 && trimmed[0] == '[' {
+			var batch []json.RawMessage
+			if err = json.Unmarshal(trimmed, &batch); err != nil {
+				return nil, err
+			}
+			records = append(records, batch...)
+			continue
+		}
+		records = append(records, value)
+	}
+}
+
+func (c *auditCollector) append(records []json.RawMessage) error {
+	var lines bytes.Buffer
+	for _, record := range records {
+		if err := json.Compact(&lines, record); err != nil {
+			return err
+		}
+		lines.WriteByte('\n')
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.out.Write(lines.Bytes())
+	return err
+}
+
+// Close stops receiving and closes the file.
+func (c *auditCollector) Close() error {
+	if c.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := c.server.Shutdown(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if closeErr := c.out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// configureAuditLog adds the audit webhook to the definition: to the endpoint
+// of the settings, or to a collector started in the agent.
+func (s *Runtime) configureAuditLog(ctx context.Context, mappings []*basev0.NetworkMapping, definition *containerDefinition, backend string) error {
+	settings := s.Settings.AuditLog
+	if settings == nil {
+		return nil
+	}
+	if settings.Endpoint != "" {
+		url, err := auditWebhookURL(ctx, settings, mappings, s.Runtime.NetworkAccess())
+		if err != nil {
+			return s.Wool.Wrapf(err, "cannot resolve the audit-log endpoint")
+		}
+		definition.Environment = append(definition.Environment, auditWebhookEnvironment(url, "")...)
+		return nil
+	}
+	if backend == FakeBackend {
+		s.Wool.Warn("the fake backend has no audit log")
+		return nil
+	}
+	// A new Init replaces the collector of the former one.
+	s.closeAuditCollector()
+	collector, err := newAuditCollector(s.auditLogFile(), s.auditCollectorFile())
+	if err != nil {
+		return err
+	}
+	if err = collector.Start(backend); err != nil {
+		return s.Wool.Wrapf(err, "cannot start the audit log collector")
+	}
+	s.auditCollector = collector
+	definition.Environment = append(definition.Environment, auditWebhookEnvironment(collector.URL(), collector.token)...)
+	s.Wool.Info("collecting the audit log", wool.Field("file", collector.file))
+	return nil
+}
+
+func (s *Runtime) closeAuditCollector() {
+	if s.auditCollector == nil {
+		return
+	}
+	if err := s.auditCollector.Close(); err != nil {
+		s.Wool.Warn("cannot close the audit log collector", wool.ErrField(err))
+	}
+	s.auditCollector = nil
+}
+
+// auditWebhookParameter is the webhook the deployment renders: only an
+// endpoint, the collector of the agent does not run in the cluster.
+func (s *Builder) auditWebhookParameter(ctx context.Context, mappings []*basev0.NetworkMapping) (string, error) {
+	settings := s.Settings.AuditLog
+	if settings == nil {
+		return "", nil
+	}
+	if settings.Endpoint == "" {
+		s.Wool.Warn("the audit log is only collected locally: set audit-log.endpoint to send it from deployments")
+		return "", nil
+	}
+	return auditWebhookURL(ctx, settings, mappings, resources.NewContainerNetworkAccess())
+}
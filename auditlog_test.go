@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestValidateAuditLog(t *testing.T) {
+	for _, valid := range []*AuditLogSettings{nil, {}, {Endpoint: "ops/audit/api"}, {Endpoint: "ops/audit/api", Path: "/minio"}} {
+		if err := validateAuditLog(valid); err != nil {
+			t.Errorf("%+v: %v", valid, err)
+		}
+	}
+	for _, invalid := range []*AuditLogSettings{{Endpoint: "audit"}, {Endpoint: "ops//api"}, {Endpoint: "ops/audit/api", Path: "minio"}, {Path: "/minio"}} {
+		if err := validateAuditLog(invalid); err == nil {
+			t.Errorf("%+v: expected an error", invalid)
+		}
+	}
+}
+
+func TestAuditWebhookNeedsTheEndpointMapping(t *testing.T) {
+	_, err := auditWebhookURL(context.Background(), &AuditLogSettings{Endpoint: "ops/audit/api"}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "ops/audit/api") {
+		t.Errorf("expected a missing mapping error, got %v", err)
+	}
+}
+
+func TestAuditCollectorWritesNDJSON(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "audit", "audit.ndjson")
+	state := filepath.Join(dir, "run", "audit-collector.json")
+	collector, err := newAuditCollector(file, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = collector.Start(NativeBackend); err != nil {
+		t.Fatal(err)
+	}
+	defer collector.Close()
+	url := collector.URL()
+	if url != fmt.Sprintf("http://127.0.0.1:%d/audit", collector.port) {
+		t.Errorf("native URL = %s", url)
+	}
+
+	post := func(token string, body string) int {
+		request, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer "+token)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		return response.StatusCode
+	}
+	if status := post(collector.token, `{"version":"1", "api": {"name": "GetObject", "bucket": "uploads"}}`); status != http.StatusOK {
+		t.Errorf("single record: %d", status)
+	}
+	if status := post(collector.token, `[{"api":{"name":"DeleteObject"}},{"api":{"name":"PutObject"}}]`); status != http.StatusOK {
+		t.Errorf("batch: %d", status)
+	}
+	if status := post("guess", `{"api":{"name":"Forged"}}`); status != http.StatusUnauthorized {
+		t.Errorf("unauthenticated record: %d", status)
+	}
+	if status := post(collector.token, `{"api":`); status != http.StatusBadRequest {
+		t.Errorf("malformed record: %d", status)
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"version":"1","api":{"name":"GetObject","bucket":"uploads"}}
+{"api":{"name":"DeleteObject"}}
+{"api":{"name":"PutObject"}}
+`
+	if string(content) != expected {
+		t.Errorf("audit log:\n%s", content)
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0o600 {
+		t.Errorf("audit log mode = %v", info.Mode().Perm())
+	}
+	if info, _ := os.Stat(state); info.Mode().Perm() != 0o600 {
+		t.Errorf("collector state mode = %v", info.Mode().Perm())
+	}
+
+	// A new collector keeps the token and the port, once the former is gone.
+	if err = collector.Close(); err != nil {
+		t.Fatal(err)
+	}
+	next, err := newAuditCollector(file, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = next.Start(NativeBackend); err != nil {
+		t.Fatal(err)
+	}
+	defer next.Close()
+	if next.token != collector.token || next.URL() != url {
+		t.Errorf("restarted collector: %s, token kept %v", next.URL(), next.token == collector.token)
+	}
+}
+
+func TestCollectorAddress(t *testing.T) {
+	if listen, host, err := collectorAddress(NativeBackend); listen != "127.0.0.1" || host != "127.0.0.1" || err != nil {
+		t.Errorf("native: %s, %s, %v", listen, host, err)
+	}
+	listen, host, err := collectorAddress(DockerBackend)
+	if dockerBridgeAddress() == "" && runtime.GOOS == "linux" {
+		if err == nil {
+			t.Errorf("docker without a bridge: %s, %s", listen, host)
+		}
+		return
+	}
+	if listen == "" || listen == "0.0.0.0" || host == "" || err != nil {
+		t.Errorf("docker: %s, %s, %v", listen, host, err)
+	}
+}
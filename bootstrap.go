@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// bootstrapParameters render the Job that provisions the buckets and creates
+// the consumer policies and users once the deployed server answers. The Job
+// runs `mc` from the pinned MinIO image.
+type bootstrapParameters struct {
+	Script     []string
+	Policies   []*ConsumerPolicy
+	Lifecycles []*bucketLifecycle
+	// Users are the secret keys of the consumer users, by the variable the
+	// script reads them from. The Job gets them from the Secret.
+	Users map[string]string
+	// Digest suffixes the Job name: Jobs are immutable, so a changed
+	// bootstrap must run as a new Job.
+	Digest string
+}
+
+// bucketLifecycle is the lifecycle configuration the bootstrap imports into
+// a bucket, from File in the bootstrap ConfigMap. Without a File the
+// bootstrap removes the rules of the bucket.
+type bucketLifecycle struct {
+	Bucket   string
+	File     string
+	Document string
+}
+
+// bucketLifecycles are the lifecycles of the buckets that declare one.
+// Buckets that do not keep the one set on the server.
+func bucketLifecycles(buckets []*BucketSettings) ([]*bucketLifecycle, error) {
+	var lifecycles []*bucketLifecycle
+	for _, bucket := range buckets {
+		if bucket.Lifecycle == nil {
+			continue
+		}
+		if len(bucket.Lifecycle) == 0 {
+			lifecycles = append(lifecycles, &bucketLifecycle{Bucket: bucket.Name})
+			continue
+		}
+		document, err := lifecycleDocument(bucket.Lifecycle)
+		if err != nil {
+			return nil, err
+		}
+		lifecycles = append(lifecycles, &bucketLifecycle{Bucket: bucket.Name, File: "lifecycle-" + bucket.Name + ".json", Document: document})
+	}
+	return lifecycles, nil
+}
+
+// consumerSecretVariable is the variable the bootstrap reads the secret key
+// of a consumer user from, e.g. CONSUMER_BACKEND_API_SECRET_KEY.
+func consumerSecretVariable(user string) string {
+	return "CONSUMER_" + strings.ToUpper(strings.ReplaceAll(user, "-", "_")) + "_SECRET_KEY"
+}
+
+// bootstrapParameters is nil when there is nothing to provision. The consumer
+// users are only created with the root secret key, which restricted renders
+// do not have.
+func (s *Service) bootstrapParameters(withUsers bool) (*bootstrapParameters, error) {
+	buckets := s.bucketNames()
+	policies, err := consumerPolicies(s.claims)
+	if err != nil {
+		return nil, err
+	}
+	lifecycles, err := bucketLifecycles(s.bucketSettings())
+	if err != nil {
+		return nil, err
+	}
+	if len(buckets) == 0 && len(policies) == 0 {
+		return nil, nil
+	}
+	var users map[string]string
+	if withUsers && len(policies) > 0 {
+		users = make(map[string]string)
+		for _, policy := range policies {
+			users[consumerSecretVariable(policy.User)] = consumerSecretKey(s.secretKey, policy.Consumer)
+		}
+	}
+	script := bootstrapScript(buckets, policies, lifecycles, users != nil)
+	hash := sha256.New()
+	for _, line := range script {
+		hash.Write([]byte(line))
+	}
+	for _, policy := range policies {
+		hash.Write([]byte(policy.Document))
+	}
+	for _, lifecycle := range lifecycles {
+		hash.Write([]byte(lifecycle.Document))
+	}
+	return &bootstrapParameters{
+		Script:     script,
+		Policies:   policies,
+		Lifecycles: lifecycles,
+		Users:      users,
+		Digest:     hex.EncodeToString(hash.Sum(nil))[:10],
+	}, nil
+}
+
+// bootstrapScript is idempotent: the Job is re-run whenever it changes. With
+// users, each consumer gets a user with its policy attached.
+func bootstrapScript(buckets []string, policies []*ConsumerPolicy, lifecycles []*bucketLifecycle, users bool) []string {
+	script := []string{
+		"set -e",
+		`until mc alias set minio "$MINIO_URL" "$MINIO_ACCESS_KEY" "$MINIO_SECRET_KEY" >/dev/null; do sleep 2; done`,
+	}
+	for _, bucket := range buckets {
+		script = append(script, fmt.Sprintf("mc mb --ignore-existing %s", shellQuote("minio/"+bucket)))
+	}
+	for _, policy := range policies {
+		script = append(script, fmt.Sprintf("mc admin policy create minio %s %s",
+			shellQuote(policy.Name), shellQuote("/bootstrap/"+policy.Name+".json")))
+		if !users {
+			continue
+		}
+		script = append(script,
+			fmt.Sprintf(`mc admin user add minio %s "$%s" >/dev/null`, shellQuote(policy.User), consumerSecretVariable(policy.User)),
+			// attach fails when the policy is already attached
+			fmt.Sprintf("mc admin policy attach minio %s --user %s || mc admin user info minio %s | grep -qw %s",
+				shellQuote(policy.Name), shellQuote(policy.User), shellQuote(policy.User), shellQuote(policy.Name)))
+	}
+	for _, lifecycle := range lifecycles {
+		if lifecycle.File == "" {
+			// rm fails on a bucket without lifecycle
+			target := shellQuote("minio/" + lifecycle.Bucket)
+			script = append(script, fmt.Sprintf("if mc ilm rule ls %s >/dev/null 2>&1; then mc ilm rule rm --all --force %s; fi", target, target))
+			continue
+		}
+		script = append(script, fmt.Sprintf("mc ilm rule import %s < %s",
+			shellQuote("minio/"+lifecycle.Bucket), shellQuote("/bootstrap/"+lifecycle.File)))
+	}
+	return script
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'"'"'`) + "'"
+}
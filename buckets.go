@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"github.com/minio/minio-go/v7/pkg/notification"
+	"github.com/minio/minio-go/v7/pkg/policy"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+// LifecycleRule expires objects of a bucket, optionally under a prefix.
+type LifecycleRule struct {
+	ID     string `yaml:"id"`
+	Prefix string `yaml:"prefix,omitempty"`
+	// ExpirationDays after which current versions expire.
+	ExpirationDays int `yaml:"expiration-days,omitempty"`
+	// NoncurrentExpirationDays after which noncurrent versions are removed.
+	NoncurrentExpirationDays int `yaml:"noncurrent-expiration-days,omitempty"`
+	// AbortIncompleteMultipartDays after which unfinished uploads are aborted.
+	AbortIncompleteMultipartDays int `yaml:"abort-incomplete-multipart-days,omitempty"`
+}
+
+// LifecycleRules of a bucket. A nil list is not declared, while an empty one
+// declares that the bucket has no rules.
+type LifecycleRules []*LifecycleRule
+
+// IsZero keeps an empty list when the settings are written back.
+func (r LifecycleRules) IsZero() bool {
+	return r == nil
+}
+
+// String is the comparable summary of the rule.
+func (r *LifecycleRule) String() string {
+	return fmt.Sprintf("%s(prefix=%q expiration=%dd noncurrent-expiration=%dd abort-incomplete-multipart=%dd)",
+		r.ID, r.Prefix, r.ExpirationDays, r.NoncurrentExpirationDays, r.AbortIncompleteMultipartDays)
+}
+
+// BucketNotification sends bucket events to a notification target configured
+// on the server, e.g. arn:minio:sqs::primary:webhook.
+type BucketNotification struct {
+	Target string   `yaml:"target"`
+	Events []string `yaml:"events"`
+	Prefix string   `yaml:"prefix,omitempty"`
+	Suffix string   `yaml:"suffix,omitempty"`
+}
+
+// String is the comparable summary of the notification.
+func (n *BucketNotification) String() string {
+	events := append([]string{}, n.Events...)
+	sort.Strings(events)
+	return fmt.Sprintf("%s(events=%s prefix=%q suffix=%q)", n.Target, strings.Join(events, ","), n.Prefix, n.Suffix)
+}
+
+// bucketSettings lists the declared buckets followed by the ones only claimed
+// by consumers, which get the default configuration.
+func (s *Service) bucketSettings() []*BucketSettings {
+	declared := make(map[string]*BucketSettings)
+	for _, bucket := range s.Settings.Buckets {
+		declared[bucket.Name] = bucket
+	}
+	var buckets []*BucketSettings
+	for _, name := range s.bucketNames() {
+		bucket, ok := declared[name]
+		if !ok {
+			bucket = &BucketSettings{Name: name}
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets
+}
+
+// bucketPolicy is the anonymous access of the bucket, none when not set.
+func bucketPolicy(bucket *BucketSettings) (policy.BucketPolicy, error) {
+	if bucket.Policy == "" {
+		return policy.BucketPolicyNone, nil
+	}
+	access := policy.BucketPolicy(bucket.Policy)
+	if !access.IsValidBucketPolicy() {
+		return "", fmt.Errorf("bucket %s: policy must be one of %s, %s, %s or %s, got %q", bucket.Name,
+			policy.BucketPolicyNone, policy.BucketPolicyReadOnly, policy.BucketPolicyWriteOnly, policy.BucketPolicyReadWrite, bucket.Policy)
+	}
+	return access, nil
+}
+
+// anonymousPolicyDocument is the bucket policy granting the anonymous access,
+// empty for none.
+func anonymousPolicyDocument(bucket string, access policy.BucketPolicy) (string, error) {
+	if access == policy.BucketPolicyNone {
+		return "", nil
+	}
+	document := policy.BucketAccessPolicy{
+		Version:    "2012-10-17",
+		Statements: policy.SetPolicy(nil, access, bucket, ""),
+	}
+	content, err := json.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// anonymousAccess classifies a bucket policy document.
+func anonymousAccess(bucket string, document string) (policy.BucketPolicy, error) {
+	if document == "" {
+		return policy.BucketPolicyNone, nil
+	}
+	var parsed policy.BucketAccessPolicy
+	if err := json.Unmarshal([]byte(document), &parsed); err != nil {
+		return "", fmt.Errorf("bucket %s: cannot parse policy: %w", bucket, err)
+	}
+	return policy.GetPolicy(parsed.Statements, bucket, ""), nil
+}
+
+func lifecycleConfiguration(rules []*LifecycleRule) *lifecycle.Configuration {
+	configuration := lifecycle.NewConfiguration()
+	for _, rule := range rules {
+		configuration.Rules = append(configuration.Rules, lifecycle.Rule{
+			ID:         rule.ID,
+			Status:     "Enabled",
+			RuleFilter: lifecycle.Filter{Prefix: rule.Prefix},
+			Expiration: lifecycle.Expiration{Days: lifecycle.ExpirationDays(rule.ExpirationDays)},
+			NoncurrentVersionExpiration: lifecycle.NoncurrentVersionExpiration{
+				NoncurrentDays: lifecycle.ExpirationDays(rule.NoncurrentExpirationDays),
+			},
+			AbortIncompleteMultipartUpload: lifecycle.AbortIncompleteMultipartUpload{
+				DaysAfterInitiation: lifecycle.ExpirationDays(rule.AbortIncompleteMultipartDays),
+			},
+		})
+	}
+	return configuration
+}
+
+// lifecycleRules reads back the enabled rules of a configuration. Parts the
+// settings cannot express, such as transitions, are not represented.
+func lifecycleRules(configuration *lifecycle.Configuration) []*LifecycleRule {
+	if configuration == nil {
+		return nil
+	}
+	var rules []*LifecycleRule
+	for _, rule := range configuration.Rules {
+		if rule.Status != "Enabled" {
+			continue
+		}
+		prefix := rule.RuleFilter.Prefix
+		if prefix == "" {
+			prefix = rule.RuleFilter.And.Prefix
+		}
+		if prefix == "" {
+			prefix = rule.Prefix
+		}
+		rules = append(rules, &LifecycleRule{
+			ID:                           rule.ID,
+			Prefix:                       prefix,
+			ExpirationDays:               int(rule.Expiration.Days),
+			NoncurrentExpirationDays:     int(rule.NoncurrentVersionExpiration.NoncurrentDays),
+			AbortIncompleteMultipartDays: int(rule.AbortIncompleteMultipartUpload.DaysAfterInitiation),
+		})
+	}
+	return rules
+}
+
+func notificationConfiguration(notifications []*BucketNotification) (notification.Configuration, error) {
+	var configuration notification.Configuration
+	for _, n := range notifications {
+		arn, err := notification.NewArnFromString(n.Target)
+		if err != nil {
+			return configuration, fmt.Errorf("notification target %q: %w", n.Target, err)
+		}
+		config := notification.NewConfig(arn)
+		for _, event := range n.Events {
+			config.AddEvents(notification.EventType(event))
+		}
+		if n.Prefix != "" {
+			config.AddFilterPrefix(n.Prefix)
+		}
+		if n.Suffix != "" {
+			config.AddFilterSuffix(n.Suffix)
+		}
+		switch arn.Service {
+		case "sns":
+			configuration.AddTopic(config)
+		case "lambda":
+			configuration.AddLambda(config)
+		default:
+			configuration.AddQueue(config)
+		}
+	}
+	return configuration, nil
+}
+
+// bucketNotifications reads back the notifications of a configuration.
+func bucketNotifications(configuration notification.Configuration) []*BucketNotification {
+	// The target ARN is only carried by the typed configurations.
+	type targetConfig struct {
+		target string
+		config notification.Config
+	}
+	var configs []targetConfig
+	for _, queue := range configuration.QueueConfigs {
+		configs = append(configs, targetConfig{target: queue.Queue, config: queue.Config})
+	}
+	for _, topic := range configuration.TopicConfigs {
+		configs = append(configs, targetConfig{target: topic.Topic, config: topic.Config})
+	}
+	for _, lambda := range configuration.LambdaConfigs {
+		configs = append(configs, targetConfig{target: lambda.Lambda, config: lambda.Config})
+	}
+	var notifications []*BucketNotification
+	for _, typed := range configs {
+		config := typed.config
+		n := &BucketNotification{Target: typed.target}
+		for _, event := range config.Events {
+			n.Events = append(n.Events, string(event))
+		}
+		if config.Filter != nil {
+			for _, rule := range config.Filter.S3Key.FilterRules {
+				switch rule.Name {
+				case "prefix":
+					n.Prefix = rule.Value
+				case "suffix":
+					n.Suffix = rule.Value
+				}
+			}
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications
+}
+
+// provisionBuckets creates the declared and claimed buckets on the local
+// server, and applies the lifecycle rules of the existing ones.
+func (s *Runtime) provisionBuckets(ctx context.Context) error {
+	client, err := s.client()
+	if err != nil {
+		return s.Wool.Wrapf(err, "cannot create minio client")
+	}
+	for _, bucket := range s.bucketSettings() {
+		exists, err := client.BucketExists(ctx, bucket.Name)
+		if err != nil {
+			return s.Wool.Wrapf(err, "cannot check bucket %s", bucket.Name)
+		}
+		if exists {
+			changed, err := applyLifecycle(ctx, client, bucket)
+			if err != nil {
+				return s.Wool.Wrapf(err, "cannot apply the lifecycle of bucket %s", bucket.Name)
+			}
+			if changed {
+				s.Wool.Info("applied the lifecycle rules", wool.Field("bucket", bucket.Name), wool.Field("rules", summary(bucket.Lifecycle)))
+			}
+			continue
+		}
+		err = client.MakeBucket(ctx, bucket.Name, minio.MakeBucketOptions{Region: s.region()})
+		if err != nil {
+			return s.Wool.Wrapf(err, "cannot create bucket %s", bucket.Name)
+		}
+		if err = configureNewBucket(ctx, client, bucket); err != nil {
+			return s.Wool.Wrapf(err, "cannot configure bucket %s", bucket.Name)
+		}
+		if seed := s.seedDirectory(bucket); seed != "" {
+			if _, err = seedBucket(ctx, client, bucket.Name, seed); err != nil {
+				return s.Wool.Wrapf(err, "cannot seed bucket %s", bucket.Name)
+			}
+		}
+		s.Wool.Debug("created bucket", wool.Field("bucket", bucket.Name))
+	}
+	return nil
+}
+
+// configureNewBucket applies the properties that differ from the defaults of a
+// new bucket.
+func configureNewBucket(ctx context.Context, client *minio.Client, bucket *BucketSettings) error {
+	drifts, err := compareBucket(bucket, &bucketState{Exists: true, Policy: policy.BucketPolicyNone})
+	if err != nil {
+		return err
+	}
+	for _, drift := range drifts {
+		if err = applyBucketProperty(ctx, client, bucket, drift.Property); err != nil {
+			return fmt.Errorf("cannot set %s: %w", drift.Property, err)
+		}
+	}
+	return nil
+}
+
+// emptyBucket deletes every object of a bucket, with all its versions and
+// bypassing governance retention. On the first failure it stops listing and
+// drains the removals still in flight.
+func emptyBucket(ctx context.Context, client *minio.Client, bucket string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	objects := make(chan minio.ObjectInfo)
+	listErr := make(chan error, 1)
+	go func() {
+		defer close(objects)
+		for object := range client.ListObjects(ctx, bucket, minio.ListObjectsOptions{WithVersions: true, Recursive: true}) {
+			if object.Err != nil {
+				listErr <- object.Err
+				return
+			}
+			select {
+			case objects <- object:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var removeErr error
+	for result := range client.RemoveObjects(ctx, bucket, objects, minio.RemoveObjectsOptions{GovernanceBypass: true}) {
+		if result.Err != nil && removeErr == nil {
+			removeErr = fmt.Errorf("cannot remove %s from %s: %w", result.ObjectName, bucket, result.Err)
+			cancel()
+		}
+	}
+	if removeErr != nil {
+		return removeErr
+	}
+	select {
+	case err := <-listErr:
+		return fmt.Errorf("cannot list %s: %w", bucket, err)
+	default:
+		return nil
+	}
+}
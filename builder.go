@@ -4,6 +4,9 @@ import (
 	"context"
 	"embed"
 	"fmt"
+	"maps"
+	"runtime/debug"
+	"slices"
 
 	v0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
 	"github.com/codefly-dev/core/resources"
@@ -20,6 +23,9 @@ import (
 type Builder struct {
 	services.BuilderServer
 	*Service
+
+	// answers to the creation questions, when communicated
+	answers map[string]string
 }
 
 // deploymentTemplateParameters carries the plugin-specific values a restricted
@@ -28,6 +34,34 @@ type Builder struct {
 type deploymentTemplateParameters struct {
 	AccessKeyReference *builderv0.KubernetesSecretKeyReference
 	SecretKeyReference *builderv0.KubernetesSecretKeyReference
+
+	Region      string
+	Console     bool
+	Ephemeral   bool
+	StorageSize string
+	Bootstrap   *bootstrapParameters
+	// AuditWebhook is the URL of the audit webhook, if any.
+	AuditWebhook string
+	// ImagePullSecret is only a reference to an externally managed Secret.
+	ImagePullSecret string
+	// Consumers are the Secrets holding the keys of each consumer user, for
+	// the deployment of the consumer alone. Restricted renders have none.
+	Consumers []*consumerSecret
+}
+
+// consumerSecret holds the keys of the MinIO user of one consumer.
+type consumerSecret struct {
+	Consumer string
+	User     string
+	Data     map[string]string
+}
+
+func consumerSecrets(consumers []*consumerCredentials) []*consumerSecret {
+	var secrets []*consumerSecret
+	for _, consumer := range consumers {
+		secrets = append(secrets, &consumerSecret{Consumer: consumer.Consumer, User: consumer.AccessKey, Data: consumer.environment()})
+	}
+	return secrets
 }
 
 func NewBuilder() *Builder {
@@ -39,20 +73,37 @@ func NewBuilder() *Builder {
 func (s *Builder) Load(ctx context.Context, req *builderv0.LoadRequest) (*builderv0.LoadResponse, error) {
 	defer s.Wool.Catch()
 
-	return s.Builder.LoadService(ctx, req, services.BuilderLoad{
+	response, err := s.Builder.LoadService(ctx, req, services.BuilderLoad{
 		Settings:         s.Settings,
 		Requirements:     requirements,
 		FactoryTemplates: factoryFS,
 		ResolveEndpoints: func(ctx context.Context, endpoints []*v0.Endpoint) error {
-			endpoint, err := resources.FindTCPEndpoint(ctx, endpoints)
-			if err != nil {
+			if err := s.resolveEndpoints(ctx, endpoints); err != nil {
 				return err
 			}
-			s.TcpEndpoint = endpoint
-			s.Wool.Debug("endpoint", wool.Field("tcp", endpoint))
+			s.Wool.Debug("endpoint", wool.Field("tcp", s.TcpEndpoint), wool.Field("console", s.ConsoleEndpoint))
 			return nil
 		},
 	})
+	if err != nil {
+		return response, err
+	}
+	if _, err = s.release(); err != nil {
+		return s.Builder.LoadError(err)
+	}
+	if err = validateDeploymentFormat(s.Settings.DeploymentFormat); err != nil {
+		return s.Builder.LoadError(s.Wool.Wrapf(err, "invalid deployment format"))
+	}
+	if err = validateAuditLog(s.Settings.AuditLog); err != nil {
+		return s.Builder.LoadError(s.Wool.Wrapf(err, "invalid audit-log setting"))
+	}
+	if err = validateBucketLifecycles(s.Settings.Buckets); err != nil {
+		return s.Builder.LoadError(s.Wool.Wrapf(err, "invalid bucket setting"))
+	}
+	if err = s.loadBucketClaims(); err != nil {
+		return s.Builder.LoadError(err)
+	}
+	return response, nil
 }
 
 func (s *Builder) Init(ctx context.Context, req *builderv0.InitRequest) (*builderv0.InitResponse, error) {
@@ -61,29 +112,123 @@ func (s *Builder) Init(ctx context.Context, req *builderv0.InitRequest) (*builde
 	return s.Builder.InitResponse()
 }
 
+// Update migrates the settings written by former agent versions, and warns
+// when the local data needs a migration to be read by the pinned MinIO
+// release. What it changed is written to the update report file.
 func (s *Builder) Update(ctx context.Context, req *builderv0.UpdateRequest) (*builderv0.UpdateResponse, error) {
 	defer s.Wool.Catch()
 
+	report := &updateReport{}
+	var err error
+	report.Changes, err = s.migrateServiceFile()
+	if err != nil {
+		return nil, err
+	}
+	factoryChanges, err := s.migrateFactoryFiles()
+	if err != nil {
+		return nil, err
+	}
+	report.Changes = append(report.Changes, factoryChanges...)
+	report.Warnings, err = s.backendFormatWarnings()
+	if err != nil {
+		return nil, err
+	}
+	if err = writeReport(s.updateReportFile(), report); err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot write the update report")
+	}
 	return &builderv0.UpdateResponse{}, nil
 }
 
+// Sync compares the settings with the instance of the sync settings, and
+// reconciles the drift when asked to. The drift is written to the sync report
+// file.
 func (s *Builder) Sync(ctx context.Context, req *builderv0.SyncRequest) (*builderv0.SyncResponse, error) {
 	defer s.Wool.Catch()
 	ctx = s.Wool.Inject(ctx)
 
+	target, err := s.syncTarget()
+	if err != nil {
+		return s.Builder.SyncError(err)
+	}
+	if target == nil {
+		s.Wool.Debug("no instance to compare against: set sync.endpoint")
+		return s.Builder.SyncResponse()
+	}
+
+	drifts, err := s.detectDrift(ctx, target)
+	if err != nil {
+		return s.Builder.SyncError(err)
+	}
+	report := &syncReport{Endpoint: target.endpoint, Drifts: drifts}
+	for _, drift := range drifts {
+		s.Wool.Warn("drift", wool.Field("drift", drift.String()))
+	}
+	if len(drifts) > 0 && s.Settings.Sync.Reconcile {
+		if err = s.reconcile(ctx, target, drifts); err != nil {
+			return s.Builder.SyncError(err)
+		}
+		report.Reconciled = true
+	}
+	if report.Drifts == nil {
+		report.Drifts = []*Drift{}
+	}
+	if err = writeReport(s.syncReportFile(), report); err != nil {
+		return s.Builder.SyncError(s.Wool.Wrapf(err, "cannot write the sync report"))
+	}
+	s.Wool.Info("synced", wool.Field("drifts", len(drifts)), wool.Field("reconciled", report.Reconciled), wool.Field("report", s.syncReportFile()))
 	return s.Builder.SyncResponse()
 }
 
 func (s *Builder) Audit(ctx context.Context, req *builderv0.AuditRequest) (*builderv0.AuditResponse, error) {
 	defer s.Wool.Catch()
 	ctx = s.Wool.Inject(ctx)
-	return s.Builder.AuditContainer(ctx, req, image.FullName())
+	image, err := s.deploymentImage()
+	if err != nil {
+		return nil, err
+	}
+	findings, err := s.auditConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	response, err := s.Builder.AuditContainer(ctx, req, image.FullName())
+	if err != nil {
+		return nil, err
+	}
+	if response == nil {
+		response = &builderv0.AuditResponse{}
+	}
+	for _, finding := range findings {
+		s.Wool.Debug("configuration finding", wool.Field("finding", finding))
+		response.Findings = append(response.Findings, finding.audit())
+	}
+	return response, nil
 }
 
 func (s *Builder) SBOM(ctx context.Context, _ *builderv0.SBOMRequest) (*builderv0.SBOMResponse, error) {
 	defer s.Wool.Catch()
 	ctx = s.Wool.Inject(ctx)
-	return s.Builder.SBOMContainer(ctx, image.FullName())
+	image, err := s.deploymentImage()
+	if err != nil {
+		return nil, err
+	}
+	response, err := s.Builder.SBOMContainer(ctx, image.FullName())
+	if err != nil {
+		return nil, err
+	}
+	if response == nil {
+		response = &builderv0.SBOMResponse{}
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil, s.Wool.NewError("agent binary carries no module information")
+	}
+	modules := agentModules(info)
+	response.Content, response.Format, err = mergeSBOM(response.Content, modules)
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot merge the agent modules into the SBOM")
+	}
+	s.Wool.Debug("merged agent modules into the SBOM", wool.Field("modules", len(modules)), wool.Field("format", response.Format))
+	return response, nil
 }
 
 func (s *Builder) Build(ctx context.Context, req *builderv0.BuildRequest) (*builderv0.BuildResponse, error) {
@@ -94,7 +239,15 @@ func (s *Builder) Build(ctx context.Context, req *builderv0.BuildRequest) (*buil
 
 func (s *Builder) Deploy(ctx context.Context, req *builderv0.DeploymentRequest) (*builderv0.DeploymentResponse, error) {
 	defer s.Wool.Catch()
+	image, err := s.deploymentImage()
+	if err != nil {
+		return nil, err
+	}
+	// The runtime runs the same release, see runtimeImage.
 	s.Base.SetDockerImage(image)
+	if s.Settings.DeploymentFormat == HelmFormat {
+		return s.deployHelm(ctx, req, image)
+	}
 
 	parameters := &deploymentTemplateParameters{}
 	var restrictedConfiguration *v0.Configuration
@@ -131,15 +284,71 @@ func (s *Builder) prepareDeployment(
 	deployment *services.KustomizeDeploymentContext,
 	parameters *deploymentTemplateParameters,
 ) (*v0.Configuration, error) {
-	req := deployment.Request
+	restricted := services.IsRestrictedOutputProfile(deployment.Profile)
+	configuration, err := s.prepareParameters(ctx, deployment.Request, deployment.Kubernetes.GetSecretReferences(), restricted, parameters)
+	if err != nil || restricted {
+		return configuration, err
+	}
+	deployment.AddSecrets(
+		resources.Env(AccessKeyField.EnvironmentKey(), s.accessKey),
+		resources.Env(SecretKeyField.EnvironmentKey(), s.secretKey),
+	)
+	if bootstrap := parameters.Bootstrap; bootstrap != nil {
+		for _, variable := range slices.Sorted(maps.Keys(bootstrap.Users)) {
+			deployment.AddSecrets(resources.Env(variable, bootstrap.Users[variable]))
+		}
+	}
+	return configuration, nil
+}
+
+// prepareParameters fills the parameters shared by every output format and
+// returns the connection configuration. Restricted renders only get the
+// Secret references, the others load the credentials.
+func (s *Builder) prepareParameters(
+	ctx context.Context,
+	req *builderv0.DeploymentRequest,
+	references map[string]*builderv0.KubernetesSecretKeyReference,
+	restricted bool,
+	parameters *deploymentTemplateParameters,
+) (*v0.Configuration, error) {
+	s.NetworkMappings = req.GetNetworkMappings()
+	s.secure = s.Settings.TLS
+	parameters.Region = s.region()
+	parameters.Console = s.ConsoleEndpoint != nil
+	parameters.Ephemeral = s.Settings.Ephemeral
+	parameters.StorageSize = s.Settings.StorageSize
+	parameters.ImagePullSecret = s.Settings.ImagePullSecret
+	if err := ValidateBucketClaims(s.claims); err != nil {
+		return nil, err
+	}
+	// The consumer keys derive from the root secret key.
+	if !restricted {
+		s.environment = req.GetEnvironment().GetName()
+		if err := s.LoadConfiguration(ctx, req.GetConfiguration()); err != nil {
+			return nil, err
+		}
+		consumers, err := s.consumerCredentials()
+		if err != nil {
+			return nil, err
+		}
+		parameters.Consumers = consumerSecrets(consumers)
+	}
+	bootstrap, err := s.bootstrapParameters(!restricted)
+	if err != nil {
+		return nil, err
+	}
+	parameters.Bootstrap = bootstrap
+	mappings := append(append([]*v0.NetworkMapping{}, req.GetNetworkMappings()...), req.GetDependenciesNetworkMappings()...)
+	if parameters.AuditWebhook, err = s.auditWebhookParameter(ctx, mappings); err != nil {
+		return nil, err
+	}
 	instance, err := resources.FindNetworkInstanceInNetworkMappings(ctx, req.GetNetworkMappings(), s.TcpEndpoint, resources.NewContainerNetworkAccess())
 	if err != nil {
 		return nil, err
 	}
-	if services.IsRestrictedOutputProfile(deployment.Profile) {
-		accessKeyEnv := resources.ServiceSecretConfigurationKeyFromUnique(s.Unique(), "minio", "MINIO_ACCESS_KEY")
-		secretKeyEnv := resources.ServiceSecretConfigurationKeyFromUnique(s.Unique(), "minio", "MINIO_SECRET_KEY")
-		references := deployment.Kubernetes.GetSecretReferences()
+	if restricted {
+		accessKeyEnv := resources.ServiceSecretConfigurationKeyFromUnique(s.Unique(), ConfigurationName, AccessKeyField.EnvironmentKey())
+		secretKeyEnv := resources.ServiceSecretConfigurationKeyFromUnique(s.Unique(), ConfigurationName, SecretKeyField.EnvironmentKey())
 		accessKeyReference := references[accessKeyEnv]
 		secretKeyReference := references[secretKeyEnv]
 		if accessKeyReference == nil || secretKeyReference == nil {
@@ -150,37 +359,54 @@ func (s *Builder) prepareDeployment(
 		}
 		parameters.AccessKeyReference = accessKeyReference
 		parameters.SecretKeyReference = secretKeyReference
-		return s.restrictedCredentialsConfiguration(instance), nil
-	}
-	if err = s.LoadConfiguration(ctx, req.GetConfiguration()); err != nil {
-		return nil, err
+		return s.restrictedCredentialsConfiguration(ctx, instance)
 	}
-	deployment.AddSecrets(
-		resources.Env("MINIO_ACCESS_KEY", s.accessKey),
-		resources.Env("MINIO_SECRET_KEY", s.secretKey),
-	)
 	return s.CreateCredentialsConfiguration(ctx, req.GetConfiguration(), instance)
 }
 
 func (s *Builder) Options() []*agentv0.Question {
-	return []*agentv0.Question{}
+	return creationOptions()
 }
 
 func (s *Builder) Communicate(stream builderv0.Builder_CommunicateServer) error {
 	asker := communicate.NewQuestionAsker(stream)
-	_, err := asker.RunSequence(s.Options())
-	return err
+	answers, err := asker.RunSequence(s.Options())
+	if err != nil {
+		return err
+	}
+	s.answers = communicatedAnswers(answers)
+	return nil
 }
 
 type create struct {
+	*Settings
+
+	AccessKey string
+	SecretKey string
 }
 
 func (s *Builder) Create(ctx context.Context, req *builderv0.CreateRequest) (*builderv0.CreateResponse, error) {
 	defer s.Wool.Catch()
 
-	c := create{}
+	answers := creationAnswers(s.answers, settingsAnswers(s.Settings))
+	s.Wool.Debug("creating", wool.Field("answers", answers))
 
-	err := s.Templates(ctx, c, services.WithFactory(factoryFS))
+	err := applyCreationAnswers(s.Settings, answers)
+	if err != nil {
+		return s.Builder.CreateErrorf(err, "invalid answers")
+	}
+
+	c := create{Settings: s.Settings}
+	c.AccessKey, err = generateSecret(8)
+	if err != nil {
+		return s.Builder.CreateErrorf(err, "cannot generate access key")
+	}
+	c.SecretKey, err = generateSecret(20)
+	if err != nil {
+		return s.Builder.CreateErrorf(err, "cannot generate secret key")
+	}
+
+	err = s.Templates(ctx, c, services.WithFactory(factoryFS))
 	if err != nil {
 		return s.Builder.CreateError(err)
 	}
@@ -202,7 +428,18 @@ func (s *Builder) CreateEndpoints(ctx context.Context) error {
 	}
 	endpoint := s.Base.BaseEndpoint(standards.TCP)
 	s.TcpEndpoint, err = resources.NewAPI(ctx, endpoint, resources.ToTCPAPI(tcp))
+	if err != nil {
+		return s.Wool.Wrapf(err, "cannot create tcp endpoint")
+	}
 	s.Endpoints = []*v0.Endpoint{s.TcpEndpoint}
+	if s.Settings.Console {
+		console := s.Base.BaseEndpoint(ConsoleEndpointName)
+		s.ConsoleEndpoint, err = resources.NewAPI(ctx, console, resources.ToTCPAPI(tcp))
+		if err != nil {
+			return s.Wool.Wrapf(err, "cannot create console endpoint")
+		}
+		s.Endpoints = append(s.Endpoints, s.ConsoleEndpoint)
+	}
 	return nil
 }
 
@@ -214,3 +451,6 @@ var builderFS embed.FS
 
 //go:embed templates/deployment
 var deploymentFS embed.FS
+
+//go:embed all:templates/helm
+var helmFS embed.FS
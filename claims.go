@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+	"gopkg.in/yaml.v3"
+)
+
+// BucketClaimsFile is the file a dependent service adds next to its
+// service.codefly.yaml to declare the buckets it needs from a MinIO service it
+// depends on:
+//
+//	service: storage/minio
+//	buckets:
+//	  - name: uploads
+//	    access: read-write
+//	    prefix: avatars/
+const BucketClaimsFile = "minio.claims.yaml"
+
+// Access levels a consumer can claim on a bucket.
+const (
+	ReadOnly  = "read-only"
+	ReadWrite = "read-write"
+)
+
+// BucketClaim is the access one consumer needs on a bucket, optionally
+// restricted to a prefix.
+type BucketClaim struct {
+	Name   string `yaml:"name"`
+	Access string `yaml:"access,omitempty"`
+	Prefix string `yaml:"prefix,omitempty"`
+}
+
+type bucketClaimsDeclaration struct {
+	Service string         `yaml:"service"`
+	Buckets []*BucketClaim `yaml:"buckets"`
+}
+
+// ConsumerClaims are the bucket claims declared by one dependent service. A
+// dependent without claims file has no claims.
+type ConsumerClaims struct {
+	// Consumer is the unique of the dependent service, e.g. "backend/api".
+	Consumer string
+	Claims   []*BucketClaim
+}
+
+// serviceDeclaration is the part of a service.codefly.yaml the claims need.
+type serviceDeclaration struct {
+	Name         string               `yaml:"name"`
+	Dependencies []*serviceDependency `yaml:"service-dependencies"`
+}
+
+type serviceDependency struct {
+	Name   string `yaml:"name"`
+	Module string `yaml:"module"`
+}
+
+// skippedDirectories never hold a service.
+var skippedDirectories = map[string]bool{"node_modules": true, "vendor": true}
+
+// LoadBucketClaims finds the services of the workspace that depend on the
+// MinIO service unique and reads their claims. A service that claims buckets
+// without depending on the service fails loading, as do invalid declarations;
+// conflicts between services are reported by ValidateBucketClaims.
+func LoadBucketClaims(workspace string, unique string) ([]*ConsumerClaims, error) {
+	if workspace == "" {
+		return nil, nil
+	}
+	var consumers []*ConsumerClaims
+	err := filepath.WalkDir(workspace, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if path != workspace && (strings.HasPrefix(entry.Name(), ".") || skippedDirectories[entry.Name()]) {
+			return filepath.SkipDir
+		}
+		service, err := readServiceDeclaration(path)
+		if err != nil || service == nil {
+			return err
+		}
+		consumer, err := dependentClaims(workspace, path, service, unique)
+		if err != nil {
+			return err
+		}
+		if consumer != nil {
+			consumers = append(consumers, consumer)
+		}
+		// The sources of a service hold no other service.
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(consumers, func(i, j int) bool { return consumers[i].Consumer < consumers[j].Consumer })
+	return consumers, nil
+}
+
+// dependentClaims returns the claims of the service in dir, nil when it does
+// not depend on unique.
+func dependentClaims(workspace string, dir string, service *serviceDeclaration, unique string) (*ConsumerClaims, error) {
+	module, name, _ := strings.Cut(unique, "/")
+	consumerModule, err := moduleName(workspace, dir)
+	if err != nil {
+		return nil, err
+	}
+	if consumerModule == "" {
+		// A single module workspace
+		consumerModule = module
+	}
+	consumer := consumerModule + "/" + service.Name
+	if consumer == unique {
+		return nil, nil
+	}
+	dependent := false
+	for _, dependency := range service.Dependencies {
+		dependencyModule := dependency.Module
+		if dependencyModule == "" {
+			dependencyModule = consumerModule
+		}
+		if dependency.Name == name && dependencyModule == module {
+			dependent = true
+		}
+	}
+	var claims []*BucketClaim
+	file := filepath.Join(dir, BucketClaimsFile)
+	if _, err = os.Stat(file); err == nil {
+		declaration, err := readBucketClaims(file)
+		if err != nil {
+			return nil, err
+		}
+		if declaration.Service == unique {
+			if !dependent {
+				return nil, fmt.Errorf("bucket claims %s: %s claims buckets of %s but does not depend on it", file, consumer, unique)
+			}
+			claims = declaration.Buckets
+		}
+	}
+	if !dependent {
+		return nil, nil
+	}
+	return &ConsumerClaims{Consumer: consumer, Claims: claims}, nil
+}
+
+// readServiceDeclaration is nil when dir holds no service.
+func readServiceDeclaration(dir string) (*serviceDeclaration, error) {
+	file := filepath.Join(dir, "service.codefly.yaml")
+	content, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var service serviceDeclaration
+	if err = yaml.Unmarshal(content, &service); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %w", file, err)
+	}
+	if service.Name == "" {
+		return nil, fmt.Errorf("%s: missing service name", file)
+	}
+	return &service, nil
+}
+
+// moduleName is the name in the closest module.codefly.yaml above dir, empty
+// when there is none in the workspace.
+func moduleName(workspace string, dir string) (string, error) {
+	for ; ; dir = filepath.Dir(dir) {
+		content, err := os.ReadFile(filepath.Join(dir, "module.codefly.yaml"))
+		if err == nil {
+			var module struct {
+				Name string `yaml:"name"`
+			}
+			if err = yaml.Unmarshal(content, &module); err != nil {
+				return "", fmt.Errorf("cannot parse the module of %s: %w", dir, err)
+			}
+			return module.Name, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if dir == workspace || dir == filepath.Dir(dir) {
+			return "", nil
+		}
+	}
+}
+
+func readBucketClaims(path string) (*bucketClaimsDeclaration, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var declaration bucketClaimsDeclaration
+	if err = yaml.Unmarshal(content, &declaration); err != nil {
+		return nil, fmt.Errorf("cannot parse bucket claims %s: %w", path, err)
+	}
+	for _, claim := range declaration.Buckets {
+		if err = s3utils.CheckValidBucketNameStrict(claim.Name); err != nil {
+			return nil, fmt.Errorf("bucket claims %s: invalid bucket name %q: %w", path, claim.Name, err)
+		}
+		if claim.Access == "" {
+			claim.Access = ReadOnly
+		}
+		if claim.Access != ReadOnly && claim.Access != ReadWrite {
+			return nil, fmt.Errorf("bucket claims %s: bucket %s: access must be %s or %s, got %q", path, claim.Name, ReadOnly, ReadWrite, claim.Access)
+		}
+	}
+	return &declaration, nil
+}
+
+// ValidateBucketClaims rejects claims of different services that both write
+// to overlapping prefixes of the same bucket: neither could rely on owning
+// its objects.
+func ValidateBucketClaims(consumers []*ConsumerClaims) error {
+	type writer struct {
+		consumer string
+		prefix   string
+	}
+	writers := make(map[string][]writer)
+	for _, consumer := range consumers {
+		for _, claim := range consumer.Claims {
+			if claim.Access != ReadWrite {
+				continue
+			}
+			for _, other := range writers[claim.Name] {
+				if other.consumer == consumer.Consumer || !prefixesOverlap(other.prefix, claim.Prefix) {
+					continue
+				}
+				return fmt.Errorf("conflicting bucket claims on %s: %s and %s both claim %s access to overlapping prefixes %q and %q",
+					claim.Name, other.consumer, consumer.Consumer, ReadWrite, other.prefix, claim.Prefix)
+			}
+			writers[claim.Name] = append(writers[claim.Name], writer{consumer: consumer.Consumer, prefix: claim.Prefix})
+		}
+	}
+	return nil
+}
+
+func prefixesOverlap(a, b string) bool {
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+// claimedBucketNames lists the buckets claimed by any consumer.
+func claimedBucketNames(consumers []*ConsumerClaims) []string {
+	var names []string
+	for _, consumer := range consumers {
+		for _, claim := range consumer.Claims {
+			names = append(names, claim.Name)
+		}
+	}
+	return names
+}
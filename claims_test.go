@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadBucketClaimsAggregatesDependentServices(t *testing.T) {
+	workspace := t.TempDir()
+	writeService(t, workspace, "storage/minio")
+	writeService(t, workspace, "frontend/web", "storage/minio")
+	writeService(t, workspace, "backend/api", "storage/minio")
+	writeBucketClaims(t, workspace, "backend/api", `
+service: storage/minio
+buckets:
+  - name: uploads
+    access: read-write
+    prefix: avatars/
+  - name: exports
+`)
+	writeService(t, workspace, "backend/worker", "storage/minio")
+	writeBucketClaims(t, workspace, "backend/worker", `
+service: storage/minio
+buckets:
+  - name: uploads
+    access: read-write
+    prefix: thumbnails/
+`)
+	writeService(t, workspace, "backend/other", "storage/minio", "storage/another")
+	writeBucketClaims(t, workspace, "backend/other", `
+service: storage/another
+buckets:
+  - name: ignored
+`)
+
+	consumers, err := LoadBucketClaims(workspace, "storage/minio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, consumer := range consumers {
+		names = append(names, consumer.Consumer)
+	}
+	if strings.Join(names, ",") != "backend/api,backend/other,backend/worker,frontend/web" {
+		t.Fatalf("consumers = %v", names)
+	}
+	if root := rootKeyConsumers(consumers); len(root) != 0 {
+		t.Errorf("root keys exported to %v next to scoped users", root)
+	}
+	if root := rootKeyConsumers([]*ConsumerClaims{consumers[1], consumers[3]}); strings.Join(root, ",") != "backend/other,frontend/web" {
+		t.Errorf("root keys exported to %v", root)
+	}
+	if access := consumers[0].Claims[1].Access; access != ReadOnly {
+		t.Errorf("default access = %q, want %q", access, ReadOnly)
+	}
+	if err = ValidateBucketClaims(consumers); err != nil {
+		t.Fatalf("disjoint prefixes conflict: %v", err)
+	}
+}
+
+func TestValidateBucketClaimsNamesBothServices(t *testing.T) {
+	err := ValidateBucketClaims([]*ConsumerClaims{
+		{Consumer: "backend/api", Claims: []*BucketClaim{{Name: "uploads", Access: ReadWrite, Prefix: "avatars/"}}},
+		{Consumer: "backend/reader", Claims: []*BucketClaim{{Name: "uploads", Access: ReadOnly}}},
+		{Consumer: "backend/worker", Claims: []*BucketClaim{{Name: "uploads", Access: ReadWrite}}},
+	})
+	if err == nil {
+		t.Fatal("expected overlapping writers to conflict")
+	}
+	for _, expected := range []string{"uploads", "backend/api", "backend/worker"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("error %q does not name %s", err, expected)
+		}
+	}
+	if strings.Contains(err.Error(), "backend/reader") {
+		t.Errorf("readers never conflict: %v", err)
+	}
+}
+
+func TestLoadBucketClaimsRejectsInvalidAccess(t *testing.T) {
+	workspace := t.TempDir()
+	writeService(t, workspace, "backend/api", "storage/minio")
+	writeBucketClaims(t, workspace, "backend/api", `
+service: storage/minio
+buckets:
+  - name: uploads
+    access: admin
+`)
+	_, err := LoadBucketClaims(workspace, "storage/minio")
+	if err == nil || !strings.Contains(err.Error(), "access must be") {
+		t.Fatalf("error = %v", err)
+	}
+}
+
+func TestLoadBucketClaimsRejectsServicesNotDependingOnIt(t *testing.T) {
+	workspace := t.TempDir()
+	writeService(t, workspace, "backend/api", "storage/another")
+	writeBucketClaims(t, workspace, "backend/api", `
+service: storage/minio
+buckets:
+  - name: uploads
+`)
+	_, err := LoadBucketClaims(workspace, "storage/minio")
+	if err == nil || !strings.Contains(err.Error(), "backend/api claims buckets of storage/minio but does not depend on it") {
+		t.Fatalf("error = %v", err)
+	}
+}
+
+func TestLoadBucketClaimsSkipsDependencies(t *testing.T) {
+	workspace := t.TempDir()
+	writeService(t, workspace, "backend/api", "storage/minio")
+	vendored := filepath.Join(workspace, "modules", "backend", "services", "web", "node_modules", "sdk")
+	if err := os.MkdirAll(vendored, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// Not a service: a parse error would fail loading.
+	if err := os.WriteFile(filepath.Join(vendored, "service.codefly.yaml"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	consumers, err := LoadBucketClaims(workspace, "storage/minio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(consumers) != 1 || consumers[0].Consumer != "backend/api" {
+		t.Fatalf("consumers = %+v", consumers)
+	}
+}
+
+func TestConsumerPoliciesScopeClaims(t *testing.T) {
+	policies, err := consumerPolicies([]*ConsumerClaims{
+		{Consumer: "backend/api", Claims: []*BucketClaim{{Name: "uploads", Access: ReadOnly, Prefix: "avatars/"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 1 || policies[0].Name != "backend-api-buckets" || policies[0].User != "backend-api" {
+		t.Fatalf("policies = %+v", policies)
+	}
+	var document policyDocument
+	if err = json.Unmarshal([]byte(policies[0].Document), &document); err != nil {
+		t.Fatal(err)
+	}
+	objects := document.Statement[1]
+	if objects.Resource[0] != "arn:aws:s3:::uploads/avatars/*" {
+		t.Errorf("object resource = %v", objects.Resource)
+	}
+	for _, action := range objects.Action {
+		if action == "s3:PutObject" || action == "s3:DeleteObject" {
+			t.Errorf("read-only claim granted %s", action)
+		}
+	}
+}
+
+func TestConsumerUsers(t *testing.T) {
+	if user := ConsumerUser("backend/api"); user != "backend-api" {
+		t.Errorf("user = %q", user)
+	}
+	long := ConsumerUser("payments/reconciliation-worker")
+	if len(long) > maxUserLength || !strings.HasPrefix(long, "payments-reco") || long == ConsumerUser("payments/reconciliation-other") {
+		t.Errorf("user of a long unique = %q", long)
+	}
+	secretKey := consumerSecretKey("root-secret", "backend/api")
+	if len(secretKey) != 40 || secretKey != consumerSecretKey("root-secret", "backend/api") {
+		t.Errorf("secret key = %q", secretKey)
+	}
+	if secretKey == consumerSecretKey("root-secret", "backend/worker") || secretKey == consumerSecretKey("other-secret", "backend/api") {
+		t.Error("secret keys are shared")
+	}
+}
+
+// writeService writes the service of a unique in its module, depending on
+// the services of the other uniques.
+func writeService(t *testing.T, workspace string, unique string, dependencies ...string) {
+	t.Helper()
+	module, name, _ := strings.Cut(unique, "/")
+	directory := filepath.Join(workspace, "modules", module, "services", name)
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err := os.WriteFile(filepath.Join(workspace, "modules", module, "module.codefly.yaml"), []byte("name: "+module+"\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "name: " + name + "\nservice-dependencies:\n"
+	for _, dependency := range dependencies {
+		dependencyModule, dependencyName, _ := strings.Cut(dependency, "/")
+		content += "  - name: " + dependencyName + "\n    module: " + dependencyModule + "\n"
+	}
+	if err = os.WriteFile(filepath.Join(directory, "service.codefly.yaml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeBucketClaims(t *testing.T, workspace string, unique string, content string) {
+	t.Helper()
+	module, name, _ := strings.Cut(unique, "/")
+	directory := filepath.Join(workspace, "modules", module, "services", name)
+	if err := os.WriteFile(filepath.Join(directory, BucketClaimsFile), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
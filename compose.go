@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/codefly-dev/core/resources"
+	"github.com/codefly-dev/core/wool"
+)
+
+// containerDefinition is the container the local runtime runs. Init applies
+// it to the Docker runner, and exports the same definition to compose.
+type containerDefinition struct {
+	Image       *resources.DockerImage
+	Command     []string
+	Ports       []*portMapping
+	Environment []*resources.EnvironmentVariable
+	Mounts      []*mountPoint
+}
+
+type portMapping struct {
+	Host      uint16
+	Container uint16
+}
+
+type mountPoint struct {
+	Source string
+	Target string
+}
+
+// secretEnvironment tells the variables kept out of the compose file.
+func secretEnvironment(key string) bool {
+	return key == AccessKeyField.EnvironmentKey() || key == SecretKeyField.EnvironmentKey() ||
+		key == "MINIO_AUDIT_WEBHOOK_AUTH_TOKEN_"+auditWebhookTarget
+}
+
+// composeService is the docker-compose service of a container definition.
+type composeService struct {
+	Image       string            `yaml:"image"`
+	Command     []string          `yaml:"command"`
+	Ports       []string          `yaml:"ports"`
+	EnvFile     []string          `yaml:"env_file,omitempty"`
+	Environment map[string]string `yaml:"environment,omitempty"`
+	Volumes     []string          `yaml:"volumes,omitempty"`
+	Healthcheck *composeHealth    `yaml:"healthcheck"`
+}
+
+type composeHealth struct {
+	Test     []string `yaml:"test"`
+	Interval string   `yaml:"interval"`
+	Timeout  string   `yaml:"timeout"`
+	Retries  int      `yaml:"retries"`
+}
+
+// composeFragment renders the definition as a compose file with one service.
+// Secret variables are written to envFile instead, returned as KEY=VALUE
+// lines.
+func composeFragment(name string, definition *containerDefinition, envFile string) ([]byte, []byte, error) {
+	service := &composeService{
+		Image:   definition.Image.FullName(),
+		Command: definition.Command,
+		Healthcheck: &composeHealth{
+			Test:     []string{"CMD", "mc", "ready", "local"},
+			Interval: "5s",
+			Timeout:  "5s",
+			Retries:  12,
+		},
+	}
+	for _, port := range definition.Ports {
+		service.Ports = append(service.Ports, fmt.Sprintf("%d:%d", port.Host, port.Container))
+	}
+	for _, mount := range definition.Mounts {
+		service.Volumes = append(service.Volumes, fmt.Sprintf("%s:%s", mount.Source, mount.Target))
+	}
+	var secrets strings.Builder
+	for _, env := range definition.Environment {
+		value := fmt.Sprint(env.Value)
+		if secretEnvironment(env.Key) {
+			fmt.Fprintf(&secrets, "%s=%s\n", env.Key, value)
+			continue
+		}
+		if service.Environment == nil {
+			service.Environment = make(map[string]string)
+		}
+		service.Environment[env.Key] = value
+	}
+	if secrets.Len() > 0 {
+		service.EnvFile = []string{envFile}
+	}
+	fragment, err := yaml.Marshal(map[string]any{
+		"services": map[string]*composeService{name: service},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return fragment, []byte(secrets.String()), nil
+}
+
+// composeExport is where a compose service was exported.
+type composeExport struct {
+	File    string `json:"file"`
+	EnvFile string `json:"env-file,omitempty"`
+}
+
+// exportCompose writes the container definition of Init to a file as a
+// docker-compose service. The credentials go to an env file next to it,
+// readable by the owner only. A relative file is in the workspace.
+func (s *Runtime) exportCompose(file string) (*composeExport, error) {
+	if s.definition == nil {
+		return nil, fmt.Errorf("no container definition: the service is not initialized")
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(s.Identity.WorkspacePath, file)
+	}
+	envFile := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)) + ".env"
+	name := resources.ToServiceWithCase(s.Identity).Name.DNSCase
+	fragment, secrets, err := composeFragment(name, s.definition, envFile)
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot render compose service")
+	}
+	if err = os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot create compose export directory")
+	}
+	if err = os.WriteFile(file, fragment, 0o644); err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot write compose service")
+	}
+	exported := &composeExport{File: file}
+	if len(secrets) > 0 {
+		exported.EnvFile = filepath.Join(filepath.Dir(file), envFile)
+		if err = os.WriteFile(exported.EnvFile, secrets, 0o600); err != nil {
+			return nil, s.Wool.Wrapf(err, "cannot write compose credentials")
+		}
+	}
+	s.Wool.Info("exported compose service", wool.Field("file", file))
+	return exported, nil
+}
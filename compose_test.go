@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/codefly-dev/core/resources"
+	"gopkg.in/yaml.v3"
+)
+
+func TestComposeFragmentKeepsCredentialsOut(t *testing.T) {
+	definition := &containerDefinition{
+		Image:   image,
+		Command: []string{"server", "/data", "--console-address", ":9001"},
+		Ports:   []*portMapping{{Host: 31000, Container: minioPort}, {Host: 31001, Container: consolePort}},
+		Environment: []*resources.EnvironmentVariable{
+			resources.Env(AccessKeyField.EnvironmentKey(), "minio"),
+			resources.Env(SecretKeyField.EnvironmentKey(), "generated-secret-key"),
+			resources.Env("MINIO_SITE_REGION", DefaultRegion),
+		},
+		Mounts: []*mountPoint{{Source: "/workspace/.codefly/data/backend/storage", Target: "/data"}},
+	}
+	fragment, secrets, err := composeFragment("storage", definition, "storage.env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(fragment), "generated-secret-key") {
+		t.Errorf("compose file holds the secret key:\n%s", fragment)
+	}
+	if string(secrets) != "MINIO_ACCESS_KEY=minio\nMINIO_SECRET_KEY=generated-secret-key\n" {
+		t.Errorf("env file = %q", secrets)
+	}
+
+	var compose struct {
+		Services map[string]*composeService `yaml:"services"`
+	}
+	if err = yaml.Unmarshal(fragment, &compose); err != nil {
+		t.Fatal(err)
+	}
+	service := compose.Services["storage"]
+	if service == nil {
+		t.Fatalf("compose = %s", fragment)
+	}
+	if len(service.Ports) != 2 || service.Ports[0] != "31000:9000" {
+		t.Errorf("ports = %v", service.Ports)
+	}
+	if len(service.Volumes) != 1 || service.Environment["MINIO_SITE_REGION"] != DefaultRegion || service.EnvFile[0] != "storage.env" {
+		t.Errorf("service = %+v", service)
+	}
+}
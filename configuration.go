@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+	agentv0 "github.com/codefly-dev/core/generated/go/codefly/services/agent/v0"
+)
+
+// ConfigurationName is the configuration information this agent reads from its
+// own configuration files and exports to its consumers.
+const ConfigurationName = "minio"
+
+// ConfigurationField describes one key of the minio configuration. Its Name is
+// the kebab-case spelling that is advertised and exported to consumers; the
+// environment key derived from it is the spelling used in the configuration
+// files, which is also the variable the MinIO server reads.
+type ConfigurationField struct {
+	Name        string
+	Description string
+	Secret      bool
+	// Input fields are read from the service configuration files; the others
+	// are derived by the agent and only exported.
+	Input    bool
+	Required bool
+	// Optional exported fields are left out when the agent has no value for them.
+	Optional bool
+	// Aliases are additional exported keys carrying the same value, so that
+	// standard SDKs pick up the connection without any glue code.
+	Aliases []string
+}
+
+var (
+	EndpointField = ConfigurationField{
+		Name:        "endpoint",
+		Description: "host:port of the S3 API",
+	}
+	URLField = ConfigurationField{
+		Name:        "url",
+		Description: "URL of the S3 API, with scheme",
+		Aliases:     []string{"AWS_ENDPOINT_URL_S3"},
+	}
+	RegionField = ConfigurationField{
+		Name:        "region",
+		Description: "region to sign requests for",
+		Aliases:     []string{"AWS_REGION", "AWS_DEFAULT_REGION"},
+	}
+	SecureField = ConfigurationField{
+		Name:        "secure",
+		Description: "true when the S3 API is served over TLS",
+	}
+	PathStyleField = ConfigurationField{
+		Name:        "path-style",
+		Description: "true when clients must use path-style bucket addressing",
+	}
+	ConsoleURLField = ConfigurationField{
+		Name:        "console-url",
+		Description: "URL of the MinIO web console, when exposed",
+		Optional:    true,
+	}
+	BucketsField = ConfigurationField{
+		Name:        "buckets",
+		Description: "comma-separated names of the declared buckets",
+	}
+	AccessKeyField = ConfigurationField{
+		Name:        "access-key",
+		Description: "root access key",
+		Secret:      true,
+		Input:       true,
+		Required:    true,
+		Aliases:     []string{"AWS_ACCESS_KEY_ID"},
+	}
+	SecretKeyField = ConfigurationField{
+		Name:        "secret-key",
+		Description: "root secret key",
+		Secret:      true,
+		Input:       true,
+		Required:    true,
+		Aliases:     []string{"AWS_SECRET_ACCESS_KEY"},
+	}
+	NamespacesURLField = ConfigurationField{
+		Name:        "namespaces-url",
+		Description: "URL test suites ask for isolated namespaces, local runtime with namespaces only",
+		Optional:    true,
+	}
+	NamespacesTokenField = ConfigurationField{
+		Name:        "namespaces-token",
+		Description: "bearer token of the namespaces URL",
+		Secret:      true,
+		Optional:    true,
+	}
+)
+
+// ConfigurationSchema is the single source of truth for the minio
+// configuration: the advertisement, the input validation, the exported keys
+// and the README are all derived from it.
+var ConfigurationSchema = []ConfigurationField{
+	EndpointField,
+	URLField,
+	RegionField,
+	SecureField,
+	PathStyleField,
+	ConsoleURLField,
+	BucketsField,
+	AccessKeyField,
+	SecretKeyField,
+	NamespacesURLField,
+	NamespacesTokenField,
+}
+
+// EnvironmentKey is the key of the field in the configuration files, e.g.
+// access-key is read from MINIO_ACCESS_KEY.
+func (f ConfigurationField) EnvironmentKey() string {
+	return "MINIO_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+}
+
+// File is the configuration file that holds the field in an environment.
+func (f ConfigurationField) File(environment string) string {
+	return configurationFile(environment, f.Secret)
+}
+
+func configurationFile(environment string, secret bool) string {
+	if environment == "" {
+		environment = "<environment>"
+	}
+	file := ConfigurationName + ".env"
+	if secret {
+		file = ConfigurationName + ".secret.env"
+	}
+	return path.Join("configurations", environment, file)
+}
+
+func inputFields() []ConfigurationField {
+	var fields []ConfigurationField
+	for _, field := range ConfigurationSchema {
+		if field.Input {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// advertisedConfiguration describes the minio configuration in the agent
+// information.
+func advertisedConfiguration() []*agentv0.ConfigurationValueDetail {
+	detail := &agentv0.ConfigurationValueDetail{
+		Name:        ConfigurationName,
+		Description: "minio connection and credentials",
+	}
+	for _, field := range ConfigurationSchema {
+		detail.Fields = append(detail.Fields, &agentv0.ConfigurationValueInformation{
+			Name:        field.Name,
+			Description: field.Description,
+		})
+		for _, alias := range field.Aliases {
+			detail.Fields = append(detail.Fields, &agentv0.ConfigurationValueInformation{
+				Name:        alias,
+				Description: fmt.Sprintf("alias of %s", field.Name),
+			})
+		}
+	}
+	return []*agentv0.ConfigurationValueDetail{detail}
+}
+
+// ParseConfiguration validates the minio information of a configuration
+// against the schema and returns the input values keyed by field name.
+// Unknown keys and missing required keys are all reported, each naming the
+// environment and the configuration file it belongs to.
+func ParseConfiguration(conf *basev0.Configuration, environment string) (map[string]string, error) {
+	known := make(map[string]ConfigurationField)
+	for _, field := range inputFields() {
+		known[field.EnvironmentKey()] = field
+	}
+	values := make(map[string]string)
+	var errs []error
+	for _, info := range conf.GetInfos() {
+		if info.GetName() != ConfigurationName {
+			continue
+		}
+		for _, value := range info.GetConfigurationValues() {
+			field, ok := known[value.GetKey()]
+			if !ok {
+				errs = append(errs, fmt.Errorf("environment %s: unknown key %s in %s (expected one of %s)",
+					environment, value.GetKey(), configurationFile(environment, value.GetSecret()), strings.Join(inputKeys(), ", ")))
+				continue
+			}
+			values[field.Name] = value.GetValue()
+		}
+	}
+	for _, field := range inputFields() {
+		if field.Required && values[field.Name] == "" {
+			errs = append(errs, fmt.Errorf("environment %s: missing required key %s in %s",
+				environment, field.EnvironmentKey(), field.File(environment)))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return values, nil
+}
+
+// readConfigurationFile reads a KEY=VALUE configuration file of the service as
+// the minio configuration information.
+func readConfigurationFile(file string) (*basev0.Configuration, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	secret := strings.HasSuffix(file, ".secret.env")
+	info := &basev0.ConfigurationInformation{Name: ConfigurationName}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s: expected KEY=VALUE, got %q", file, line)
+		}
+		info.ConfigurationValues = append(info.ConfigurationValues, &basev0.ConfigurationValue{
+			Key:    strings.TrimSpace(key),
+			Value:  strings.Trim(strings.TrimSpace(value), `"`),
+			Secret: secret,
+		})
+	}
+	return &basev0.Configuration{Infos: []*basev0.ConfigurationInformation{info}}, nil
+}
+
+func inputKeys() []string {
+	var keys []string
+	for _, field := range inputFields() {
+		keys = append(keys, field.EnvironmentKey())
+	}
+	return keys
+}
+
+// exportedConfigurationValues lists the values exported to consumers in schema
+// order, each followed by its aliases. Fields missing from values are left
+// out, as are empty optional ones. Secret values are only filled in when
+// withSecrets is set; otherwise they are exported as value-free references.
+func exportedConfigurationValues(values map[string]string, withSecrets bool) []*basev0.ConfigurationValue {
+	var exported []*basev0.ConfigurationValue
+	for _, field := range ConfigurationSchema {
+		value, ok := values[field.Name]
+		if !ok || field.Optional && value == "" {
+			continue
+		}
+		if field.Secret && !withSecrets {
+			value = ""
+		}
+		for _, key := range append([]string{field.Name}, field.Aliases...) {
+			exported = append(exported, &basev0.ConfigurationValue{Key: key, Value: value, Secret: field.Secret})
+		}
+	}
+	return exported
+}
+
+// withoutSecretValues copies a configuration with its secret values turned
+// into value-free references.
+func withoutSecretValues(conf *basev0.Configuration) *basev0.Configuration {
+	if conf == nil {
+		return nil
+	}
+	copied := &basev0.Configuration{Origin: conf.Origin, RuntimeContext: conf.RuntimeContext}
+	for _, info := range conf.Infos {
+		public := &basev0.ConfigurationInformation{Name: info.Name}
+		for _, value := range info.ConfigurationValues {
+			if value.Secret {
+				value = &basev0.ConfigurationValue{Key: value.Key, Secret: true}
+			}
+			public.ConfigurationValues = append(public.ConfigurationValues, value)
+		}
+		copied.Infos = append(copied.Infos, public)
+	}
+	return copied
+}
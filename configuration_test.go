@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+)
+
+func TestConfigurationSchemaDrivesEnvironmentKeys(t *testing.T) {
+	if got := AccessKeyField.EnvironmentKey(); got != "MINIO_ACCESS_KEY" {
+		t.Fatalf("access key environment key = %q", got)
+	}
+	if got := SecretKeyField.EnvironmentKey(); got != "MINIO_SECRET_KEY" {
+		t.Fatalf("secret key environment key = %q", got)
+	}
+	for _, field := range ConfigurationSchema {
+		if field.Required && !field.Input {
+			t.Errorf("%s is required but never read from the configuration files", field.Name)
+		}
+	}
+}
+
+func TestParseConfiguration(t *testing.T) {
+	values, err := ParseConfiguration(minioConfiguration(
+		&basev0.ConfigurationValue{Key: "MINIO_ACCESS_KEY", Value: "minio", Secret: true},
+		&basev0.ConfigurationValue{Key: "MINIO_SECRET_KEY", Value: "password", Secret: true},
+	), "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[AccessKeyField.Name] != "minio" || values[SecretKeyField.Name] != "password" {
+		t.Fatalf("parsed values = %v", values)
+	}
+}
+
+func TestParseConfigurationReportsUnknownAndMissingKeys(t *testing.T) {
+	_, err := ParseConfiguration(minioConfiguration(
+		&basev0.ConfigurationValue{Key: "MINIO_ACCESS_KEY", Value: "minio", Secret: true},
+		&basev0.ConfigurationValue{Key: "access-key", Value: "minio", Secret: true},
+	), "staging")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, expected := range []string{
+		"environment staging: unknown key access-key in configurations/staging/minio.secret.env",
+		"environment staging: missing required key MINIO_SECRET_KEY in configurations/staging/minio.secret.env",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("error %q does not contain %q", err, expected)
+		}
+	}
+}
+
+func TestExportedConfigurationValuesFollowSchema(t *testing.T) {
+	values := connection{
+		Address:   "localhost:9000",
+		Region:    DefaultRegion,
+		AccessKey: "minio",
+		SecretKey: "password",
+	}.values()
+	exported := make(map[string]*basev0.ConfigurationValue)
+	for _, value := range exportedConfigurationValues(values, false) {
+		exported[value.GetKey()] = value
+	}
+	for _, field := range ConfigurationSchema {
+		for _, key := range append([]string{field.Name}, field.Aliases...) {
+			value, ok := exported[key]
+			if field.Optional {
+				if ok {
+					t.Errorf("optional %s exported without a value", key)
+				}
+				continue
+			}
+			if !ok {
+				t.Errorf("%s not exported", key)
+				continue
+			}
+			if value.GetSecret() != field.Secret {
+				t.Errorf("%s secret = %v, want %v", key, value.GetSecret(), field.Secret)
+			}
+			if field.Secret && value.GetValue() != "" {
+				t.Errorf("value-free export leaked %s", key)
+			}
+		}
+	}
+}
+
+func minioConfiguration(values ...*basev0.ConfigurationValue) *basev0.Configuration {
+	return &basev0.Configuration{
+		Infos: []*basev0.ConfigurationInformation{
+			{Name: ConfigurationName, ConfigurationValues: values},
+		},
+	}
+}
+
+func TestWithoutSecretValues(t *testing.T) {
+	conf := &basev0.Configuration{Origin: "store/minio", Infos: []*basev0.ConfigurationInformation{{
+		Name: ConfigurationName,
+		ConfigurationValues: []*basev0.ConfigurationValue{
+			{Key: AccessKeyField.Name, Value: "minio", Secret: true},
+			{Key: "ENDPOINT", Value: "http://minio:9000"},
+		},
+	}}}
+	public := withoutSecretValues(conf)
+	values := public.GetInfos()[0].GetConfigurationValues()
+	if public.GetOrigin() != "store/minio" || len(values) != 2 {
+		t.Fatalf("configuration = %v", public)
+	}
+	if values[0].GetValue() != "" || !values[0].GetSecret() || values[1].GetValue() != "http://minio:9000" {
+		t.Errorf("values = %v", values)
+	}
+	if conf.Infos[0].ConfigurationValues[0].Value != "minio" {
+		t.Error("the original configuration was changed")
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+	"github.com/codefly-dev/core/resources"
+)
+
+// connection is everything a consumer needs to reach one network instance of
+// MinIO with a standard S3 SDK.
+type connection struct {
+	Address        string
+	ConsoleAddress string
+	Secure         bool
+	Region         string
+	Buckets        []string
+	AccessKey      string
+	SecretKey      string
+	// WithholdRootKeys leaves the root credentials out once consumers have
+	// users of their own.
+	WithholdRootKeys bool
+	// NamespacesURL and NamespacesToken reach the namespaces broker of the
+	// local runtime.
+	NamespacesURL   string
+	NamespacesToken string
+}
+
+// values maps the connection onto the configuration schema.
+func (c connection) values() map[string]string {
+	values := map[string]string{
+		EndpointField.Name: c.Address,
+		URLField.Name:      c.url(c.Address),
+		RegionField.Name:   c.Region,
+		SecureField.Name:   strconv.FormatBool(c.Secure),
+		// MinIO only serves virtual-host style requests when it is given a
+		// domain, which neither the runtime nor the deployment configure.
+		PathStyleField.Name: "true",
+		BucketsField.Name:   strings.Join(c.Buckets, ","),
+	}
+	if !c.WithholdRootKeys {
+		values[AccessKeyField.Name] = c.AccessKey
+		values[SecretKeyField.Name] = c.SecretKey
+	}
+	if c.ConsoleAddress != "" {
+		values[ConsoleURLField.Name] = c.url(c.ConsoleAddress)
+	}
+	if c.NamespacesURL != "" {
+		values[NamespacesURLField.Name] = c.NamespacesURL
+		values[NamespacesTokenField.Name] = c.NamespacesToken
+	}
+	return values
+}
+
+// consumerCredentials are the keys of the MinIO user of one consumer. They
+// are not part of the exported configuration, which every dependent gets:
+// each consumer is handed its own keys alone.
+type consumerCredentials struct {
+	Consumer  string
+	AccessKey string
+	SecretKey string
+}
+
+// environment maps the keys onto the variables of the root credentials and
+// their aliases, e.g. MINIO_ACCESS_KEY and AWS_ACCESS_KEY_ID.
+func (c *consumerCredentials) environment() map[string]string {
+	environment := make(map[string]string)
+	for field, value := range map[*ConfigurationField]string{&AccessKeyField: c.AccessKey, &SecretKeyField: c.SecretKey} {
+		environment[field.EnvironmentKey()] = value
+		for _, alias := range field.Aliases {
+			environment[alias] = value
+		}
+	}
+	return environment
+}
+
+// ConsumerConfigurationName names the credentials of a consumer, e.g.
+// minio-backend-api for backend/api.
+func ConsumerConfigurationName(consumer string) string {
+	return ConfigurationName + "-" + ConsumerUser(consumer)
+}
+
+// consumerCredentials derives the keys of every consumer with claims.
+func (s *Service) consumerCredentials() ([]*consumerCredentials, error) {
+	policies, err := consumerPolicies(s.claims)
+	if err != nil {
+		return nil, err
+	}
+	var consumers []*consumerCredentials
+	for _, policy := range policies {
+		consumers = append(consumers, &consumerCredentials{
+			Consumer:  policy.Consumer,
+			AccessKey: policy.User,
+			SecretKey: consumerSecretKey(s.secretKey, policy.Consumer),
+		})
+	}
+	return consumers, nil
+}
+
+// consumerCredentialsDirectory holds the env files the local runtime hands
+// the consumers their keys in.
+func (s *Service) consumerCredentialsDirectory() string {
+	return filepath.Join(s.Identity.WorkspacePath, ".codefly", "credentials", s.Identity.Module, s.Identity.Name)
+}
+
+// writeConsumerCredentials replaces the env files of the consumers, one
+// ConsumerConfigurationName.env per consumer, readable by the owner only.
+func writeConsumerCredentials(dir string, consumers []*consumerCredentials) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if len(consumers) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	for _, consumer := range consumers {
+		environment := consumer.environment()
+		var content strings.Builder
+		for _, key := range slices.Sorted(maps.Keys(environment)) {
+			content.WriteString(key + "=" + environment[key] + "\n")
+		}
+		file := filepath.Join(dir, ConsumerConfigurationName(consumer.Consumer)+".env")
+		if err := os.WriteFile(file, []byte(content.String()), 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c connection) url(address string) string {
+	if c.Secure {
+		return "https://" + address
+	}
+	return "http://" + address
+}
+
+// connection to a network instance of the S3 API. The console is looked up in
+// the network mappings with the same access as the instance.
+func (s *Service) connection(ctx context.Context, instance *basev0.NetworkInstance) (*connection, error) {
+	c := &connection{
+		Address:   instance.Address,
+		Secure:    s.secure,
+		Region:    s.region(),
+		Buckets:   s.bucketNames(),
+		AccessKey: s.accessKey,
+		SecretKey: s.secretKey,
+	}
+	policies, err := consumerPolicies(s.claims)
+	if err != nil {
+		return nil, err
+	}
+	c.WithholdRootKeys = len(policies) > 0
+	if s.ConsoleEndpoint != nil {
+		console, err := resources.FindNetworkInstanceInNetworkMappings(ctx, s.NetworkMappings, s.ConsoleEndpoint, instance.Access)
+		if err != nil {
+			return nil, err
+		}
+		if console != nil {
+			c.ConsoleAddress = console.Address
+		}
+	}
+	if s.namespaces != nil {
+		c.NamespacesURL = s.namespaces.URL(instance.Hostname)
+		c.NamespacesToken = s.namespaces.token
+	}
+	return c, nil
+}
+
+func (s *Service) region() string {
+	if s.Settings.Region == "" {
+		return DefaultRegion
+	}
+	return s.Settings.Region
+}
+
+// bucketNames lists the declared buckets followed by the ones only claimed by
+// consumers, without duplicates.
+func (s *Service) bucketNames() []string {
+	var names []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, bucket := range s.Settings.Buckets {
+		add(bucket.Name)
+	}
+	for _, name := range claimedBucketNames(s.claims) {
+		add(name)
+	}
+	return names
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConnectionValuesAreSDKReady(t *testing.T) {
+	values := connection{
+		Address:        "minio.example.com:9000",
+		ConsoleAddress: "minio.example.com:9001",
+		Secure:         true,
+		Region:         "eu-west-1",
+		Buckets:        []string{"uploads", "exports"},
+		AccessKey:      "minio",
+		SecretKey:      "password",
+	}.values()
+	for key, expected := range map[string]string{
+		EndpointField.Name:   "minio.example.com:9000",
+		URLField.Name:        "https://minio.example.com:9000",
+		RegionField.Name:     "eu-west-1",
+		SecureField.Name:     "true",
+		PathStyleField.Name:  "true",
+		ConsoleURLField.Name: "https://minio.example.com:9001",
+		BucketsField.Name:    "uploads,exports",
+	} {
+		if values[key] != expected {
+			t.Errorf("%s = %q, want %q", key, values[key], expected)
+		}
+	}
+
+	exported := make(map[string]string)
+	for _, value := range exportedConfigurationValues(values, true) {
+		exported[value.GetKey()] = value.GetValue()
+	}
+	for key, expected := range map[string]string{
+		"AWS_ACCESS_KEY_ID":     "minio",
+		"AWS_SECRET_ACCESS_KEY": "password",
+		"AWS_ENDPOINT_URL_S3":   "https://minio.example.com:9000",
+		"AWS_REGION":            "eu-west-1",
+		"AWS_DEFAULT_REGION":    "eu-west-1",
+	} {
+		if exported[key] != expected {
+			t.Errorf("%s = %q, want %q", key, exported[key], expected)
+		}
+	}
+}
+
+func TestConnectionWithoutConsoleOmitsConsoleURL(t *testing.T) {
+	values := connection{Address: "localhost:9000"}.values()
+	if values[URLField.Name] != "http://localhost:9000" {
+		t.Errorf("url = %q", values[URLField.Name])
+	}
+	for _, value := range exportedConfigurationValues(values, true) {
+		if value.GetKey() == ConsoleURLField.Name {
+			t.Fatalf("console url exported without a console: %+v", value)
+		}
+	}
+}
+
+func TestConnectionWithholdsRootKeys(t *testing.T) {
+	c := connection{
+		Address:          "localhost:9000",
+		AccessKey:        "minio",
+		SecretKey:        "password",
+		WithholdRootKeys: true,
+	}
+	for _, value := range exportedConfigurationValues(c.values(), true) {
+		if value.GetSecret() {
+			t.Errorf("root credential %s exported", value.GetKey())
+		}
+	}
+}
+
+func TestWriteConsumerCredentials(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "credentials")
+	consumers := []*consumerCredentials{
+		{Consumer: "backend/api", AccessKey: "backend-api", SecretKey: "api-secret"},
+		{Consumer: "backend/worker", AccessKey: "backend-worker", SecretKey: "worker-secret"},
+	}
+	if err := writeConsumerCredentials(dir, consumers); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "minio-backend-api.env")
+	info, err := os.Stat(file)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("credentials of backend/api = %v, %v", info, err)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "AWS_ACCESS_KEY_ID=backend-api\nAWS_SECRET_ACCESS_KEY=api-secret\nMINIO_ACCESS_KEY=backend-api\nMINIO_SECRET_KEY=api-secret\n"
+	if string(content) != expected {
+		t.Errorf("credentials of backend/api = %q", content)
+	}
+
+	// A consumer that no longer claims buckets loses its file.
+	if err = writeConsumerCredentials(dir, consumers[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "minio-backend-worker.env")); !os.IsNotExist(err) {
+		t.Errorf("credentials of backend/worker are kept: %v", err)
+	}
+}
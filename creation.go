@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+
+	"github.com/codefly-dev/core/agents/communicate"
+	agentv0 "github.com/codefly-dev/core/generated/go/codefly/services/agent/v0"
+)
+
+// Questions asked when the service is created. Without communication, each
+// answer is read from the settings the service is created with, e.g.
+// storage-size: 50Gi or console: true; otherwise its default is used.
+const (
+	BucketsOption     = "buckets"
+	ConsoleOption     = "console"
+	TLSOption         = "tls"
+	PersistenceOption = "persistence"
+	StorageSizeOption = "storage-size"
+)
+
+// DefaultStorageSize of the deployed data volume.
+const DefaultStorageSize = "10Gi"
+
+type creationQuestion struct {
+	Name        string
+	Message     string
+	Description string
+	Default     string
+	Confirm     bool
+}
+
+var creationQuestions = []creationQuestion{
+	{
+		Name:        BucketsOption,
+		Message:     "Initial buckets (comma-separated)?",
+		Description: "created when the service starts, and exported to consumers",
+	},
+	{
+		Name:        ConsoleOption,
+		Message:     "Expose the MinIO console?",
+		Description: "the web console is served on its own endpoint",
+		Default:     "false",
+		Confirm:     true,
+	},
+	{
+		Name:        TLSOption,
+		Message:     "Is the deployed S3 API served over TLS?",
+		Description: "consumers get https URLs; the local runtime always serves plain HTTP",
+		Default:     "false",
+		Confirm:     true,
+	},
+	{
+		Name:        PersistenceOption,
+		Message:     "Persist data?",
+		Description: "keep objects on a volume across restarts (Recommended)",
+		Default:     "true",
+		Confirm:     true,
+	},
+	{
+		Name:        StorageSizeOption,
+		Message:     "Size of the data volume?",
+		Description: "as a Kubernetes quantity, e.g. 10Gi",
+		Default:     DefaultStorageSize,
+	},
+}
+
+var storageSizePattern = regexp.MustCompile(`^[1-9][0-9]*(Ki|Mi|Gi|Ti)$`)
+
+func creationOptions() []*agentv0.Question {
+	var questions []*agentv0.Question
+	for _, question := range creationQuestions {
+		message := &agentv0.Message{Name: question.Name, Message: question.Message, Description: question.Description}
+		if question.Confirm {
+			questions = append(questions, communicate.NewConfirm(message, question.Default == "true"))
+			continue
+		}
+		questions = append(questions, communicate.NewStringInput(message, question.Default))
+	}
+	return questions
+}
+
+// communicatedAnswers keeps the answers of the creation questions by name.
+func communicatedAnswers(answers map[string]*agentv0.Answer) map[string]string {
+	values := make(map[string]string)
+	for _, question := range creationQuestions {
+		answer, ok := answers[question.Name]
+		if !ok {
+			continue
+		}
+		if question.Confirm {
+			values[question.Name] = strconv.FormatBool(answer.GetConfirm().GetConfirmed())
+			continue
+		}
+		values[question.Name] = answer.GetStringInput().GetValue()
+	}
+	return values
+}
+
+// settingsAnswers reads the answers from settings given at creation. The
+// defaults of the questions are the zero values of the settings.
+func settingsAnswers(settings *Settings) map[string]string {
+	values := make(map[string]string)
+	var buckets []string
+	for _, bucket := range settings.Buckets {
+		buckets = append(buckets, bucket.Name)
+	}
+	if len(buckets) > 0 {
+		values[BucketsOption] = strings.Join(buckets, ",")
+	}
+	if settings.Console {
+		values[ConsoleOption] = "true"
+	}
+	if settings.TLS {
+		values[TLSOption] = "true"
+	}
+	if settings.Ephemeral {
+		values[PersistenceOption] = "false"
+	}
+	if settings.StorageSize != "" {
+		values[StorageSizeOption] = settings.StorageSize
+	}
+	return values
+}
+
+// creationAnswers completes answers with the ones of the settings and the
+// defaults.
+func creationAnswers(answers map[string]string, preset map[string]string) map[string]string {
+	values := make(map[string]string)
+	for _, question := range creationQuestions {
+		if answer, ok := answers[question.Name]; ok {
+			values[question.Name] = answer
+			continue
+		}
+		if answer, ok := preset[question.Name]; ok {
+			values[question.Name] = answer
+			continue
+		}
+		values[question.Name] = question.Default
+	}
+	return values
+}
+
+// applyCreationAnswers writes the answers into the settings. Buckets already
+// in the settings keep the rest of their settings.
+func applyCreationAnswers(settings *Settings, answers map[string]string) error {
+	var err error
+	existing := make(map[string]*BucketSettings)
+	for _, bucket := range settings.Buckets {
+		existing[bucket.Name] = bucket
+	}
+	settings.Buckets = nil
+	for _, name := range strings.Split(answers[BucketsOption], ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if err = s3utils.CheckValidBucketNameStrict(name); err != nil {
+			return fmt.Errorf("%s: invalid bucket name %q: %w", BucketsOption, name, err)
+		}
+		if bucket, ok := existing[name]; ok {
+			settings.Buckets = append(settings.Buckets, bucket)
+			continue
+		}
+		settings.Buckets = append(settings.Buckets, &BucketSettings{Name: name})
+	}
+	if settings.Console, err = parseConfirmAnswer(answers, ConsoleOption); err != nil {
+		return err
+	}
+	if settings.TLS, err = parseConfirmAnswer(answers, TLSOption); err != nil {
+		return err
+	}
+	persistence, err := parseConfirmAnswer(answers, PersistenceOption)
+	if err != nil {
+		return err
+	}
+	settings.Ephemeral = !persistence
+	size := strings.TrimSpace(answers[StorageSizeOption])
+	if !storageSizePattern.MatchString(size) {
+		return fmt.Errorf("%s: %q is not a size such as %s", StorageSizeOption, size, DefaultStorageSize)
+	}
+	settings.StorageSize = size
+	return nil
+}
+
+func parseConfirmAnswer(answers map[string]string, name string) (bool, error) {
+	value, err := strconv.ParseBool(strings.TrimSpace(answers[name]))
+	if err != nil {
+		return false, fmt.Errorf("%s: %q is not a yes/no answer", name, answers[name])
+	}
+	return value, nil
+}
+
+// generateSecret returns a random hex string for generated credentials.
+func generateSecret(bytes int) (string, error) {
+	secret := make([]byte, bytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
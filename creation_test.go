@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCreationDefaults(t *testing.T) {
+	settings := &Settings{}
+	if err := applyCreationAnswers(settings, creationAnswers(nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if len(settings.Buckets) != 0 || settings.Console || settings.TLS || settings.Ephemeral {
+		t.Fatalf("default settings = %+v", settings)
+	}
+	if settings.StorageSize != DefaultStorageSize {
+		t.Fatalf("storage size = %q", settings.StorageSize)
+	}
+}
+
+func TestCreationAnswersFromSettings(t *testing.T) {
+	settings := &Settings{
+		Buckets:     []*BucketSettings{{Name: "uploads", Versioning: true}, {Name: "exports"}},
+		Ephemeral:   true,
+		StorageSize: "50Gi",
+	}
+	preset := settingsAnswers(settings)
+	if len(preset) != 3 {
+		t.Fatalf("answers of the settings = %v", preset)
+	}
+
+	if err := applyCreationAnswers(settings, creationAnswers(map[string]string{ConsoleOption: "true"}, preset)); err != nil {
+		t.Fatal(err)
+	}
+	if len(settings.Buckets) != 2 || settings.Buckets[0].Name != "uploads" || settings.Buckets[1].Name != "exports" {
+		t.Fatalf("buckets = %+v", settings.Buckets)
+	}
+	if !settings.Buckets[0].Versioning {
+		t.Error("the settings of a preset bucket are lost")
+	}
+	if !settings.Console || !settings.Ephemeral || settings.StorageSize != "50Gi" {
+		t.Fatalf("settings = %+v", settings)
+	}
+}
+
+func TestCreationRejectsInvalidAnswers(t *testing.T) {
+	for name, answer := range map[string]string{
+		BucketsOption:     "Not_A_Bucket",
+		TLSOption:         "maybe",
+		StorageSizeOption: "ten gigs",
+	} {
+		answers := creationAnswers(map[string]string{name: answer}, nil)
+		err := applyCreationAnswers(&Settings{}, answers)
+		if err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("%s=%q: error = %v", name, answer, err)
+		}
+	}
+}
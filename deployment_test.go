@@ -53,8 +53,8 @@ func TestRestrictedPortableDeploymentReferencesExternalSecretsAndReturnsValueFre
 		destination,
 		networkMappings,
 		map[string]*builderv0.KubernetesSecretKeyReference{
-			accessKeyEnv: {Name: "minio-credentials", Key: "access-key"},
-			secretKeyEnv: {Name: "minio-credentials", Key: "secret-key"},
+			accessKeyEnv:       {Name: "minio-credentials", Key: "access-key"},
+			secretKeyEnv:       {Name: "minio-credentials", Key: "secret-key"},
 			"UNRELATED_SECRET": {Name: "unrelated-credentials", Key: "token"},
 		},
 	))
@@ -86,8 +86,14 @@ func TestRestrictedPortableDeploymentReferencesExternalSecretsAndReturnsValueFre
 	if len(infos) != 1 || infos[0].GetName() != "minio" {
 		t.Fatalf("connection configuration infos = %v", infos)
 	}
+	secretKeys := make(map[string]bool)
+	for _, field := range ConfigurationSchema {
+		for _, key := range append([]string{field.Name}, field.Aliases...) {
+			secretKeys[key] = field.Secret
+		}
+	}
 	for _, value := range infos[0].GetConfigurationValues() {
-		if value.GetKey() == "endpoint" {
+		if !secretKeys[value.GetKey()] {
 			continue
 		}
 		if !value.GetSecret() || value.GetValue() != "" {
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/policy"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+// SyncSettings point Sync at the instance it compares the settings with.
+type SyncSettings struct {
+	// Endpoint of the S3 API of the instance, e.g.
+	// https://minio.staging.example.com.
+	Endpoint string `yaml:"endpoint"`
+	// Environment whose credentials Sync signs in with, local by default.
+	Environment string `yaml:"environment,omitempty"`
+	// Reconcile applies the settings where they drift instead of only
+	// reporting it.
+	Reconcile bool `yaml:"reconcile,omitempty"`
+}
+
+// Kinds of resources compared by Sync.
+const (
+	BucketResource             = "bucket"
+	PolicyResource             = "policy"
+	UserResource               = "user"
+	NotificationTargetResource = "notification-target"
+)
+
+// Properties of a bucket compared by Sync.
+const (
+	ExistsProperty        = "exists"
+	VersioningProperty    = "versioning"
+	PolicyProperty        = "policy"
+	LifecycleProperty     = "lifecycle"
+	NotificationsProperty = "notifications"
+	DocumentProperty      = "document"
+	StatusProperty        = "status"
+)
+
+// Drift is one difference between the settings and the instance.
+type Drift struct {
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Property string `json:"property"`
+	Desired  string `json:"desired"`
+	Actual   string `json:"actual"`
+}
+
+func (d *Drift) String() string {
+	return fmt.Sprintf("%s %s: %s is %q, settings want %q", d.Kind, d.Name, d.Property, d.Actual, d.Desired)
+}
+
+// bucketState is what Sync reads back from a bucket.
+type bucketState struct {
+	Exists        bool
+	Versioning    bool
+	Policy        policy.BucketPolicy
+	Lifecycle     []*LifecycleRule
+	Notifications []*BucketNotification
+}
+
+// compareBucket lists the drift of one declared bucket. A missing bucket is a
+// single drift: creating it applies every property.
+func compareBucket(desired *BucketSettings, actual *bucketState) ([]*Drift, error) {
+	access, err := bucketPolicy(desired)
+	if err != nil {
+		return nil, err
+	}
+	drift := func(property string, want string, got string) *Drift {
+		return &Drift{Kind: BucketResource, Name: desired.Name, Property: property, Desired: want, Actual: got}
+	}
+	if !actual.Exists {
+		return []*Drift{drift(ExistsProperty, "true", "false")}, nil
+	}
+	var drifts []*Drift
+	if desired.Versioning != actual.Versioning {
+		drifts = append(drifts, drift(VersioningProperty, strconv.FormatBool(desired.Versioning), strconv.FormatBool(actual.Versioning)))
+	}
+	if access != actual.Policy {
+		drifts = append(drifts, drift(PolicyProperty, string(access), string(actual.Policy)))
+	}
+	// A bucket without rules keeps the lifecycle of the server.
+	if want, got := summary(desired.Lifecycle), summary(actual.Lifecycle); desired.Lifecycle != nil && want != got {
+		drifts = append(drifts, drift(LifecycleProperty, want, got))
+	}
+	if want, got := summary(desired.Notifications), summary(actual.Notifications); want != got {
+		drifts = append(drifts, drift(NotificationsProperty, want, got))
+	}
+	return drifts, nil
+}
+
+// undeclaredBuckets reports the buckets of the instance the settings do not
+// know about. Sync never deletes them.
+func undeclaredBuckets(desired []*BucketSettings, actual []string) []*Drift {
+	declared := make(map[string]bool)
+	for _, bucket := range desired {
+		declared[bucket.Name] = true
+	}
+	var drifts []*Drift
+	for _, name := range actual {
+		if !declared[name] {
+			drifts = append(drifts, &Drift{Kind: BucketResource, Name: name, Property: ExistsProperty, Desired: "false", Actual: "true"})
+		}
+	}
+	return drifts
+}
+
+// comparePolicies lists the consumer policies that are missing or differ from
+// their claims. Other policies of the instance are left alone.
+func comparePolicies(desired []*ConsumerPolicy, actual map[string]json.RawMessage) ([]*Drift, error) {
+	var drifts []*Drift
+	for _, consumer := range desired {
+		want, err := normalizePolicy([]byte(consumer.Document))
+		if err != nil {
+			return nil, err
+		}
+		got := ""
+		if document, ok := actual[consumer.Name]; ok {
+			if got, err = normalizePolicy(document); err != nil {
+				return nil, fmt.Errorf("policy %s: %w", consumer.Name, err)
+			}
+		}
+		if want != got {
+			drifts = append(drifts, &Drift{Kind: PolicyResource, Name: consumer.Name, Property: DocumentProperty, Desired: want, Actual: got})
+		}
+	}
+	return drifts, nil
+}
+
+// compareUsers lists the users of the consumers that are missing, disabled,
+// or granted another policy than the one of their claims. Other users of the
+// instance are left alone.
+func compareUsers(desired []*ConsumerPolicy, actual map[string]*userInfo) []*Drift {
+	var drifts []*Drift
+	for _, consumer := range desired {
+		drift := func(property string, want string, got string) *Drift {
+			return &Drift{Kind: UserResource, Name: consumer.User, Property: property, Desired: want, Actual: got}
+		}
+		info, ok := actual[consumer.User]
+		if !ok {
+			drifts = append(drifts, drift(ExistsProperty, "true", "false"))
+			continue
+		}
+		if info.Status != userEnabled {
+			drifts = append(drifts, drift(StatusProperty, userEnabled, info.Status))
+		}
+		if info.PolicyName != consumer.Name {
+			drifts = append(drifts, drift(PolicyProperty, consumer.Name, info.PolicyName))
+		}
+	}
+	return drifts
+}
+
+// compareNotificationTargets lists the targets the bucket notifications send
+// to that the instance does not have, or has offline. The targets are
+// configured on the server, not in the settings.
+func compareNotificationTargets(buckets []*BucketSettings, actual map[string]string) []*Drift {
+	seen := make(map[string]bool)
+	var drifts []*Drift
+	for _, bucket := range buckets {
+		for _, notification := range bucket.Notifications {
+			if seen[notification.Target] {
+				continue
+			}
+			seen[notification.Target] = true
+			drift := &Drift{Kind: NotificationTargetResource, Name: notification.Target}
+			status, ok := actual[notificationTargetID(notification.Target)]
+			switch {
+			case !ok:
+				drift.Property, drift.Desired, drift.Actual = ExistsProperty, "true", "false"
+			case status != targetOnline:
+				drift.Property, drift.Desired, drift.Actual = StatusProperty, targetOnline, status
+			default:
+				continue
+			}
+			drifts = append(drifts, drift)
+		}
+	}
+	return drifts
+}
+
+// notificationTargetID is the <id>:<type> of a target ARN such as
+// arn:minio:sqs::primary:webhook.
+func notificationTargetID(arn string) string {
+	parts := strings.Split(arn, ":")
+	if len(parts) != 6 {
+		return arn
+	}
+	return parts[4] + ":" + parts[5]
+}
+
+// normalizePolicy makes documents comparable whatever the order of their
+// actions and resources.
+func normalizePolicy(content []byte) (string, error) {
+	var document policyDocument
+	if err := json.Unmarshal(content, &document); err != nil {
+		return "", fmt.Errorf("cannot parse policy: %w", err)
+	}
+	for _, statement := range document.Statement {
+		sort.Strings(statement.Action)
+		sort.Strings(statement.Resource)
+	}
+	normalized, err := json.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+	return string(normalized), nil
+}
+
+// summary sorts the summaries of rules or notifications so that their order
+// does not matter.
+func summary[T fmt.Stringer](items []T) string {
+	var summaries []string
+	for _, item := range items {
+		summaries = append(summaries, item.String())
+	}
+	sort.Strings(summaries)
+	return strings.Join(summaries, "; ")
+}
+
+// syncTarget is the instance Sync compares against.
+type syncTarget struct {
+	endpoint string
+	client   *minio.Client
+	admin    *adminClient
+}
+
+// syncTarget is nil when the settings configure no instance.
+func (s *Builder) syncTarget() (*syncTarget, error) {
+	settings := s.Settings.Sync
+	if settings == nil || settings.Endpoint == "" {
+		return nil, nil
+	}
+	endpoint := settings.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	target, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("sync endpoint: %w", err)
+	}
+	environment := settings.Environment
+	if environment == "" {
+		environment = "local"
+	}
+	conf, err := readConfigurationFile(s.Local(configurationFile(environment, true)))
+	if err != nil {
+		return nil, err
+	}
+	values, err := ParseConfiguration(conf, environment)
+	if err != nil {
+		return nil, err
+	}
+	secure := target.Scheme == "https"
+	client, err := minio.New(target.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4(values[AccessKeyField.Name], values[SecretKeyField.Name], ""),
+		Secure: secure,
+		Region: s.region(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &syncTarget{
+		endpoint: target.String(),
+		client:   client,
+		admin: &adminClient{
+			endpoint:  &url.URL{Scheme: target.Scheme, Host: target.Host},
+			accessKey: values[AccessKeyField.Name],
+			secretKey: values[SecretKeyField.Name],
+			region:    s.region(),
+			http:      http.DefaultClient,
+		},
+	}, nil
+}
+
+// detectDrift reads the buckets, the policies and users of the consumers, and
+// the notification targets of the instance.
+func (s *Service) detectDrift(ctx context.Context, target *syncTarget) ([]*Drift, error) {
+	existing, err := target.client.ListBuckets(ctx)
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot list buckets")
+	}
+	var names []string
+	exists := make(map[string]bool)
+	for _, bucket := range existing {
+		names = append(names, bucket.Name)
+		exists[bucket.Name] = true
+	}
+	desired := s.bucketSettings()
+	var drifts []*Drift
+	for _, bucket := range desired {
+		actual := &bucketState{}
+		if exists[bucket.Name] {
+			if actual, err = readBucketState(ctx, target.client, bucket.Name); err != nil {
+				return nil, s.Wool.Wrapf(err, "cannot read bucket %s", bucket.Name)
+			}
+		}
+		bucketDrifts, err := compareBucket(bucket, actual)
+		if err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, bucketDrifts...)
+	}
+	drifts = append(drifts, undeclaredBuckets(desired, names)...)
+
+	policies, err := consumerPolicies(s.claims)
+	if err != nil {
+		return nil, err
+	}
+	if len(policies) > 0 {
+		actualPolicies, err := target.admin.cannedPolicies(ctx)
+		if err != nil {
+			return nil, s.Wool.Wrapf(err, "cannot list policies")
+		}
+		policyDrifts, err := comparePolicies(policies, actualPolicies)
+		if err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, policyDrifts...)
+
+		users := make(map[string]*userInfo)
+		for _, consumer := range policies {
+			info, err := target.admin.user(ctx, consumer.User)
+			if errors.Is(err, errNoSuchUser) {
+				continue
+			}
+			if err != nil {
+				return nil, s.Wool.Wrapf(err, "cannot read user %s", consumer.User)
+			}
+			users[consumer.User] = info
+		}
+		drifts = append(drifts, compareUsers(policies, users)...)
+	}
+
+	if hasNotifications(desired) {
+		targets, err := target.admin.notificationTargets(ctx)
+		if err != nil {
+			return nil, s.Wool.Wrapf(err, "cannot list notification targets")
+		}
+		drifts = append(drifts, compareNotificationTargets(desired, targets)...)
+	}
+	return drifts, nil
+}
+
+func hasNotifications(buckets []*BucketSettings) bool {
+	for _, bucket := range buckets {
+		if len(bucket.Notifications) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func readBucketState(ctx context.Context, client *minio.Client, bucket string) (*bucketState, error) {
+	state := &bucketState{Exists: true}
+	versioning, err := client.GetBucketVersioning(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	state.Versioning = versioning.Enabled()
+	document, err := client.GetBucketPolicy(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if state.Policy, err = anonymousAccess(bucket, document); err != nil {
+		return nil, err
+	}
+	rules, err := client.GetBucketLifecycle(ctx, bucket)
+	if err != nil && minio.ToErrorResponse(err).Code != "NoSuchLifecycleConfiguration" {
+		return nil, err
+	}
+	state.Lifecycle = lifecycleRules(rules)
+	notifications, err := client.GetBucketNotification(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	state.Notifications = bucketNotifications(notifications)
+	return state, nil
+}
+
+// reconcile applies the settings for each drift. Undeclared buckets and
+// notification targets are only reported.
+func (s *Service) reconcile(ctx context.Context, target *syncTarget, drifts []*Drift) error {
+	declared := make(map[string]*BucketSettings)
+	for _, bucket := range s.bucketSettings() {
+		declared[bucket.Name] = bucket
+	}
+	policies, err := consumerPolicies(s.claims)
+	if err != nil {
+		return err
+	}
+	documents := make(map[string]string)
+	users := make(map[string]*ConsumerPolicy)
+	for _, consumer := range policies {
+		documents[consumer.Name] = consumer.Document
+		users[consumer.User] = consumer
+	}
+	for _, drift := range drifts {
+		switch drift.Kind {
+		case PolicyResource:
+			if err = target.admin.addCannedPolicy(ctx, drift.Name, documents[drift.Name]); err != nil {
+				return s.Wool.Wrapf(err, "cannot update policy %s", drift.Name)
+			}
+			continue
+		case UserResource:
+			consumer := users[drift.Name]
+			if drift.Property != PolicyProperty {
+				secretKey := consumerSecretKey(target.admin.secretKey, consumer.Consumer)
+				if err = target.admin.addUser(ctx, consumer.User, secretKey); err != nil {
+					return s.Wool.Wrapf(err, "cannot update user %s", consumer.User)
+				}
+			}
+			if err = target.admin.setUserPolicy(ctx, consumer.User, consumer.Name); err != nil {
+				return s.Wool.Wrapf(err, "cannot attach policy %s to user %s", consumer.Name, consumer.User)
+			}
+			continue
+		case NotificationTargetResource:
+			s.Wool.Warn("notification targets are configured on the server", wool.Field("target", drift.Name))
+			continue
+		}
+		bucket, ok := declared[drift.Name]
+		if !ok {
+			s.Wool.Warn("not deleting undeclared bucket", wool.Field("bucket", drift.Name))
+			continue
+		}
+		if drift.Property == ExistsProperty {
+			err = target.client.MakeBucket(ctx, bucket.Name, minio.MakeBucketOptions{Region: s.region()})
+			if err != nil {
+				return s.Wool.Wrapf(err, "cannot create bucket %s", bucket.Name)
+			}
+			if err = configureNewBucket(ctx, target.client, bucket); err != nil {
+				return s.Wool.Wrapf(err, "cannot configure bucket %s", bucket.Name)
+			}
+			continue
+		}
+		if err = applyBucketProperty(ctx, target.client, bucket, drift.Property); err != nil {
+			return s.Wool.Wrapf(err, "cannot reconcile %s of bucket %s", drift.Property, bucket.Name)
+		}
+	}
+	return nil
+}
+
+func applyBucketProperty(ctx context.Context, client *minio.Client, bucket *BucketSettings, property string) error {
+	switch property {
+	case VersioningProperty:
+		if bucket.Versioning {
+			return client.EnableVersioning(ctx, bucket.Name)
+		}
+		return client.SuspendVersioning(ctx, bucket.Name)
+	case PolicyProperty:
+		access, err := bucketPolicy(bucket)
+		if err != nil {
+			return err
+		}
+		document, err := anonymousPolicyDocument(bucket.Name, access)
+		if err != nil {
+			return err
+		}
+		return client.SetBucketPolicy(ctx, bucket.Name, document)
+	case LifecycleProperty:
+		return client.SetBucketLifecycle(ctx, bucket.Name, lifecycleConfiguration(bucket.Lifecycle))
+	case NotificationsProperty:
+		configuration, err := notificationConfiguration(bucket.Notifications)
+		if err != nil {
+			return err
+		}
+		return client.SetBucketNotification(ctx, bucket.Name, configuration)
+	}
+	return fmt.Errorf("unknown bucket property %s", property)
+}
+
+// syncReport is what Sync found and did, written to the sync report file.
+type syncReport struct {
+	Endpoint   string   `json:"endpoint"`
+	Drifts     []*Drift `json:"drifts"`
+	Reconciled bool     `json:"reconciled"`
+}
+
+func (s *Service) syncReportFile() string {
+	return filepath.Join(s.Identity.WorkspacePath, ".codefly", "sync", s.Identity.Module, s.Identity.Name, "drift.json")
+}
+
+// writeReport writes the JSON report of a builder operation.
+func writeReport(file string, report any) error {
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, content, 0o644)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/minio/minio-go/v7/pkg/policy"
+)
+
+func TestCompareBucket(t *testing.T) {
+	desired := &BucketSettings{
+		Name:       "uploads",
+		Versioning: true,
+		Policy:     string(policy.BucketPolicyReadOnly),
+		Lifecycle: []*LifecycleRule{
+			{ID: "tmp", Prefix: "tmp/", ExpirationDays: 1},
+			{ID: "uploads", AbortIncompleteMultipartDays: 7},
+		},
+	}
+
+	missing, err := compareBucket(desired, &bucketState{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 1 || missing[0].Property != ExistsProperty {
+		t.Fatalf("missing bucket drift = %v", missing)
+	}
+
+	inSync, err := compareBucket(desired, &bucketState{
+		Exists:     true,
+		Versioning: true,
+		Policy:     policy.BucketPolicyReadOnly,
+		Lifecycle: []*LifecycleRule{
+			{ID: "uploads", AbortIncompleteMultipartDays: 7},
+			{ID: "tmp", Prefix: "tmp/", ExpirationDays: 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inSync) != 0 {
+		t.Fatalf("rule order should not drift, got %v", inSync)
+	}
+
+	drifted, err := compareBucket(desired, &bucketState{
+		Exists:        true,
+		Policy:        policy.BucketPolicyReadWrite,
+		Notifications: []*BucketNotification{{Target: "arn:minio:sqs::primary:webhook", Events: []string{"s3:ObjectCreated:*"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties := make(map[string]bool)
+	for _, drift := range drifted {
+		properties[drift.Property] = true
+	}
+	for _, property := range []string{VersioningProperty, PolicyProperty, LifecycleProperty, NotificationsProperty} {
+		if !properties[property] {
+			t.Errorf("%s drift not reported in %v", property, drifted)
+		}
+	}
+}
+
+func TestCompareBucketWithoutRules(t *testing.T) {
+	drifts, err := compareBucket(&BucketSettings{Name: "uploads"}, &bucketState{
+		Exists:    true,
+		Policy:    policy.BucketPolicyNone,
+		Lifecycle: []*LifecycleRule{{ID: "tmp", Prefix: "tmp/", ExpirationDays: 1}},
+	})
+	if err != nil || len(drifts) != 0 {
+		t.Errorf("lifecycle of a bucket without rules drifts: %v, %v", drifts, err)
+	}
+
+	drifts, err = compareBucket(&BucketSettings{Name: "uploads", Lifecycle: LifecycleRules{}}, &bucketState{
+		Exists:    true,
+		Policy:    policy.BucketPolicyNone,
+		Lifecycle: []*LifecycleRule{{ID: "tmp", Prefix: "tmp/", ExpirationDays: 1}},
+	})
+	if err != nil || len(drifts) != 1 || drifts[0].Property != LifecycleProperty {
+		t.Errorf("rules left on a bucket declaring none: %v, %v", drifts, err)
+	}
+}
+
+func TestCompareBucketRejectsUnknownPolicy(t *testing.T) {
+	_, err := compareBucket(&BucketSettings{Name: "uploads", Policy: "public"}, &bucketState{Exists: true})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestUndeclaredBucketsAreReported(t *testing.T) {
+	drifts := undeclaredBuckets([]*BucketSettings{{Name: "uploads"}}, []string{"uploads", "scratch"})
+	if len(drifts) != 1 || drifts[0].Name != "scratch" || drifts[0].Desired != "false" {
+		t.Fatalf("undeclared drift = %v", drifts)
+	}
+}
+
+func TestAnonymousPolicyRoundTrip(t *testing.T) {
+	for _, access := range []policy.BucketPolicy{
+		policy.BucketPolicyNone,
+		policy.BucketPolicyReadOnly,
+		policy.BucketPolicyWriteOnly,
+		policy.BucketPolicyReadWrite,
+	} {
+		document, err := anonymousPolicyDocument("uploads", access)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := anonymousAccess("uploads", document)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != access {
+			t.Errorf("%s read back as %s", access, got)
+		}
+	}
+}
+
+func TestLifecycleAndNotificationsRoundTrip(t *testing.T) {
+	rules := []*LifecycleRule{{ID: "tmp", Prefix: "tmp/", ExpirationDays: 1, NoncurrentExpirationDays: 2, AbortIncompleteMultipartDays: 3}}
+	if want, got := summary(rules), summary(lifecycleRules(lifecycleConfiguration(rules))); want != got {
+		t.Errorf("lifecycle read back as %s, want %s", got, want)
+	}
+
+	notifications := []*BucketNotification{{
+		Target: "arn:minio:sqs::primary:webhook",
+		Events: []string{"s3:ObjectCreated:*", "s3:ObjectRemoved:*"},
+		Prefix: "images/",
+		Suffix: ".png",
+	}}
+	configuration, err := notificationConfiguration(notifications)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := summary(notifications), summary(bucketNotifications(configuration)); want != got {
+		t.Errorf("notifications read back as %s, want %s", got, want)
+	}
+}
+
+func TestComparePoliciesIgnoresOrdering(t *testing.T) {
+	desired, err := consumerPolicies([]*ConsumerClaims{{
+		Consumer: "backend/api",
+		Claims:   []*BucketClaim{{Name: "uploads", Access: ReadWrite}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var document policyDocument
+	if err = json.Unmarshal([]byte(desired[0].Document), &document); err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range document.Statement {
+		for i, j := 0, len(statement.Action)-1; i < j; i, j = i+1, j-1 {
+			statement.Action[i], statement.Action[j] = statement.Action[j], statement.Action[i]
+		}
+	}
+	reordered, err := json.Marshal(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drifts, err := comparePolicies(desired, map[string]json.RawMessage{desired[0].Name: reordered})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 0 {
+		t.Fatalf("reordered actions should not drift, got %v", drifts)
+	}
+
+	drifts, err = comparePolicies(desired, map[string]json.RawMessage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 1 || drifts[0].Kind != PolicyResource {
+		t.Fatalf("missing policy drift = %v", drifts)
+	}
+}
+
+func TestCompareUsers(t *testing.T) {
+	desired, err := consumerPolicies([]*ConsumerClaims{
+		{Consumer: "backend/api", Claims: []*BucketClaim{{Name: "uploads", Access: ReadWrite}}},
+		{Consumer: "backend/worker", Claims: []*BucketClaim{{Name: "uploads"}}},
+		{Consumer: "web/front", Claims: []*BucketClaim{{Name: "uploads"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byConsumer := make(map[string]*ConsumerPolicy)
+	for _, consumer := range desired {
+		byConsumer[consumer.Consumer] = consumer
+	}
+	api, worker := byConsumer["backend/api"], byConsumer["backend/worker"]
+	drifts := compareUsers(desired, map[string]*userInfo{
+		api.User:    {PolicyName: api.Name, Status: userEnabled},
+		worker.User: {PolicyName: "readwrite", Status: "disabled"},
+	})
+	got := make(map[string]bool)
+	for _, drift := range drifts {
+		if drift.Kind != UserResource {
+			t.Errorf("drift kind %s", drift.Kind)
+		}
+		got[drift.Name+" "+drift.Property] = true
+	}
+	for _, want := range []string{
+		worker.User + " " + StatusProperty,
+		worker.User + " " + PolicyProperty,
+		byConsumer["web/front"].User + " " + ExistsProperty,
+	} {
+		if !got[want] {
+			t.Errorf("%s not reported in %v", want, drifts)
+		}
+	}
+	if len(drifts) != 3 {
+		t.Errorf("drifts = %v", drifts)
+	}
+}
+
+func TestCompareNotificationTargets(t *testing.T) {
+	buckets := []*BucketSettings{
+		{Name: "uploads", Notifications: []*BucketNotification{
+			{Target: "arn:minio:sqs::primary:webhook"},
+			{Target: "arn:minio:sqs::audit:webhook"},
+		}},
+		{Name: "exports", Notifications: []*BucketNotification{
+			{Target: "arn:minio:sqs::primary:webhook"},
+			{Target: "arn:minio:sqs::events:amqp"},
+		}},
+	}
+	drifts := compareNotificationTargets(buckets, map[string]string{
+		"primary:webhook": targetOnline,
+		"audit:webhook":   "offline",
+	})
+	if len(drifts) != 2 {
+		t.Fatalf("drifts = %v", drifts)
+	}
+	if drifts[0].Name != "arn:minio:sqs::audit:webhook" || drifts[0].Property != StatusProperty || drifts[0].Actual != "offline" {
+		t.Errorf("offline target drift = %v", drifts[0])
+	}
+	if drifts[1].Name != "arn:minio:sqs::events:amqp" || drifts[1].Property != ExistsProperty {
+		t.Errorf("missing target drift = %v", drifts[1])
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sync", "drift.json")
+	report := &syncReport{Endpoint: "https://minio.example.com", Drifts: []*Drift{}}
+	if err := writeReport(file, report); err != nil {
+		t.Fatal(err)
+	}
+	var read syncReport
+	if err := json.Unmarshal([]byte(readFile(t, file)), &read); err != nil {
+		t.Fatal(err)
+	}
+	if read.Endpoint != report.Endpoint || read.Drifts == nil || len(read.Drifts) != 0 || read.Reconciled {
+		t.Errorf("report = %+v", read)
+	}
+}
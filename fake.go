@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+	"github.com/codefly-dev/core/resources"
+	"github.com/codefly-dev/core/wool"
+
+	"github.com/codefly-dev/service-minio/s3fake"
+)
+
+const (
+	DockerBackend = "docker"
+	NativeBackend = "native"
+	// FakeBackend serves S3 from the agent process, in memory: no Docker and
+	// no minio binary. The MinIO admin API is not available.
+	FakeBackend = "fake"
+)
+
+// validateBackend checks the backend setting.
+func validateBackend(backend string) error {
+	switch backend {
+	case "", DockerBackend, NativeBackend, FakeBackend:
+		return nil
+	}
+	return fmt.Errorf("backend must be %s, %s or %s, got %q", DockerBackend, NativeBackend, FakeBackend, backend)
+}
+
+// backend is the one of the settings, or else the one of the runtime context.
+func backend(setting string, runtimeContext *basev0.RuntimeContext) string {
+	if setting != "" {
+		return setting
+	}
+	if runtimeContext.GetKind() == resources.RuntimeContextNative {
+		return NativeBackend
+	}
+	return DockerBackend
+}
+
+// initFake serves the S3 API of the definition with the in-process fake,
+// with the same credentials and region. Data never outlives the agent.
+func (s *Runtime) initFake(ctx context.Context, definition *containerDefinition) error {
+	var port uint16
+	for _, mapping := range definition.Ports {
+		if mapping.Container == minioPort {
+			port = mapping.Host
+		}
+	}
+	if port == 0 {
+		return s.Wool.NewError("no host port for the S3 API")
+	}
+	if !s.Settings.Ephemeral {
+		s.Wool.Warn("the fake backend keeps data in memory: persistence is ignored")
+	}
+	server := s3fake.New(s.accessKey, s.secretKey, s.region())
+	address, err := server.Start(fmt.Sprintf(":%d", port))
+	if err != nil {
+		return s.Wool.Wrapf(err, "cannot start the fake S3 server")
+	}
+	s.fake = server
+	s.fakeAddress = fmt.Sprintf(":%d", port)
+	s.Wool.Debug("started fake S3 server", wool.Field("address", address))
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+	"github.com/codefly-dev/core/resources"
+)
+
+func TestBackend(t *testing.T) {
+	native := &basev0.RuntimeContext{Kind: resources.RuntimeContextNative}
+	container := &basev0.RuntimeContext{Kind: resources.RuntimeContextContainer}
+	for _, test := range []struct {
+		setting  string
+		context  *basev0.RuntimeContext
+		expected string
+	}{
+		{"", container, DockerBackend},
+		{"", native, NativeBackend},
+		{"", nil, DockerBackend},
+		{"fake", container, FakeBackend},
+		{"docker", native, DockerBackend},
+	} {
+		if err := validateBackend(test.setting); err != nil {
+			t.Fatal(err)
+		}
+		if got := backend(test.setting, test.context); got != test.expected {
+			t.Errorf("backend(%q, %s) = %s; want %s", test.setting, test.context.GetKind(), got, test.expected)
+		}
+	}
+	if err := validateBackend("memory"); err == nil {
+		t.Error("expected an unknown backend error")
+	}
+}
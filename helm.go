@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/codefly-dev/core/agents/services"
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+	builderv0 "github.com/codefly-dev/core/generated/go/codefly/services/builder/v0"
+	"github.com/codefly-dev/core/resources"
+	"github.com/codefly-dev/core/wool"
+)
+
+// Deployment formats of the manifests.
+const (
+	KustomizeFormat = "kustomize"
+	HelmFormat      = "helm"
+)
+
+// validateDeploymentFormat checks the deployment-format setting.
+func validateDeploymentFormat(format string) error {
+	switch format {
+	case "", KustomizeFormat, HelmFormat:
+		return nil
+	}
+	return fmt.Errorf("deployment-format must be %s or %s, got %q", KustomizeFormat, HelmFormat, format)
+}
+
+// helmChartDirectory is where the chart is written under the deployment
+// destination.
+const helmChartDirectory = "chart"
+
+// helmValues are the values.yaml of the chart. Everything can be overridden
+// at install time; restricted renders never hold secret values.
+type helmValues struct {
+	// Name of the Kubernetes Service: dependent services connect to it.
+	Name        string          `yaml:"name"`
+	Image       helmImage       `yaml:"image"`
+	Credentials helmCredentials `yaml:"credentials"`
+	Region      string          `yaml:"region,omitempty"`
+	Console     bool            `yaml:"console"`
+	Storage     helmStorage     `yaml:"storage"`
+	Resources   helmResources   `yaml:"resources"`
+	Ingress     helmIngress     `yaml:"ingress"`
+	Bootstrap   *helmBootstrap  `yaml:"bootstrap,omitempty"`
+	Audit       *helmAudit      `yaml:"audit,omitempty"`
+	// ConsumerSecrets hold the keys of each consumer user, for the release
+	// of the consumer alone. Only inline credentials have them.
+	ConsumerSecrets []*helmConsumerSecret `yaml:"consumerSecrets,omitempty"`
+	// Configuration is the exported connection configuration. Restricted
+	// renders return it on the response instead.
+	Configuration *helmConfiguration `yaml:"configuration,omitempty"`
+}
+
+// helmConfiguration is the connection configuration under the keys the
+// dependent services read, like the kustomize ExportConfiguration: the
+// values go to a ConfigMap and the secret values to a Secret.
+type helmConfiguration struct {
+	Values  map[string]string `yaml:"values,omitempty"`
+	Secrets map[string]string `yaml:"secrets,omitempty"`
+}
+
+// exportedConfiguration keys the configuration values by service, name and
+// key.
+func exportedConfiguration(unique string, configuration *basev0.Configuration) *helmConfiguration {
+	exported := &helmConfiguration{Values: make(map[string]string), Secrets: make(map[string]string)}
+	for _, info := range configuration.GetInfos() {
+		for _, value := range info.GetConfigurationValues() {
+			if value.GetSecret() {
+				exported.Secrets[resources.ServiceSecretConfigurationKeyFromUnique(unique, info.GetName(), value.GetKey())] = value.GetValue()
+				continue
+			}
+			exported.Values[resources.ServiceConfigurationKeyFromUnique(unique, info.GetName(), value.GetKey())] = value.GetValue()
+		}
+	}
+	return exported
+}
+
+type helmConsumerSecret struct {
+	Consumer string            `yaml:"consumer"`
+	User     string            `yaml:"user"`
+	Keys     map[string]string `yaml:"keys"`
+}
+
+type helmImage struct {
+	Repository string `yaml:"repository"`
+	Tag        string `yaml:"tag"`
+	Digest     string `yaml:"digest,omitempty"`
+	PullSecret string `yaml:"pullSecret,omitempty"`
+}
+
+// helmCredentials are either inline, rendered into a Secret of the chart, or
+// references to an existing Secret.
+type helmCredentials struct {
+	AccessKey string `yaml:"accessKey,omitempty"`
+	SecretKey string `yaml:"secretKey,omitempty"`
+	// Consumers are the secret keys of the consumer users, by the variable
+	// the bootstrap reads them from. Only inline credentials have them.
+	Consumers      map[string]string   `yaml:"consumers,omitempty"`
+	ExistingSecret *helmExistingSecret `yaml:"existingSecret,omitempty"`
+}
+
+type helmExistingSecret struct {
+	AccessKey helmSecretKeyReference `yaml:"accessKey"`
+	SecretKey helmSecretKeyReference `yaml:"secretKey"`
+}
+
+type helmSecretKeyReference struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+}
+
+type helmStorage struct {
+	Ephemeral    bool   `yaml:"ephemeral"`
+	Size         string `yaml:"size"`
+	StorageClass string `yaml:"storageClass,omitempty"`
+}
+
+type helmResources struct {
+	Requests map[string]string `yaml:"requests"`
+	Limits   map[string]string `yaml:"limits"`
+}
+
+type helmIngress struct {
+	Enabled     bool              `yaml:"enabled"`
+	ClassName   string            `yaml:"className,omitempty"`
+	Host        string            `yaml:"host,omitempty"`
+	TLSSecret   string            `yaml:"tlsSecret,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type helmBootstrap struct {
+	Script []string `yaml:"script"`
+	// Policies are the consumer policy documents, by name.
+	Policies map[string]string `yaml:"policies,omitempty"`
+	// Lifecycles are the bucket lifecycle documents, by file name.
+	Lifecycles map[string]string `yaml:"lifecycles,omitempty"`
+}
+
+type helmAudit struct {
+	Webhook string `yaml:"webhook"`
+}
+
+// newHelmValues renders the parameters of the kustomize templates as chart
+// values. Credentials are only inlined when not restricted.
+func newHelmValues(name string, image *resources.DockerImage, parameters *deploymentTemplateParameters, accessKey string, secretKey string) *helmValues {
+	values := &helmValues{
+		Name: name,
+		Image: helmImage{
+			Repository: image.Name,
+			Tag:        image.Tag,
+			Digest:     image.Digest,
+			PullSecret: parameters.ImagePullSecret,
+		},
+		Region:  parameters.Region,
+		Console: parameters.Console,
+		Storage: helmStorage{
+			Ephemeral: parameters.Ephemeral,
+			Size:      parameters.StorageSize,
+		},
+		Resources: helmResources{
+			Requests: map[string]string{"cpu": "100m", "memory": "128Mi"},
+			Limits:   map[string]string{"cpu": "500m", "memory": "512Mi"},
+		},
+	}
+	if values.Storage.Size == "" {
+		values.Storage.Size = DefaultStorageSize
+	}
+	if parameters.AccessKeyReference != nil && parameters.SecretKeyReference != nil {
+		values.Credentials.ExistingSecret = &helmExistingSecret{
+			AccessKey: helmSecretKeyReference{Name: parameters.AccessKeyReference.GetName(), Key: parameters.AccessKeyReference.GetKey()},
+			SecretKey: helmSecretKeyReference{Name: parameters.SecretKeyReference.GetName(), Key: parameters.SecretKeyReference.GetKey()},
+		}
+	} else {
+		values.Credentials.AccessKey = accessKey
+		values.Credentials.SecretKey = secretKey
+		if parameters.Bootstrap != nil {
+			values.Credentials.Consumers = parameters.Bootstrap.Users
+		}
+		for _, consumer := range parameters.Consumers {
+			values.ConsumerSecrets = append(values.ConsumerSecrets, &helmConsumerSecret{Consumer: consumer.Consumer, User: consumer.User, Keys: consumer.Data})
+		}
+	}
+	if bootstrap := parameters.Bootstrap; bootstrap != nil {
+		values.Bootstrap = &helmBootstrap{Script: bootstrap.Script}
+		for _, policy := range bootstrap.Policies {
+			if values.Bootstrap.Policies == nil {
+				values.Bootstrap.Policies = make(map[string]string)
+			}
+			values.Bootstrap.Policies[policy.Name] = policy.Document
+		}
+		for _, lifecycle := range bootstrap.Lifecycles {
+			if lifecycle.File == "" {
+				continue
+			}
+			if values.Bootstrap.Lifecycles == nil {
+				values.Bootstrap.Lifecycles = make(map[string]string)
+			}
+			values.Bootstrap.Lifecycles[lifecycle.File] = lifecycle.Document
+		}
+	}
+	if parameters.AuditWebhook != "" {
+		values.Audit = &helmAudit{Webhook: parameters.AuditWebhook}
+	}
+	return values
+}
+
+// helmChart is the Chart.yaml of the chart.
+type helmChart struct {
+	APIVersion  string `yaml:"apiVersion"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Type        string `yaml:"type"`
+	Version     string `yaml:"version"`
+	AppVersion  string `yaml:"appVersion"`
+}
+
+// writeHelmChart writes Chart.yaml, values.yaml and the chart templates, which
+// are copied as they are: they are Helm templates, rendered at install time.
+func writeHelmChart(dir string, chart *helmChart, values *helmValues) error {
+	if err := os.MkdirAll(filepath.Join(dir, "templates"), 0o755); err != nil {
+		return err
+	}
+	for file, content := range map[string]any{"Chart.yaml": chart, "values.yaml": values} {
+		out, err := yaml.Marshal(content)
+		if err != nil {
+			return err
+		}
+		if err = os.WriteFile(filepath.Join(dir, file), out, 0o644); err != nil {
+			return err
+		}
+	}
+	templates, err := fs.Sub(helmFS, "templates/helm/chart/templates")
+	if err != nil {
+		return err
+	}
+	return fs.WalkDir(templates, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(templates, path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, "templates", path), content, 0o644)
+	})
+}
+
+// deployHelm renders the service as a Helm chart instead of the kustomize
+// tree, with the same guarantees: a restricted render only references the
+// credentials Secret and returns the connection configuration on the
+// response, the others export it into the chart.
+func (s *Builder) deployHelm(ctx context.Context, req *builderv0.DeploymentRequest, image *resources.DockerImage) (*builderv0.DeploymentResponse, error) {
+	kubernetes := req.GetDeployment().GetKubernetes()
+	if kubernetes == nil {
+		return nil, s.Wool.NewError("helm output needs a Kubernetes deployment")
+	}
+	restricted := services.IsRestrictedOutputProfile(kubernetes.GetProfile())
+	parameters := &deploymentTemplateParameters{}
+	configuration, err := s.prepareParameters(ctx, req, kubernetes.GetSecretReferences(), restricted, parameters)
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot prepare the chart")
+	}
+	release, err := s.release()
+	if err != nil {
+		return nil, err
+	}
+	name := resources.ToServiceWithCase(s.Identity).Name.DNSCase
+	chart := &helmChart{
+		APIVersion:  "v2",
+		Name:        name,
+		Description: fmt.Sprintf("MinIO service %s, rendered by the codefly minio agent", s.Unique()),
+		Type:        "application",
+		Version:     agent.Version,
+		AppVersion:  release.Tag,
+	}
+	accessKey, secretKey := s.accessKey, s.secretKey
+	if restricted {
+		accessKey, secretKey = "", ""
+	}
+	values := newHelmValues(name, image, parameters, accessKey, secretKey)
+	if !restricted {
+		values.Configuration = exportedConfiguration(s.Unique(), configuration)
+	}
+	dir := filepath.Join(kubernetes.GetDestination(), helmChartDirectory)
+	if err = writeHelmChart(dir, chart, values); err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot write the chart")
+	}
+	s.Wool.Debug("rendered helm chart", wool.Field("chart", dir), wool.Field("restricted", restricted))
+	// The credentials are in the chart values: like the kustomize output, the
+	// response only carries the non-secret configuration.
+	return &builderv0.DeploymentResponse{
+		State:         &builderv0.DeploymentStatus{State: builderv0.DeploymentStatus_SUCCESS},
+		Configuration: withoutSecretValues(configuration),
+	}, nil
+}
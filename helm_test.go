@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+	builderv0 "github.com/codefly-dev/core/generated/go/codefly/services/builder/v0"
+	"github.com/codefly-dev/core/resources"
+	"gopkg.in/yaml.v3"
+)
+
+func TestHelmValuesOnlyReferenceRestrictedCredentials(t *testing.T) {
+	parameters := &deploymentTemplateParameters{
+		AccessKeyReference: &builderv0.KubernetesSecretKeyReference{Name: "minio-root", Key: "access-key"},
+		SecretKeyReference: &builderv0.KubernetesSecretKeyReference{Name: "minio-root", Key: "secret-key"},
+	}
+	values := newHelmValues("storage", image, parameters, "", "")
+	if values.Credentials.ExistingSecret == nil || values.Credentials.AccessKey != "" || values.Credentials.SecretKey != "" {
+		t.Fatalf("credentials = %+v", values.Credentials)
+	}
+	if values.Image.Digest != image.Digest || values.Storage.Size != DefaultStorageSize {
+		t.Errorf("values = %+v", values)
+	}
+
+	consumers := consumerSecrets([]*consumerCredentials{{Consumer: "backend/api", AccessKey: "backend-api", SecretKey: "api-secret"}})
+	inline := newHelmValues("storage", image, &deploymentTemplateParameters{Consumers: consumers}, "minio", "generated-secret-key")
+	if inline.Credentials.ExistingSecret != nil || inline.Credentials.SecretKey != "generated-secret-key" {
+		t.Errorf("inline credentials = %+v", inline.Credentials)
+	}
+	if len(inline.ConsumerSecrets) != 1 || inline.ConsumerSecrets[0].User != "backend-api" || inline.ConsumerSecrets[0].Keys["AWS_SECRET_ACCESS_KEY"] != "api-secret" {
+		t.Errorf("consumer secrets = %+v", inline.ConsumerSecrets)
+	}
+}
+
+func TestHelmExportsTheConfiguration(t *testing.T) {
+	configuration := &basev0.Configuration{Infos: []*basev0.ConfigurationInformation{{
+		Name: "minio",
+		ConfigurationValues: []*basev0.ConfigurationValue{
+			{Key: "MINIO_ENDPOINT", Value: "http://storage:9000"},
+			{Key: "MINIO_SECRET_KEY", Value: "generated-secret-key", Secret: true},
+		},
+	}}}
+	exported := exportedConfiguration("store/storage", configuration)
+	endpoint := resources.ServiceConfigurationKeyFromUnique("store/storage", "minio", "MINIO_ENDPOINT")
+	secretKey := resources.ServiceSecretConfigurationKeyFromUnique("store/storage", "minio", "MINIO_SECRET_KEY")
+	if len(exported.Values) != 1 || exported.Values[endpoint] != "http://storage:9000" {
+		t.Errorf("values = %v", exported.Values)
+	}
+	if len(exported.Secrets) != 1 || exported.Secrets[secretKey] != "generated-secret-key" {
+		t.Errorf("secrets = %v", exported.Secrets)
+	}
+}
+
+func TestWriteHelmChart(t *testing.T) {
+	dir := t.TempDir()
+	values := newHelmValues("storage", image, &deploymentTemplateParameters{
+		AccessKeyReference: &builderv0.KubernetesSecretKeyReference{Name: "minio-root", Key: "access-key"},
+		SecretKeyReference: &builderv0.KubernetesSecretKeyReference{Name: "minio-root", Key: "secret-key"},
+		Bootstrap: &bootstrapParameters{
+			Script:     []string{"set -e"},
+			Policies:   []*ConsumerPolicy{{Name: "api-buckets", Document: "{}"}},
+			Lifecycles: []*bucketLifecycle{{Bucket: "uploads", File: "lifecycle-uploads.json", Document: `{"Rules":[]}`}},
+		},
+	}, "", "")
+	if err := writeHelmChart(dir, &helmChart{APIVersion: "v2", Name: "storage", Version: "0.0.1"}, values); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "values.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written helmValues
+	if err = yaml.Unmarshal(content, &written); err != nil {
+		t.Fatal(err)
+	}
+	if written.Credentials.ExistingSecret.SecretKey.Key != "secret-key" || written.Bootstrap.Policies["api-buckets"] != "{}" ||
+		written.Bootstrap.Lifecycles["lifecycle-uploads.json"] != `{"Rules":[]}` {
+		t.Errorf("values.yaml = %s", content)
+	}
+
+	// Helm functions are not available here: the templates are only parsed.
+	helm := template.FuncMap{}
+	for _, name := range []string{"include", "nindent", "toYaml", "b64enc", "required", "quote"} {
+		helm[name] = func(...any) string { return "" }
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "templates", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 9 {
+		t.Fatalf("templates = %v", files)
+	}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = template.New(filepath.Base(file)).Funcs(helm).Parse(string(content)); err != nil {
+			t.Errorf("%s: %v", file, err)
+		}
+		if strings.Contains(string(content), "stringData") {
+			t.Errorf("%s embeds secret data", file)
+		}
+	}
+}
+
+func TestValidateDeploymentFormat(t *testing.T) {
+	for _, format := range []string{"", KustomizeFormat, HelmFormat} {
+		if err := validateDeploymentFormat(format); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := validateDeploymentFormat("jsonnet"); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// validateLifecycle checks the lifecycle rules of a bucket. Errors name the
+// bucket and the rule.
+func validateLifecycle(bucket *BucketSettings) error {
+	ids := make(map[string]bool)
+	for i, rule := range bucket.Lifecycle {
+		name := rule.ID
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		invalid := func(format string, args ...any) error {
+			return fmt.Errorf("bucket %s: lifecycle rule %s: %s", bucket.Name, name, fmt.Sprintf(format, args...))
+		}
+		switch {
+		case rule.ID == "":
+			return invalid("needs an id")
+		case len(rule.ID) > 255:
+			return invalid("id is longer than 255 characters")
+		case ids[rule.ID]:
+			return invalid("id is used by another rule")
+		case strings.HasPrefix(rule.Prefix, "/"):
+			return invalid("prefix %q must not start with /", rule.Prefix)
+		case rule.ExpirationDays < 0, rule.NoncurrentExpirationDays < 0, rule.AbortIncompleteMultipartDays < 0:
+			return invalid("days must be positive")
+		case rule.ExpirationDays == 0 && rule.NoncurrentExpirationDays == 0 && rule.AbortIncompleteMultipartDays == 0:
+			return invalid("needs expiration-days, noncurrent-expiration-days or abort-incomplete-multipart-days")
+		case rule.NoncurrentExpirationDays > 0 && !bucket.Versioning:
+			return invalid("noncurrent-expiration-days needs versioning: the bucket has no noncurrent versions")
+		}
+		ids[rule.ID] = true
+	}
+	return nil
+}
+
+// validateBucketLifecycles checks the rules of every declared bucket.
+func validateBucketLifecycles(buckets []*BucketSettings) error {
+	for _, bucket := range buckets {
+		if err := validateLifecycle(bucket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lifecycleDocument is the JSON lifecycle configuration `mc ilm rule import`
+// reads.
+func lifecycleDocument(rules []*LifecycleRule) (string, error) {
+	content, err := json.Marshal(lifecycleConfiguration(rules))
+	return string(content), err
+}
+
+// applyLifecycle sets the lifecycle of an existing bucket when it differs
+// from the settings, and tells whether it did. Rules removed from the
+// settings are removed from the bucket, and an empty list removes them all.
+// Like the bootstrap, a bucket that does not declare a lifecycle keeps the
+// one set on the server.
+func applyLifecycle(ctx context.Context, client *minio.Client, bucket *BucketSettings) (bool, error) {
+	if bucket.Lifecycle == nil {
+		return false, nil
+	}
+	current, err := client.GetBucketLifecycle(ctx, bucket.Name)
+	if err != nil && minio.ToErrorResponse(err).Code != "NoSuchLifecycleConfiguration" {
+		return false, err
+	}
+	if summary(lifecycleRules(current)) == summary(bucket.Lifecycle) {
+		return false, nil
+	}
+	return true, applyBucketProperty(ctx, client, bucket, LifecycleProperty)
+}
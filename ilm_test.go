@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"gopkg.in/yaml.v3"
+)
+
+func TestValidateLifecycle(t *testing.T) {
+	valid := &BucketSettings{Name: "uploads", Versioning: true, Lifecycle: []*LifecycleRule{
+		{ID: "tmp", Prefix: "tmp/", ExpirationDays: 1},
+		{ID: "versions", NoncurrentExpirationDays: 30},
+		{ID: "uploads", AbortIncompleteMultipartDays: 7},
+	}}
+	if err := validateLifecycle(valid); err != nil {
+		t.Error(err)
+	}
+	for _, test := range []struct {
+		rules    []*LifecycleRule
+		expected string
+	}{
+		{[]*LifecycleRule{{ExpirationDays: 1}}, "lifecycle rule #1: needs an id"},
+		{[]*LifecycleRule{{ID: "tmp", ExpirationDays: 1}, {ID: "tmp", ExpirationDays: 2}}, "lifecycle rule tmp: id is used"},
+		{[]*LifecycleRule{{ID: "tmp"}}, "lifecycle rule tmp: needs expiration-days"},
+		{[]*LifecycleRule{{ID: "tmp", ExpirationDays: -1}}, "lifecycle rule tmp: days must be positive"},
+		{[]*LifecycleRule{{ID: "tmp", Prefix: "/tmp", ExpirationDays: 1}}, "lifecycle rule tmp: prefix"},
+		{[]*LifecycleRule{{ID: "versions", NoncurrentExpirationDays: 30}}, "lifecycle rule versions: noncurrent-expiration-days needs versioning"},
+	} {
+		err := validateBucketLifecycles([]*BucketSettings{{Name: "reports"}, {Name: "uploads", Lifecycle: test.rules}})
+		if err == nil || !strings.HasPrefix(err.Error(), "bucket uploads: ") || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: %v", test.expected, err)
+		}
+	}
+}
+
+func TestLifecycleDocument(t *testing.T) {
+	rules := []*LifecycleRule{{ID: "tmp", Prefix: "tmp/", ExpirationDays: 1, AbortIncompleteMultipartDays: 2}}
+	document, err := lifecycleDocument(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var configuration lifecycle.Configuration
+	if err = json.Unmarshal([]byte(document), &configuration); err != nil {
+		t.Fatalf("%s: %v", document, err)
+	}
+	if got := summary(lifecycleRules(&configuration)); got != summary(rules) {
+		t.Errorf("rules of %s = %s", document, got)
+	}
+}
+
+func TestBootstrapImportsLifecycles(t *testing.T) {
+	lifecycles, err := bucketLifecycles([]*BucketSettings{
+		{Name: "uploads", Lifecycle: []*LifecycleRule{{ID: "tmp", ExpirationDays: 1}}},
+		{Name: "reports"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lifecycles) != 1 || lifecycles[0].File != "lifecycle-uploads.json" {
+		t.Fatalf("lifecycles = %+v", lifecycles)
+	}
+	script := strings.Join(bootstrapScript([]string{"uploads", "reports"}, nil, lifecycles, false), "\n")
+	if !strings.HasSuffix(script, "mc mb --ignore-existing 'minio/reports'\nmc ilm rule import 'minio/uploads' < '/bootstrap/lifecycle-uploads.json'") {
+		t.Errorf("script = %s", script)
+	}
+}
+
+func TestApplyLifecycleToExistingBucket(t *testing.T) {
+	ctx := context.Background()
+	client := fakeClient(t)
+	if err := client.MakeBucket(ctx, "uploads", minio.MakeBucketOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	bucket := &BucketSettings{Name: "uploads", Lifecycle: []*LifecycleRule{{ID: "tmp", Prefix: "tmp/", ExpirationDays: 1}}}
+	for i, expected := range []bool{true, false} {
+		changed, err := applyLifecycle(ctx, client, bucket)
+		if err != nil || changed != expected {
+			t.Errorf("apply %d: %v, %v", i, changed, err)
+		}
+	}
+	state, err := readBucketState(ctx, client, "uploads")
+	if err != nil || summary(state.Lifecycle) != summary(bucket.Lifecycle) {
+		t.Errorf("lifecycle = %v, %v", state, err)
+	}
+
+	bucket.Lifecycle = []*LifecycleRule{{ID: "uploads", AbortIncompleteMultipartDays: 7}}
+	if changed, err := applyLifecycle(ctx, client, bucket); err != nil || !changed {
+		t.Errorf("replace: %v, %v", changed, err)
+	}
+	if state, err = readBucketState(ctx, client, "uploads"); err != nil || summary(state.Lifecycle) != summary(bucket.Lifecycle) {
+		t.Errorf("rules removed from the settings are left: %v, %v", state, err)
+	}
+
+	// A bucket without rules keeps the lifecycle of the server.
+	declared := bucket.Lifecycle
+	bucket.Lifecycle = nil
+	if changed, err := applyLifecycle(ctx, client, bucket); err != nil || changed {
+		t.Errorf("bucket without rules: %v, %v", changed, err)
+	}
+	if state, err = readBucketState(ctx, client, "uploads"); err != nil || summary(state.Lifecycle) != summary(declared) {
+		t.Errorf("lifecycle of the server = %v, %v", state, err)
+	}
+
+	// An empty list removes the rules.
+	bucket.Lifecycle = LifecycleRules{}
+	for i, expected := range []bool{true, false} {
+		changed, err := applyLifecycle(ctx, client, bucket)
+		if err != nil || changed != expected {
+			t.Errorf("clear %d: %v, %v", i, changed, err)
+		}
+	}
+	if state, err = readBucketState(ctx, client, "uploads"); err != nil || len(state.Lifecycle) != 0 {
+		t.Errorf("rules left by an empty list = %v, %v", state, err)
+	}
+}
+
+func TestEmptyLifecycleIsDeclared(t *testing.T) {
+	var settings struct {
+		Buckets []*BucketSettings `yaml:"buckets"`
+	}
+	content := "buckets:\n  - name: uploads\n    lifecycle: []\n  - name: reports\n"
+	if err := yaml.Unmarshal([]byte(content), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings.Buckets[0].Lifecycle == nil || settings.Buckets[1].Lifecycle != nil {
+		t.Fatalf("lifecycles = %#v, %#v", settings.Buckets[0].Lifecycle, settings.Buckets[1].Lifecycle)
+	}
+	written, err := yaml.Marshal(&settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(written), "lifecycle: []") || strings.Count(string(written), "lifecycle") != 1 {
+		t.Errorf("written = %s", written)
+	}
+
+	lifecycles, err := bucketLifecycles(settings.Buckets)
+	if err != nil || len(lifecycles) != 1 || lifecycles[0].File != "" {
+		t.Fatalf("lifecycles = %+v, %v", lifecycles, err)
+	}
+	script := strings.Join(bootstrapScript([]string{"uploads"}, nil, lifecycles, false), "\n")
+	if !strings.HasSuffix(script, "if mc ilm rule ls 'minio/uploads' >/dev/null 2>&1; then mc ilm rule rm --all --force 'minio/uploads'; fi") {
+		t.Errorf("script = %s", script)
+	}
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"os"
+	"regexp"
 	"strings"
 	"testing"
+
+	"github.com/codefly-dev/core/resources"
 )
 
 func TestRuntimeImageIsImmutable(t *testing.T) {
@@ -22,3 +25,95 @@ func TestDeploymentImageIsImmutable(t *testing.T) {
 		t.Fatalf("MinIO deployment must render the shared pinned image declaration: %s", deployment)
 	}
 }
+
+var (
+	releaseTagPattern = regexp.MustCompile(`^RELEASE\.\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2}Z$`)
+	digestPattern     = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+)
+
+func TestEveryReleaseIsDigestPinned(t *testing.T) {
+	if len(Releases) == 0 {
+		t.Fatal("no vetted MinIO release")
+	}
+	for i, release := range Releases {
+		if !releaseTagPattern.MatchString(release.Tag) {
+			t.Errorf("release %q is not a MinIO release tag", release.Tag)
+		}
+		if !digestPattern.MatchString(release.Digest) {
+			t.Errorf("release %s is not pinned: index digest %q", release.Tag, release.Digest)
+		}
+		if len(release.Platforms) == 0 {
+			t.Errorf("release %s has no image digest per architecture", release.Tag)
+		}
+		for _, arch := range []string{"amd64", "arm64"} {
+			if _, ok := release.Platforms[arch]; !ok && len(release.Platforms) > 0 {
+				t.Errorf("release %s has no %s image digest", release.Tag, arch)
+			}
+		}
+		for arch, digest := range release.Platforms {
+			if !digestPattern.MatchString(digest) {
+				t.Errorf("release %s is not pinned on %s: digest %q", release.Tag, arch, digest)
+			}
+			if digest == release.Digest {
+				t.Errorf("release %s pins %s to the index digest", release.Tag, arch)
+			}
+		}
+		if i > 0 && Releases[i-1].Tag <= release.Tag {
+			t.Errorf("releases must be listed newest first and once: %s before %s", Releases[i-1].Tag, release.Tag)
+		}
+	}
+}
+
+func TestRuntimeAndDeploymentUseTheChosenRelease(t *testing.T) {
+	for _, release := range Releases {
+		service := &Service{Settings: &Settings{Release: release.Tag}}
+		runtimeImage, err := service.runtimeImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		chosen, err := service.release()
+		if err != nil {
+			t.Fatal(err)
+		}
+		deploymentImage := chosen.DeploymentImage()
+		if runtimeImage.Name != deploymentImage.Name || runtimeImage.Tag != deploymentImage.Tag || runtimeImage.Tag != release.Tag {
+			t.Errorf("runtime runs %s:%s, deployment renders %s:%s", runtimeImage.Name, runtimeImage.Tag, deploymentImage.Name, deploymentImage.Tag)
+		}
+		if runtimeImage.Digest == "" || deploymentImage.Digest == "" {
+			t.Errorf("release %s is not pinned by digest", release.Tag)
+		}
+	}
+	if _, err := FindRelease("RELEASE.2000-01-01T00-00-00Z"); err == nil {
+		t.Error("an unknown release must be rejected")
+	}
+}
+
+func TestMirrorKeepsTheDigest(t *testing.T) {
+	service := &Service{Settings: &Settings{Mirror: "registry.example.com:5000/dockerhub", ImagePullSecret: "regcred"}}
+	runtimeImage, err := service.runtimeImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deploymentImage, err := service.deploymentImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mirroredImage := range []*resources.DockerImage{runtimeImage, deploymentImage} {
+		if mirroredImage.Name != "registry.example.com:5000/dockerhub/minio/minio" {
+			t.Errorf("mirrored image name = %s", mirroredImage.Name)
+		}
+		if mirroredImage.Tag != image.Tag || !digestPattern.MatchString(mirroredImage.Digest) {
+			t.Errorf("mirrored image is not pinned: %+v", mirroredImage)
+		}
+	}
+
+	for _, settings := range []*Settings{
+		{Mirror: "https://registry.example.com"},
+		{Mirror: "registry.example.com/minio:latest"},
+		{ImagePullSecret: "Reg_Cred"},
+	} {
+		if err = validateImageSettings(settings); err == nil {
+			t.Errorf("settings %+v must be rejected", settings)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// lifecycleState is where the runtime is in its lifecycle.
+type lifecycleState int
+
+const (
+	stateUnloaded lifecycleState = iota
+	stateLoaded
+	stateInitialized
+	stateRunning
+	stateStopped
+	stateDestroyed
+)
+
+func (l lifecycleState) String() string {
+	switch l {
+	case stateLoaded:
+		return "loaded"
+	case stateInitialized:
+		return "initialized"
+	case stateRunning:
+		return "running"
+	case stateStopped:
+		return "stopped"
+	case stateDestroyed:
+		return "destroyed"
+	}
+	return "unloaded"
+}
+
+// runtimeStatus is what Information reports of the fields the RPCs own. The
+// RPCs publish it as they leave: Information never waits for them.
+type runtimeStatus struct {
+	backend string
+	server  string
+	// watcher and namespaces lock their own state.
+	watcher    *healthWatcher
+	watching   bool
+	namespaces *namespaceBroker
+	operations string
+	snapshots  string
+}
+
+// runtimeLifecycle serializes the RPCs of the runtime and checks their
+// order. An RPC enters in one of the states it is allowed in, moves the state
+// on success and leaves.
+type runtimeLifecycle struct {
+	mu sync.Mutex
+	// rpc holds a token while an RPC runs.
+	rpc    chan struct{}
+	state  lifecycleState
+	status runtimeStatus
+}
+
+// enter waits for the running RPC, if any, and fails with FailedPrecondition
+// when the RPC is not allowed in the current state. Leave must be called when
+// it succeeds.
+func (l *runtimeLifecycle) enter(rpc string, allowed ...lifecycleState) error {
+	return l.enterContext(context.Background(), rpc, allowed...)
+}
+
+// enterContext stops waiting when the context is done.
+func (l *runtimeLifecycle) enterContext(ctx context.Context, rpc string, allowed ...lifecycleState) error {
+	select {
+	case l.token() <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	state := l.State()
+	for _, s := range allowed {
+		if s == state {
+			return nil
+		}
+	}
+	l.leave()
+	var expected []string
+	for _, s := range allowed {
+		expected = append(expected, s.String())
+	}
+	return status.Errorf(codes.FailedPrecondition, "%s: minio is %s, expected %s", rpc, state, strings.Join(expected, " or "))
+}
+
+func (l *runtimeLifecycle) leave() {
+	<-l.token()
+}
+
+func (l *runtimeLifecycle) token() chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rpc == nil {
+		l.rpc = make(chan struct{}, 1)
+	}
+	return l.rpc
+}
+
+// set moves the state, from inside an RPC.
+func (l *runtimeLifecycle) set(state lifecycleState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state = state
+}
+
+// State can be read at any time, also during an RPC.
+func (l *runtimeLifecycle) State() lifecycleState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state
+}
+
+// publish replaces the status, from inside an RPC.
+func (l *runtimeLifecycle) publish(status runtimeStatus) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.status = status
+}
+
+// Status is the one the last RPC published.
+func (l *runtimeLifecycle) Status() runtimeStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.status
+}
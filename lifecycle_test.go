@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	runtimev0 "github.com/codefly-dev/core/generated/go/codefly/services/runtime/v0"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestOutOfOrderRPCsFailWithPrecondition(t *testing.T) {
+	ctx := context.Background()
+	runtime := NewRuntime()
+	_, err := runtime.Start(ctx, &runtimev0.StartRequest{})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Start before Init: %v", err)
+	}
+	_, err = runtime.Init(ctx, &runtimev0.InitRequest{})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Init before Load: %v", err)
+	}
+	if _, err = runtime.Test(ctx, &runtimev0.TestRequest{}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Test before Start: %v", err)
+	}
+	if runtime.lifecycle.State() != stateUnloaded {
+		t.Errorf("state = %s", runtime.lifecycle.State())
+	}
+}
+
+func TestLifecycleTransitions(t *testing.T) {
+	var l runtimeLifecycle
+	for _, step := range []struct {
+		rpc     string
+		allowed []lifecycleState
+		next    lifecycleState
+	}{
+		{"Load", []lifecycleState{stateUnloaded}, stateLoaded},
+		{"Init", []lifecycleState{stateLoaded}, stateInitialized},
+		{"Start", []lifecycleState{stateInitialized}, stateRunning},
+		{"Stop", []lifecycleState{stateRunning}, stateStopped},
+		{"Destroy", []lifecycleState{stateStopped}, stateDestroyed},
+	} {
+		if err := l.enter(step.rpc, step.allowed...); err != nil {
+			t.Fatal(err)
+		}
+		l.set(step.next)
+		l.leave()
+	}
+	err := l.enter("Start", stateInitialized, stateStopped)
+	if status.Code(err) != codes.FailedPrecondition || status.Convert(err).Message() != "Start: minio is destroyed, expected initialized or stopped" {
+		t.Errorf("Start after Destroy: %v", err)
+	}
+}
+
+func TestLifecycleSerializesRPCs(t *testing.T) {
+	var l runtimeLifecycle
+	var wg sync.WaitGroup
+	counter := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.enter("Start", stateUnloaded, stateRunning); err != nil {
+				t.Error(err)
+				return
+			}
+			defer l.leave()
+			counter++
+			l.set(stateRunning)
+		}()
+	}
+	wg.Wait()
+	if counter != 50 || l.State() != stateRunning {
+		t.Errorf("counter = %d, state = %s", counter, l.State())
+	}
+}
+
+func TestEnterContextStopsWaiting(t *testing.T) {
+	var l runtimeLifecycle
+	if err := l.enter("Start", stateUnloaded); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.enterContext(ctx, "restart", stateUnloaded); !errors.Is(err, context.Canceled) {
+		t.Errorf("enter while an RPC runs = %v", err)
+	}
+	l.leave()
+	if err := l.enter("Stop", stateUnloaded); err != nil {
+		t.Error(err)
+	}
+	l.leave()
+}
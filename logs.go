@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+// logger is the part of Wool the MinIO logs go to.
+type logger interface {
+	Debug(msg string, fields ...*wool.LogField)
+	Info(msg string, fields ...*wool.LogField)
+	Warn(msg string, fields ...*wool.LogField)
+	Error(msg string, fields ...*wool.LogField)
+	Write(p []byte) (int, error)
+}
+
+// logRecord is a line of `minio server --json`.
+type logRecord struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+	API     *struct {
+		Name string `json:"name"`
+		Args *struct {
+			Bucket string `json:"bucket"`
+			Object string `json:"object"`
+		} `json:"args"`
+	} `json:"api"`
+	RequestID  string `json:"requestID"`
+	RemoteHost string `json:"remotehost"`
+	Error      *struct {
+		Message string   `json:"message"`
+		Source  []string `json:"source"`
+	} `json:"error"`
+}
+
+// fields of the record, omitting empty ones.
+func (r *logRecord) fields() []*wool.LogField {
+	var fields []*wool.LogField
+	add := func(key string, value string) {
+		if value != "" {
+			fields = append(fields, wool.Field(key, value))
+		}
+	}
+	if r.API != nil {
+		add("api", r.API.Name)
+		if r.API.Args != nil {
+			add("bucket", r.API.Args.Bucket)
+			add("object", r.API.Args.Object)
+		}
+	}
+	add("request-id", r.RequestID)
+	add("remote-host", r.RemoteHost)
+	if r.Error != nil {
+		add("error", r.Error.Message)
+		if len(r.Error.Source) > 0 {
+			add("source", r.Error.Source[0])
+		}
+	}
+	return fields
+}
+
+// message falls back on the error for records without one.
+func (r *logRecord) message() string {
+	if r.Message != "" {
+		return r.Message
+	}
+	if r.Error != nil && r.Error.Message != "" {
+		return r.Error.Message
+	}
+	if r.API != nil {
+		return r.API.Name
+	}
+	return "minio"
+}
+
+// minioLogWriter parses the JSON logs of MinIO into Wool entries at their
+// level. Lines that are not JSON records pass through.
+type minioLogWriter struct {
+	logger logger
+	mu     sync.Mutex
+	buffer []byte
+}
+
+func newMinIOLogWriter(l logger) *minioLogWriter {
+	return &minioLogWriter{logger: l}
+}
+
+// Write buffers partial lines: the container output is not line aligned.
+func (m *minioLogWriter) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buffer = append(m.buffer, p...)
+	for {
+		i := bytes.IndexByte(m.buffer, '\n')
+		if i < 0 {
+			break
+		}
+		m.line(m.buffer[:i])
+		m.buffer = m.buffer[i+1:]
+	}
+	return len(p), nil
+}
+
+func (m *minioLogWriter) line(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+	var record logRecord
+	if line[0] != '{' || json.Unmarshal(line, &record) != nil || record.Level == "" {
+		_, _ = m.logger.Write(append(line, '\n'))
+		return
+	}
+	message, fields := record.message(), record.fields()
+	switch strings.ToUpper(record.Level) {
+	case "DEBUG", "TRACE":
+		m.logger.Debug(message, fields...)
+	case "WARN", "WARNING":
+		m.logger.Warn(message, fields...)
+	case "ERROR", "FATAL":
+		m.logger.Error(message, fields...)
+	default:
+		m.logger.Info(message, fields...)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+type recordedLog struct {
+	level   string
+	message string
+	fields  map[string]any
+}
+
+type recordingLogger struct {
+	logs []recordedLog
+	raw  strings.Builder
+}
+
+func (r *recordingLogger) record(level string, msg string, fields []*wool.LogField) {
+	log := recordedLog{level: level, message: msg, fields: make(map[string]any)}
+	for _, field := range fields {
+		log.fields[field.Key] = field.Value
+	}
+	r.logs = append(r.logs, log)
+}
+
+func (r *recordingLogger) Debug(msg string, fields ...*wool.LogField) { r.record("debug", msg, fields) }
+func (r *recordingLogger) Info(msg string, fields ...*wool.LogField)  { r.record("info", msg, fields) }
+func (r *recordingLogger) Warn(msg string, fields ...*wool.LogField)  { r.record("warn", msg, fields) }
+func (r *recordingLogger) Error(msg string, fields ...*wool.LogField) { r.record("error", msg, fields) }
+func (r *recordingLogger) Write(p []byte) (int, error)                { return r.raw.Write(p) }
+
+func TestMinIOLogsBecomeWoolEntries(t *testing.T) {
+	recorder := &recordingLogger{}
+	writer := newMinIOLogWriter(recorder)
+	output := `{"level":"INFO","time":"2025-09-07T16:13:09Z","message":"MinIO Object Storage Server"}
+{"level":"ERROR","errKind":"ALL","time":"2025-09-07T16:13:10Z","api":{"name":"PutObject","args":{"bucket":"uploads","object":"a.txt"}},"remotehost":"172.17.0.1","requestID":"1863A2F","error":{"message":"Storage reached its minimum free drive threshold.","source":["cmd/object-handlers.go:1983:cmd.objectAPIHandlers.PutObjectHandler()"]}}
+not json at all
+{"level":"WARNING","message":"Detected default credentials"}
+`
+	// The container output is not line aligned.
+	for _, chunk := range []string{output[:50], output[50:300], output[300:]} {
+		if _, err := fmt.Fprint(writer, chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(recorder.logs) != 3 {
+		t.Fatalf("entries = %+v", recorder.logs)
+	}
+	if log := recorder.logs[0]; log.level != "info" || log.message != "MinIO Object Storage Server" || len(log.fields) != 0 {
+		t.Errorf("info entry = %+v", log)
+	}
+	failure := recorder.logs[1]
+	if failure.level != "error" || failure.message != "Storage reached its minimum free drive threshold." {
+		t.Errorf("error entry = %+v", failure)
+	}
+	for key, expected := range map[string]string{
+		"api":         "PutObject",
+		"bucket":      "uploads",
+		"object":      "a.txt",
+		"request-id":  "1863A2F",
+		"remote-host": "172.17.0.1",
+		"error":       "Storage reached its minimum free drive threshold.",
+	} {
+		if failure.fields[key] != expected {
+			t.Errorf("%s = %v, want %s", key, failure.fields[key], expected)
+		}
+	}
+	if log := recorder.logs[2]; log.level != "warn" {
+		t.Errorf("warning entry = %+v", log)
+	}
+	if recorder.raw.String() != "not json at all\n" {
+		t.Errorf("raw lines = %q", recorder.raw.String())
+	}
+}
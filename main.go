@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"embed"
+	"path/filepath"
+
 	"github.com/codefly-dev/core/agents"
 	"github.com/codefly-dev/core/agents/services"
 	"github.com/codefly-dev/core/builders"
@@ -12,6 +14,7 @@ import (
 	runnersbase "github.com/codefly-dev/core/runners/base"
 	"github.com/codefly-dev/core/shared"
 	"github.com/codefly-dev/core/templates"
+	"github.com/codefly-dev/core/wool"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -22,32 +25,133 @@ var agent = shared.Must(resources.LoadFromFs[resources.Agent](shared.Embed(infoF
 var requirements = builders.NewDependencies(agent.Name, builders.NewDependency("service.codefly.yaml"))
 
 type Settings struct {
+	// Region is configured on the server and exported to consumers.
+	Region string `yaml:"region,omitempty"`
+	// TLS marks the deployed S3 API as served over HTTPS. The local runtime
+	// always serves plain HTTP.
+	TLS bool `yaml:"tls,omitempty"`
+	// Console exposes the MinIO web console on its own endpoint.
+	Console bool `yaml:"console,omitempty"`
+	// Buckets declared by the service.
+	Buckets []*BucketSettings `yaml:"buckets,omitempty"`
+	// Ephemeral services keep no data across restarts.
+	Ephemeral bool `yaml:"ephemeral,omitempty"`
+	// StorageSize of the deployed data volume.
+	StorageSize string `yaml:"storage-size,omitempty"`
+	// Sync compares the settings with an instance.
+	Sync *SyncSettings `yaml:"sync,omitempty"`
+	// Release holds the service on one of the vetted MinIO releases instead
+	// of the newest one.
+	Release string `yaml:"release,omitempty"`
+	// Mirror is the registry, with an optional path, the MinIO image is
+	// pulled from instead of Docker Hub, e.g. registry.example.com/dockerhub.
+	// The image keeps its digest.
+	Mirror string `yaml:"mirror,omitempty"`
+	// ImagePullSecret names the Kubernetes Secret the deployment pulls the
+	// image with. The Secret is managed outside the agent.
+	ImagePullSecret string `yaml:"image-pull-secret,omitempty"`
+	// StorageEncrypted records that the deployed data volume is encrypted at
+	// rest, e.g. by its storage class. MinIO itself runs without a KMS.
+	StorageEncrypted bool `yaml:"storage-encrypted,omitempty"`
+	// BackupSchedule records the cron schedule on which the buckets are
+	// copied out of the deployed service, e.g. by a mirror job of the
+	// platform. The audit expects one for persistent buckets.
+	BackupSchedule string `yaml:"backup-schedule,omitempty"`
+	// DeploymentFormat of the manifests: kustomize (default) or helm.
+	DeploymentFormat string `yaml:"deployment-format,omitempty"`
+	// AuditLog sends a record of every API call to a webhook.
+	AuditLog *AuditLogSettings `yaml:"audit-log,omitempty"`
+	// Backend runs the local server: docker, native or fake. It defaults to
+	// the one of the runtime context.
+	Backend string `yaml:"backend,omitempty"`
+	// MinIOBinary is the minio server binary of the native backend. It
+	// defaults to minio in the PATH.
+	MinIOBinary string `yaml:"minio-binary,omitempty"`
+	// StopMode is what Stop does to the local server: keep-alive (default),
+	// pause or stop.
+	StopMode string `yaml:"stop-mode,omitempty"`
+	// AutoRestart restarts the local server when it crashes.
+	AutoRestart bool `yaml:"auto-restart,omitempty"`
+	// Namespaces hands out isolated buckets to parallel test suites.
+	Namespaces *NamespaceSettings `yaml:"namespaces,omitempty"`
+}
+
+// BucketSettings declares one bucket of the service and its configuration.
+type BucketSettings struct {
+	Name string `yaml:"name"`
+	// Versioning keeps every version of the objects.
+	Versioning bool `yaml:"versioning,omitempty"`
+	// Policy is the anonymous access to the bucket: none (default), readonly,
+	// writeonly or readwrite.
+	Policy string `yaml:"policy,omitempty"`
+	// Lifecycle rules of the bucket. Without the key the bucket keeps the
+	// lifecycle set on the server; an empty list removes it.
+	Lifecycle LifecycleRules `yaml:"lifecycle,omitempty"`
+	// Notifications sent to targets configured on the server.
+	Notifications []*BucketNotification `yaml:"notifications,omitempty"`
+	// Critical buckets hold data that cannot be recreated: the audit expects
+	// them versioned and backed up.
+	Critical bool `yaml:"critical,omitempty"`
+	// Seed is a directory of the service whose files the local runtime
+	// uploads into a new bucket, and again on reset.
+	Seed string `yaml:"seed,omitempty"`
 }
 
+// DefaultRegion is the region S3 SDKs assume when none is configured.
+const DefaultRegion = "us-east-1"
+
+// ConsoleEndpointName is the endpoint of the web console, only created when
+// the console is exposed.
+const ConsoleEndpointName = "console"
+
+// Ports MinIO listens on inside its container.
+const (
+	minioPort   uint16 = 9000
+	consolePort uint16 = 9001
+)
+
 const HotReload = "hot-reload"
 const DatabaseName = "database-name"
 
-var image = &resources.DockerImage{
-	Name:   "minio/minio",
-	Tag:    "RELEASE.2025-09-07T16-13-09Z",
-	Digest: "sha256:14cea493d9a34af32f524e538b8346cf79f3321eff8e708c1e2960462bd8936e",
-}
-
 type Service struct {
 	*services.Base
 
 	// Settings
 	*Settings
 
+	// environment the configuration is loaded for, used in error messages
+	environment string
+
+	// secure is set when the exported connection is served over TLS
+	secure bool
+
 	accessKey string
 	secretKey string
 
-	TcpEndpoint *basev0.Endpoint
+	// claims declared by dependent services, aggregated at load time
+	claims []*ConsumerClaims
+
+	// namespaces hands out test namespaces, in the local runtime only
+	namespaces *namespaceBroker
+
+	TcpEndpoint     *basev0.Endpoint
+	ConsoleEndpoint *basev0.Endpoint
+}
+
+// readmeParameters renders the agent README from the configuration schema.
+type readmeParameters struct {
+	Information   *services.Information
+	Configuration []ConfigurationField
+	Releases      []*MinIORelease
 }
 
 func (s *Service) GetAgentInformation(ctx context.Context, _ *agentv0.AgentInformationRequest) (*agentv0.AgentInformation, error) {
 
-	readme, err := templates.ApplyTemplateFrom(ctx, shared.Embed(readmeFS), "templates/agent/README.md", s.Information)
+	readme, err := templates.ApplyTemplateFrom(ctx, shared.Embed(readmeFS), "templates/agent/README.md", readmeParameters{
+		Information:   s.Information,
+		Configuration: ConfigurationSchema,
+		Releases:      Releases,
+	})
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
@@ -55,23 +159,24 @@ func (s *Service) GetAgentInformation(ctx context.Context, _ *agentv0.AgentInfor
 	return services.Advertisement{
 		Backends: runnersbase.BackendSupport{
 			Docker: true,
+			Native: true,
 		},
-		Config: []*agentv0.ConfigurationValueDetail{
-			{
-				Name: "minio", Description: "minio credentials",
-				Fields: []*agentv0.ConfigurationValueInformation{
-					{
-						Name: "access-key", Description: "access key",
-					}, {
-						Name: "secret-key", Description: "secret key",
-					},
-				},
-			},
-		},
+		Config: advertisedConfiguration(),
 		ReadMe: readme,
 	}.Build(), nil
 }
 
+// localDataPath is where the local runtime keeps the data of a persistent
+// service: in the workspace, outside of the service sources.
+func (s *Service) localDataPath() string {
+	return filepath.Join(s.Identity.WorkspacePath, ".codefly", "data", s.Identity.Module, s.Identity.Name)
+}
+
+// runDirectory holds the state of the local server in the workspace.
+func (s *Service) runDirectory() string {
+	return filepath.Join(s.Identity.WorkspacePath, ".codefly", "run", s.Identity.Module, s.Identity.Name)
+}
+
 func NewService() *Service {
 	return &Service{
 		Base:     services.NewServiceBase(context.Background(), agent.Of(resources.ServiceAgent)),
@@ -79,34 +184,30 @@ func NewService() *Service {
 	}
 }
 
-func (s *Service) LoadConfiguration(ctx context.Context, conf *basev0.Configuration) error {
+func (s *Service) LoadConfiguration(_ context.Context, conf *basev0.Configuration) error {
 	defer s.Wool.Catch()
-	ctx = s.Wool.Inject(ctx)
-	var err error
-	s.accessKey, err = resources.GetConfigurationValue(ctx, conf, "minio", "MINIO_ACCESS_KEY")
+	values, err := ParseConfiguration(conf, s.environment)
 	if err != nil {
-		return s.Wool.Wrapf(err, "cannot get access key")
-	}
-	s.secretKey, err = resources.GetConfigurationValue(ctx, conf, "minio", "MINIO_SECRET_KEY")
-	if err != nil {
-		return s.Wool.Wrapf(err, "cannot get secret key")
+		return s.Wool.Wrapf(err, "invalid minio configuration")
 	}
+	s.accessKey = values[AccessKeyField.Name]
+	s.secretKey = values[SecretKeyField.Name]
 	return nil
 }
 
 func (s *Service) CreateCredentialsConfiguration(ctx context.Context, conf *basev0.Configuration, instance *basev0.NetworkInstance) (*basev0.Configuration, error) {
 	defer s.Wool.Catch()
 	ctx = s.Wool.Inject(ctx)
+	connection, err := s.connection(ctx, instance)
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot create connection")
+	}
 	outputConf := &basev0.Configuration{
 		Origin:         s.Base.Unique(),
 		RuntimeContext: resources.RuntimeContextFromInstance(instance),
 		Infos: []*basev0.ConfigurationInformation{
-			{Name: "minio",
-				ConfigurationValues: []*basev0.ConfigurationValue{
-					{Key: "endpoint", Value: instance.Address},
-					{Key: "access-key", Value: s.accessKey, Secret: true},
-					{Key: "secret-key", Value: s.secretKey, Secret: true},
-				},
+			{Name: ConfigurationName,
+				ConfigurationValues: exportedConfigurationValues(connection.values(), true),
 			},
 		},
 	}
@@ -116,21 +217,56 @@ func (s *Service) CreateCredentialsConfiguration(ctx context.Context, conf *base
 // restrictedCredentialsConfiguration advertises the connection endpoint plus
 // value-free references to MinIO's root credentials. A restricted render never
 // receives or serializes the secret values themselves; consumers resolve the
-// access and secret keys from the externally managed Secret.
-func (s *Service) restrictedCredentialsConfiguration(instance *basev0.NetworkInstance) *basev0.Configuration {
+// access and secret keys from the externally managed Secret. Without the root
+// secret key there are no consumer keys to derive: consumers keep the root
+// references.
+func (s *Service) restrictedCredentialsConfiguration(ctx context.Context, instance *basev0.NetworkInstance) (*basev0.Configuration, error) {
+	connection, err := s.connection(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+	connection.WithholdRootKeys = false
 	return &basev0.Configuration{
 		Origin:         s.Base.Unique(),
 		RuntimeContext: resources.RuntimeContextFromInstance(instance),
 		Infos: []*basev0.ConfigurationInformation{
-			{Name: "minio",
-				ConfigurationValues: []*basev0.ConfigurationValue{
-					{Key: "endpoint", Value: instance.Address},
-					{Key: "access-key", Secret: true},
-					{Key: "secret-key", Secret: true},
-				},
+			{Name: ConfigurationName,
+				ConfigurationValues: exportedConfigurationValues(connection.values(), false),
 			},
 		},
+	}, nil
+}
+
+// resolveEndpoints keeps the S3 API endpoint and, when exposed, the console.
+func (s *Service) resolveEndpoints(ctx context.Context, endpoints []*basev0.Endpoint) error {
+	var apis []*basev0.Endpoint
+	for _, endpoint := range endpoints {
+		if endpoint.Name == ConsoleEndpointName {
+			s.ConsoleEndpoint = endpoint
+			continue
+		}
+		apis = append(apis, endpoint)
 	}
+	endpoint, err := resources.FindTCPEndpoint(ctx, apis)
+	if err != nil {
+		return err
+	}
+	s.TcpEndpoint = endpoint
+	return nil
+}
+
+// loadBucketClaims aggregates the bucket claims of the dependent services
+// found in the workspace.
+func (s *Service) loadBucketClaims() error {
+	var err error
+	s.claims, err = LoadBucketClaims(s.Identity.WorkspacePath, s.Unique())
+	if err != nil {
+		return s.Wool.Wrapf(err, "cannot load bucket claims")
+	}
+	for _, consumer := range s.claims {
+		s.Wool.Debug("bucket claims", wool.Field("consumer", consumer.Consumer), wool.Field("claims", consumer.Claims))
+	}
+	return nil
 }
 
 func main() {
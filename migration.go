@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+// ServiceFile holds the settings of the service under its spec.
+const ServiceFile = "service.codefly.yaml"
+
+// settingsMigration rewrites one older shape of the settings. It reports
+// whether the spec changed.
+type settingsMigration struct {
+	description string
+	apply       func(spec *yaml.Node) bool
+}
+
+// settingsMigrations bring the settings of services created by former agent
+// versions, which had none, to their current shape.
+var settingsMigrations = []settingsMigration{
+	{
+		description: "storage-size is pinned to the default it was created with",
+		apply: func(spec *yaml.Node) bool {
+			if ephemeral := mappingValue(spec, "ephemeral"); ephemeral != nil && ephemeral.Value == "true" {
+				return false
+			}
+			return setDefault(spec, "storage-size", DefaultStorageSize)
+		},
+	},
+	{
+		description: "region is pinned to the default it was created with",
+		apply: func(spec *yaml.Node) bool {
+			return setDefault(spec, "region", DefaultRegion)
+		},
+	},
+	{
+		description: "release is pinned to the newest vetted release, so that the next agent does not upgrade the service",
+		apply: func(spec *yaml.Node) bool {
+			return setDefault(spec, "release", Releases[0].Tag)
+		},
+	},
+}
+
+// migrateServiceDocument applies the settings migrations to the spec of a
+// service file, keeping its comments and layout.
+func migrateServiceDocument(content []byte) ([]byte, []string, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, nil, fmt.Errorf("cannot parse %s: %w", ServiceFile, err)
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return content, nil, nil
+	}
+	root := document.Content[0]
+	spec := mappingValue(root, "spec")
+	if spec == nil || spec.Kind != yaml.MappingNode {
+		spec = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		root.Content = append(root.Content, scalar("spec"), spec)
+	}
+	var changes []string
+	for _, migration := range settingsMigrations {
+		if migration.apply(spec) {
+			changes = append(changes, migration.description)
+		}
+	}
+	if len(changes) == 0 {
+		return content, nil, nil
+	}
+	// An empty spec may be written {}: the migrated one is a block.
+	spec.Style &^= yaml.FlowStyle
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, nil, err
+	}
+	return out.Bytes(), changes, nil
+}
+
+// setDefault writes the default of an unset key, so that a later change of
+// the default does not silently change the service.
+func setDefault(spec *yaml.Node, key string, value string) bool {
+	current := mappingValue(spec, key)
+	if current == nil {
+		spec.Content = append(spec.Content, scalar(key), scalar(value))
+		return true
+	}
+	if current.Value != "" {
+		return false
+	}
+	current.Value = value
+	return true
+}
+
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func scalar(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+// formerFactorySecretKey is the local secret key the factory of former agent
+// versions wrote. MinIO refuses secret keys shorter than 8 characters.
+const formerFactorySecretKey = "minio"
+
+// migrateLocalCredentials replaces the secret key the former factory wrote in
+// the local credentials by a generated one, as Create now does. The change
+// names the key without its value.
+func migrateLocalCredentials(content string) (string, []string, error) {
+	lines := strings.Split(content, "\n")
+	var changes []string
+	for i, line := range lines {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || strings.TrimSpace(key) != SecretKeyField.EnvironmentKey() || strings.Trim(strings.TrimSpace(value), `"`) != formerFactorySecretKey {
+			continue
+		}
+		secret, err := generateSecret(20)
+		if err != nil {
+			return "", nil, err
+		}
+		lines[i] = SecretKeyField.EnvironmentKey() + "=" + secret
+		changes = append(changes, fmt.Sprintf("%s in %s is generated: the default of the former factory is shorter than the 8 characters MinIO accepts",
+			SecretKeyField.EnvironmentKey(), configurationFile("local", true)))
+	}
+	return strings.Join(lines, "\n"), changes, nil
+}
+
+// backendFormatBoundary is a MinIO release from which data written in an
+// older backend format is no longer readable and must be migrated.
+type backendFormatBoundary struct {
+	Release string
+	// Format of the data that the release no longer reads, as recorded in
+	// .minio.sys/format.json.
+	Format    string
+	Migration string
+}
+
+var backendFormatBoundaries = []backendFormatBoundary{
+	{
+		Release:   "RELEASE.2022-10-29T06-21-33Z",
+		Format:    "fs",
+		Migration: "the legacy filesystem backend was removed: copy the objects out with mc mirror using an older release, then into a fresh data volume",
+	},
+}
+
+// dataFormatBoundaries lists the boundaries the data of a local volume cannot
+// cross to reach the release.
+func dataFormatBoundaries(dataDir string, release string) ([]backendFormatBoundary, error) {
+	content, err := os.ReadFile(filepath.Join(dataDir, ".minio.sys", "format.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var format struct {
+		Format string `json:"format"`
+	}
+	if err = json.Unmarshal(content, &format); err != nil {
+		return nil, fmt.Errorf("cannot parse backend format of %s: %w", dataDir, err)
+	}
+	var blocking []backendFormatBoundary
+	for _, boundary := range backendFormatBoundaries {
+		if format.Format == boundary.Format && boundary.Release <= release {
+			blocking = append(blocking, boundary)
+		}
+	}
+	return blocking, nil
+}
+
+// migrateServiceFile migrates the settings in place and reloads them. It
+// returns the changes.
+func (s *Builder) migrateServiceFile() ([]string, error) {
+	file := s.Local(ServiceFile)
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot read %s", ServiceFile)
+	}
+	migrated, changes, err := migrateServiceDocument(content)
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot migrate %s", ServiceFile)
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	if err = os.WriteFile(file, migrated, 0o644); err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot write %s", ServiceFile)
+	}
+	for _, change := range changes {
+		s.Wool.Info("migrated settings", wool.Field("file", ServiceFile), wool.Field("change", change))
+	}
+	var service struct {
+		Spec yaml.Node `yaml:"spec"`
+	}
+	if err = yaml.Unmarshal(migrated, &service); err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot parse migrated %s", ServiceFile)
+	}
+	*s.Settings = Settings{}
+	if err = service.Spec.Decode(s.Settings); err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot reload migrated settings")
+	}
+	return changes, nil
+}
+
+// migrateFactoryFiles migrates the files the factory wrote in the service and
+// returns the changes. A file the user removed is left alone.
+func (s *Builder) migrateFactoryFiles() ([]string, error) {
+	file := s.Local(configurationFile("local", true))
+	info, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot read the local credentials")
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot read the local credentials")
+	}
+	migrated, changes, err := migrateLocalCredentials(string(content))
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot generate the local secret key")
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	if err = os.WriteFile(file, []byte(migrated), info.Mode().Perm()); err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot write the local credentials")
+	}
+	for _, change := range changes {
+		s.Wool.Info("migrated factory file", wool.Field("change", change))
+	}
+	return changes, nil
+}
+
+// backendFormatWarnings tell when the data of the local volume is in a
+// backend format the pinned release cannot read.
+func (s *Builder) backendFormatWarnings() ([]string, error) {
+	release, err := s.release()
+	if err != nil {
+		return nil, err
+	}
+	blocking, err := dataFormatBoundaries(s.localDataPath(), release.Tag)
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot read the backend format of the local data")
+	}
+	var warnings []string
+	for _, boundary := range blocking {
+		s.Wool.Warn("local data is in a MinIO backend format the pinned release cannot read",
+			wool.Field("data", s.localDataPath()), wool.Field("format", boundary.Format),
+			wool.Field("release", release.Tag), wool.Field("migration", boundary.Migration))
+		warnings = append(warnings, fmt.Sprintf("the local data is in the %s backend format %s no longer reads: %s",
+			boundary.Format, release.Tag, boundary.Migration))
+	}
+	return warnings, nil
+}
+
+// updateReport is what Update changed and warned about, written to the
+// update report file.
+type updateReport struct {
+	Changes  []string `json:"changes"`
+	Warnings []string `json:"warnings"`
+}
+
+func (s *Service) updateReportFile() string {
+	return filepath.Join(s.Identity.WorkspacePath, ".codefly", "update", s.Identity.Module, s.Identity.Name, "changes.json")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// formerServiceFile is a service created by agent 0.0.13, whose settings
+// were empty.
+const formerServiceFile = `name: storage
+version: 0.0.1
+agent:
+  kind: codefly:service
+  name: minio
+  publisher: codefly.dev
+  version: 0.0.13
+# settings of the service
+spec: {}
+`
+
+func TestMigrateServiceDocument(t *testing.T) {
+	migrated, changes, err := migrateServiceDocument([]byte(formerServiceFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != len(settingsMigrations) {
+		t.Errorf("changes = %v", changes)
+	}
+	if !strings.Contains(string(migrated), "# settings of the service") || strings.Contains(string(migrated), "{") {
+		t.Errorf("comment lost:\n%s", migrated)
+	}
+
+	var service struct {
+		Spec Settings `yaml:"spec"`
+	}
+	if err = yaml.Unmarshal(migrated, &service); err != nil {
+		t.Fatal(err)
+	}
+	if service.Spec.StorageSize != DefaultStorageSize || service.Spec.Region != DefaultRegion || service.Spec.Release != Releases[0].Tag {
+		t.Errorf("defaults not pinned: %+v", service.Spec)
+	}
+
+	_, changes, err = migrateServiceDocument(migrated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("migration is not idempotent: %v", changes)
+	}
+}
+
+func TestEphemeralServicesKeepNoStorageSize(t *testing.T) {
+	migrated, changes, err := migrateServiceDocument([]byte("spec:\n  ephemeral: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != len(settingsMigrations)-1 || strings.Contains(string(migrated), "storage-size") {
+		t.Errorf("changes = %v, migrated:\n%s", changes, migrated)
+	}
+}
+
+func TestMigrateLocalCredentials(t *testing.T) {
+	migrated, changes, err := migrateLocalCredentials("MINIO_ACCESS_KEY=minio\nMINIO_SECRET_KEY=minio\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || strings.Contains(changes[0], "=") {
+		t.Errorf("changes = %v", changes)
+	}
+	conf, err := readConfigurationFile(writeFile(t, migrated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := ParseConfiguration(conf, "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["access-key"] != "minio" || len(values["secret-key"]) < 8 {
+		t.Errorf("migrated = %q", migrated)
+	}
+
+	chosen := "MINIO_ACCESS_KEY=minio\nMINIO_SECRET_KEY=chosen-secret\n"
+	if kept, changes, err := migrateLocalCredentials(chosen); err != nil || kept != chosen || len(changes) != 0 {
+		t.Errorf("chosen key migrated: %q, %v, %v", kept, changes, err)
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "minio.secret.env")
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestBackendFormatBoundaries(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, ".minio.sys"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".minio.sys", "format.json"), []byte(`{"version":"1","format":"fs"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	blocking, err := dataFormatBoundaries(dir, image.Tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocking) != 1 {
+		t.Errorf("blocking = %v", blocking)
+	}
+}
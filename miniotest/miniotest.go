@@ -0,0 +1,237 @@
+// Package miniotest starts the MinIO release the agent deploys, for Go tests
+// of the services that use it. The container runs under the hardened profile
+// of the deployment: read-only root filesystem, non-root user, no
+// capabilities and no privilege escalation.
+//
+//	func TestUploads(t *testing.T) {
+//		conn := miniotest.Run(t, miniotest.Options{Buckets: []string{"uploads"}})
+//		client, err := conn.Client()
+//		...
+//	}
+package miniotest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/codefly-dev/service-minio/pinned"
+)
+
+// Options of the MinIO server.
+type Options struct {
+	// Release is a vetted release tag, the newest one when empty.
+	Release string
+	// Buckets are created once the server is healthy.
+	Buckets []string
+	// Region defaults to us-east-1.
+	Region string
+	// Timeout bounds the wait for health, one minute by default. Pulling the
+	// image counts.
+	Timeout time.Duration
+}
+
+// Connection to a started server, with the values the agent exports.
+type Connection struct {
+	// Endpoint is the host:port of the S3 API.
+	Endpoint  string
+	URL       string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Buckets   []string
+	// Image is the pinned image the container runs.
+	Image     string
+	Container string
+}
+
+// Client connects with the root credentials.
+func (c *Connection) Client() (*minio.Client, error) {
+	return minio.New(c.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(c.AccessKey, c.SecretKey, ""),
+		Region: c.Region,
+	})
+}
+
+// Environment is the AWS SDK environment of the connection, as exported to
+// dependent services.
+func (c *Connection) Environment() map[string]string {
+	return map[string]string{
+		"AWS_ACCESS_KEY_ID":     c.AccessKey,
+		"AWS_SECRET_ACCESS_KEY": c.SecretKey,
+		"AWS_ENDPOINT_URL_S3":   c.URL,
+		"AWS_REGION":            c.Region,
+		"AWS_DEFAULT_REGION":    c.Region,
+	}
+}
+
+// Run starts a server for a test, skips the test when Docker is not
+// available, and removes the container at the end of the test.
+func Run(t testing.TB, options Options) *Connection {
+	t.Helper()
+	if !DockerAvailable() {
+		t.Skip("docker not available")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	conn, cleanup, err := Start(ctx, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+	return conn
+}
+
+// Start runs a server with generated credentials, waits for its health and
+// creates the buckets. The cleanup removes the container and its data.
+func Start(ctx context.Context, options Options) (*Connection, func(), error) {
+	release, err := pinned.Find(options.Release)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn := &Connection{
+		Region:  options.Region,
+		Buckets: options.Buckets,
+		Image:   release.RuntimeImage(runtime.GOARCH).FullName(),
+	}
+	if conn.Region == "" {
+		conn.Region = "us-east-1"
+	}
+	if conn.AccessKey, err = randomKey(10); err != nil {
+		return nil, nil, err
+	}
+	if conn.SecretKey, err = randomKey(20); err != nil {
+		return nil, nil, err
+	}
+	suffix, err := randomKey(4)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.Container = "miniotest-" + suffix
+
+	out, err := exec.CommandContext(ctx, "docker", dockerArguments(conn)...).CombinedOutput()
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot run %s: %w: %s", conn.Image, err, out)
+	}
+	cleanup := func() {
+		_ = exec.Command("docker", "rm", "-f", "-v", conn.Container).Run()
+	}
+
+	if conn.Endpoint, err = hostAddress(ctx, conn.Container); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	conn.URL = "http://" + conn.Endpoint
+
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	if err = waitForHealth(ctx, conn, timeout); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	if err = createBuckets(ctx, conn); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return conn, cleanup, nil
+}
+
+// dockerArguments run the image with the securityContext of the deployment.
+func dockerArguments(conn *Connection) []string {
+	return []string{"run", "-d", "--name", conn.Container,
+		"--read-only",
+		"--user", "1000:1000",
+		"--cap-drop", "ALL",
+		"--security-opt", "no-new-privileges",
+		"--tmpfs", "/tmp:uid=1000,gid=1000",
+		"--tmpfs", "/data:uid=1000,gid=1000",
+		"-e", "MINIO_ACCESS_KEY=" + conn.AccessKey,
+		"-e", "MINIO_SECRET_KEY=" + conn.SecretKey,
+		"-e", "MINIO_SITE_REGION=" + conn.Region,
+		"-p", "127.0.0.1::9000",
+		conn.Image, "server", "/data",
+	}
+}
+
+// hostAddress resolves the host-side "127.0.0.1:port" mapping docker assigned
+// to the S3 API port.
+func hostAddress(ctx context.Context, container string) (string, error) {
+	out, err := exec.CommandContext(ctx, "docker", "port", container, "9000/tcp").Output()
+	if err != nil {
+		return "", fmt.Errorf("docker port: %w", err)
+	}
+	mapping := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	if mapping == "" {
+		return "", fmt.Errorf("no host mapping for container port 9000: %q", out)
+	}
+	return mapping, nil
+}
+
+func waitForHealth(ctx context.Context, conn *Connection, timeout time.Duration) error {
+	healthURL := conn.URL + "/minio/health/live"
+	client := &http.Client{Timeout: 3 * time.Second}
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := client.Get(healthURL)
+		if err == nil {
+			_ = resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = fmt.Errorf("status %s", resp.Status)
+		}
+		if time.Now().After(deadline) {
+			logs, _ := exec.Command("docker", "logs", conn.Container).CombinedOutput()
+			return fmt.Errorf("MinIO never became healthy at %s: last err %v\nlogs:\n%s", healthURL, err, logs)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func createBuckets(ctx context.Context, conn *Connection) error {
+	if len(conn.Buckets) == 0 {
+		return nil
+	}
+	client, err := conn.Client()
+	if err != nil {
+		return err
+	}
+	for _, bucket := range conn.Buckets {
+		if err = client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{Region: conn.Region}); err != nil {
+			return fmt.Errorf("cannot create bucket %s: %w", bucket, err)
+		}
+	}
+	return nil
+}
+
+func randomKey(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// DockerAvailable tells whether a Docker daemon answers.
+func DockerAvailable() bool {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return false
+	}
+	return exec.Command("docker", "info").Run() == nil
+}
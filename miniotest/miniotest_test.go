@@ -0,0 +1,46 @@
+package miniotest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/codefly-dev/service-minio/pinned"
+)
+
+func TestDockerArgumentsAreHardened(t *testing.T) {
+	conn := &Connection{Container: "miniotest-x", AccessKey: "access", SecretKey: "secret", Region: "eu-west-1", Image: "minio/minio"}
+	arguments := strings.Join(dockerArguments(conn), " ")
+	for _, expected := range []string{
+		"--read-only", "--user 1000:1000", "--cap-drop ALL", "--security-opt no-new-privileges",
+		"-e MINIO_ACCESS_KEY=access", "-e MINIO_SECRET_KEY=secret", "-e MINIO_SITE_REGION=eu-west-1",
+		"-p 127.0.0.1::9000", "minio/minio server /data",
+	} {
+		if !strings.Contains(arguments, expected) {
+			t.Errorf("docker arguments miss %q: %s", expected, arguments)
+		}
+	}
+}
+
+func TestStartRejectsUnvettedRelease(t *testing.T) {
+	if _, _, err := Start(context.Background(), Options{Release: "RELEASE.2000-01-01T00-00-00Z"}); err == nil {
+		t.Error("an unknown release must be rejected")
+	}
+}
+
+func TestRunCreatesBuckets(t *testing.T) {
+	conn := Run(t, Options{Buckets: []string{"uploads", "exports"}})
+	if !strings.HasPrefix(conn.Image, pinned.ImageName) {
+		t.Errorf("image = %s", conn.Image)
+	}
+	client, err := conn.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bucket := range conn.Buckets {
+		exists, err := client.BucketExists(context.Background(), bucket)
+		if err != nil || !exists {
+			t.Errorf("bucket %s: %v", bucket, err)
+		}
+	}
+}
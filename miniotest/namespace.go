@@ -0,0 +1,109 @@
+package miniotest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Namespace is the copy of the declared buckets a test suite gets from the
+// local runtime, with credentials limited to them. The runtime hands them
+// out at the namespaces-url it exports, with the namespaces-token.
+type Namespace struct {
+	ID string `json:"id"`
+	// Buckets maps the declared buckets to the ones of the namespace.
+	Buckets      map[string]string `json:"buckets"`
+	Endpoint     string            `json:"endpoint"`
+	Region       string            `json:"region"`
+	AccessKey    string            `json:"access-key"`
+	SecretKey    string            `json:"secret-key"`
+	SessionToken string            `json:"session-token,omitempty"`
+	// Scoped is false with the fake backend: the credentials are the root
+	// ones.
+	Scoped  bool      `json:"scoped"`
+	Expires time.Time `json:"expires"`
+}
+
+// Bucket is the bucket of the namespace for a declared one.
+func (n *Namespace) Bucket(declared string) string {
+	return n.Buckets[declared]
+}
+
+// Client connects with the credentials of the namespace.
+func (n *Namespace) Client() (*minio.Client, error) {
+	return minio.New(n.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(n.AccessKey, n.SecretKey, n.SessionToken),
+		Region: n.Region,
+	})
+}
+
+// UseNamespace gets a namespace for a test and releases it at the end of the
+// test.
+func UseNamespace(t testing.TB, url string, token string) *Namespace {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	namespace, release, err := RequestNamespace(ctx, url, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := release(); err != nil {
+			t.Errorf("cannot release namespace %s: %v", namespace.ID, err)
+		}
+	})
+	return namespace
+}
+
+// RequestNamespace gets a namespace, and the function that releases it. A
+// namespace that is not released is torn down when its lease expires.
+func RequestNamespace(ctx context.Context, url string, token string) (*Namespace, func() error, error) {
+	response, err := namespaceRequest(ctx, http.MethodPost, url, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		return nil, nil, namespaceError(response)
+	}
+	var namespace Namespace
+	if err = json.NewDecoder(response.Body).Decode(&namespace); err != nil {
+		return nil, nil, err
+	}
+	release := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		response, err := namespaceRequest(ctx, http.MethodDelete, strings.TrimSuffix(url, "/")+"/"+namespace.ID, token)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusNoContent && response.StatusCode != http.StatusNotFound {
+			return namespaceError(response)
+		}
+		return nil
+	}
+	return &namespace, release, nil
+}
+
+func namespaceRequest(ctx context.Context, method string, url string, token string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultClient.Do(request)
+}
+
+func namespaceError(response *http.Response) error {
+	message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	return fmt.Errorf("namespaces: %s: %s", response.Status, strings.TrimSpace(string(message)))
+}
@@ -0,0 +1,50 @@
+package miniotest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestUseNamespaceReleasesIt(t *testing.T) {
+	var mu sync.Mutex
+	var released []string
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/namespaces":
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"id":"0a1b2c3d","buckets":{"uploads":"uploads-0a1b2c3d"},"endpoint":"localhost:9000","region":"us-east-1","access-key":"a","secret-key":"s","session-token":"t","scoped":true}`))
+		case r.Method == http.MethodDelete:
+			mu.Lock()
+			released = append(released, r.URL.Path)
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer broker.Close()
+
+	t.Run("suite", func(t *testing.T) {
+		namespace := UseNamespace(t, broker.URL+"/namespaces", "token")
+		if namespace.Bucket("uploads") != "uploads-0a1b2c3d" || namespace.SessionToken != "t" || !namespace.Scoped {
+			t.Errorf("namespace = %+v", namespace)
+		}
+		if _, err := namespace.Client(); err != nil {
+			t.Error(err)
+		}
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if len(released) != 1 || released[0] != "/namespaces/0a1b2c3d" {
+		t.Errorf("released = %v", released)
+	}
+	if _, _, err := RequestNamespace(t.Context(), broker.URL+"/namespaces", "wrong"); err == nil {
+		t.Error("expected an unauthorized error")
+	}
+}
@@ -0,0 +1,78 @@
+package miniotest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Operations reaches the one-shot operations of the local runtime. The
+// runtime writes their URL and token to operations.json in its run directory,
+// .codefly/run/<module>/<service> of the workspace.
+type Operations struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// LoadOperations reads the operations file of a runtime.
+func LoadOperations(file string) (*Operations, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var operations Operations
+	if err = json.Unmarshal(content, &operations); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return &operations, nil
+}
+
+// Snapshot archives the data of the local server under a name.
+func (o *Operations) Snapshot(ctx context.Context, name string) error {
+	return o.post(ctx, "/snapshots/"+url.PathEscape(name), nil)
+}
+
+// Restore replaces the data of the local server with the latest snapshot of
+// a name.
+func (o *Operations) Restore(ctx context.Context, name string) error {
+	return o.post(ctx, "/snapshots/"+url.PathEscape(name)+"/restore", nil)
+}
+
+// Reset brings the buckets of the local server back to their seed.
+func (o *Operations) Reset(ctx context.Context) error {
+	return o.post(ctx, "/reset", nil)
+}
+
+// ExportCompose writes the container of the local server to a file as a
+// docker-compose service, with its credentials in an env file next to it.
+func (o *Operations) ExportCompose(ctx context.Context, file string) error {
+	body, err := json.Marshal(map[string]string{"file": file})
+	if err != nil {
+		return err
+	}
+	return o.post(ctx, "/compose", body)
+}
+
+func (o *Operations) post(ctx context.Context, path string, body []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(o.URL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+o.Token)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("operations: %s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
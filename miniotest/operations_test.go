@@ -0,0 +1,58 @@
+package miniotest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOperations(t *testing.T) {
+	var posted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		posted = append(posted, r.URL.Path)
+		if strings.HasPrefix(r.URL.Path, "/snapshots/missing") {
+			http.Error(w, `no snapshot "missing"`, http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "operations.json")
+	if err := os.WriteFile(file, []byte(`{"url":"`+server.URL+`","token":"token"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	operations, err := LoadOperations(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = operations.Snapshot(t.Context(), "good"); err != nil {
+		t.Fatal(err)
+	}
+	if err = operations.Restore(t.Context(), "good"); err != nil {
+		t.Fatal(err)
+	}
+	if err = operations.Reset(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if err = operations.ExportCompose(t.Context(), "compose.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if err = operations.Restore(t.Context(), "missing"); err == nil || !strings.Contains(err.Error(), "no snapshot") {
+		t.Errorf("restore of a missing snapshot: %v", err)
+	}
+	if strings.Join(posted, " ") != "/snapshots/good /snapshots/good/restore /reset /compose /snapshots/missing/restore" {
+		t.Errorf("posted = %v", posted)
+	}
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+// NamespaceSettings let parallel test suites share the local server: each
+// suite asks the agent for a namespace, its own copy of the buckets with
+// credentials limited to them.
+type NamespaceSettings struct {
+	// Lease of a namespace that is not released, 15m by default and at most
+	// 12h: the lifetimes MinIO accepts for temporary credentials.
+	Lease string `yaml:"lease,omitempty"`
+}
+
+const (
+	defaultNamespaceLease = 15 * time.Minute
+	maxNamespaceLease     = 12 * time.Hour
+	// namespaceSweep is how often expired namespaces are torn down.
+	namespaceSweep = 30 * time.Second
+)
+
+// namespaceLease reads the lease of the settings.
+func namespaceLease(settings *NamespaceSettings) (time.Duration, error) {
+	if settings == nil || settings.Lease == "" {
+		return defaultNamespaceLease, nil
+	}
+	lease, err := time.ParseDuration(settings.Lease)
+	if err != nil {
+		return 0, fmt.Errorf("namespaces lease %q: %w", settings.Lease, err)
+	}
+	if lease < defaultNamespaceLease || lease > maxNamespaceLease {
+		return 0, fmt.Errorf("namespaces lease %s must be between %s and %s", lease, defaultNamespaceLease, maxNamespaceLease)
+	}
+	return lease, nil
+}
+
+// namespace is what a test suite receives: the name of its copy of every
+// bucket, and credentials for them.
+type namespace struct {
+	ID           string            `json:"id"`
+	Buckets      map[string]string `json:"buckets"`
+	Endpoint     string            `json:"endpoint"`
+	Region       string            `json:"region"`
+	AccessKey    string            `json:"access-key"`
+	SecretKey    string            `json:"secret-key"`
+	SessionToken string            `json:"session-token,omitempty"`
+	// Scoped is false when the credentials are the root ones: the fake
+	// backend has no temporary credentials.
+	Scoped  bool      `json:"scoped"`
+	Expires time.Time `json:"expires"`
+}
+
+func (n *namespace) bucketNames() []string {
+	var names []string
+	for _, name := range n.Buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// namespaceBucketName suffixes a bucket with the namespace, shortening the
+// bucket to stay within the 63 characters of a bucket name.
+func namespaceBucketName(bucket string, id string) string {
+	if limit := 63 - len(id) - 1; len(bucket) > limit {
+		bucket = strings.TrimRight(bucket[:limit], ".-")
+	}
+	return bucket + "-" + id
+}
+
+// namespacePolicy limits temporary credentials to the buckets of a namespace.
+func namespacePolicy(buckets []string) (string, error) {
+	statement := &policyStatement{Effect: "Allow", Action: []string{"s3:*"}}
+	for _, bucket := range buckets {
+		statement.Resource = append(statement.Resource, "arn:aws:s3:::"+bucket, "arn:aws:s3:::"+bucket+"/*")
+	}
+	content, err := json.Marshal(&policyDocument{Version: "2012-10-17", Statement: []*policyStatement{statement}})
+	return string(content), err
+}
+
+// namespaceServer creates and removes namespaces on a server, with its root
+// credentials.
+type namespaceServer struct {
+	client    *minio.Client
+	endpoint  string
+	region    string
+	accessKey string
+	secretKey string
+	// scoped servers issue temporary credentials with MinIO STS.
+	scoped bool
+}
+
+// create makes the buckets of a namespace, configured as the declared ones.
+func (n *namespaceServer) create(ctx context.Context, buckets []*BucketSettings) error {
+	for _, bucket := range buckets {
+		if err := n.client.MakeBucket(ctx, bucket.Name, minio.MakeBucketOptions{Region: n.region}); err != nil {
+			return fmt.Errorf("cannot create bucket %s: %w", bucket.Name, err)
+		}
+		if err := configureNewBucket(ctx, n.client, bucket); err != nil {
+			return fmt.Errorf("cannot configure bucket %s: %w", bucket.Name, err)
+		}
+	}
+	return nil
+}
+
+// credentials for the buckets of a namespace, expiring with its lease.
+func (n *namespaceServer) credentials(ctx context.Context, buckets []string, lease time.Duration) (credentials.Value, error) {
+	if !n.scoped {
+		return credentials.Value{AccessKeyID: n.accessKey, SecretAccessKey: n.secretKey}, nil
+	}
+	document, err := namespacePolicy(buckets)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	sts, err := credentials.NewSTSAssumeRole("http://"+n.endpoint, credentials.STSAssumeRoleOptions{
+		AccessKey:       n.accessKey,
+		SecretKey:       n.secretKey,
+		Policy:          document,
+		Location:        n.region,
+		DurationSeconds: int(lease.Seconds()),
+	})
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	return sts.GetWithContext(&credentials.CredContext{Client: &http.Client{Timeout: 10 * time.Second}})
+}
+
+// remove deletes the buckets of a namespace and their objects. Buckets
+// already gone are skipped.
+func (n *namespaceServer) remove(ctx context.Context, buckets []string) error {
+	for _, bucket := range buckets {
+		exists, err := n.client.BucketExists(ctx, bucket)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if err = emptyBucket(ctx, n.client, bucket); err != nil {
+			return err
+		}
+		if err = n.client.RemoveBucket(ctx, bucket); err != nil {
+			return fmt.Errorf("cannot remove bucket %s: %w", bucket, err)
+		}
+	}
+	return nil
+}
+
+// namespaceBroker hands out namespaces over HTTP to the test suites of the
+// workspace, and tears them down when released, when their lease expires or
+// at the end of Runtime.Test:
+//
+//	POST   /namespaces       creates a namespace, answered as JSON
+//	DELETE /namespaces/<id>  releases it
+//
+// Requests authenticate with a generated bearer token. The handlers never
+// read the runtime: the RPCs hand the broker the server and the buckets with
+// the lifecycle held.
+type namespaceBroker struct {
+	logger logger
+	token  string
+	lease  time.Duration
+	now    func() time.Time
+
+	mu         sync.Mutex
+	server     *namespaceServer
+	buckets    []*BucketSettings
+	namespaces map[string]*namespace
+	http       *http.Server
+	port       int
+	// bridge is the address containers reach the broker at, if any.
+	bridge    string
+	stopSweep context.CancelFunc
+}
+
+func newNamespaceBroker(l logger, lease time.Duration) (*namespaceBroker, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	return &namespaceBroker{
+		logger:     l,
+		token:      hex.EncodeToString(token),
+		lease:      lease,
+		now:        time.Now,
+		namespaces: make(map[string]*namespace),
+	}, nil
+}
+
+// serve sets the server the namespaces are made on and the buckets they copy.
+func (b *namespaceBroker) serve(server *namespaceServer, buckets []*BucketSettings) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.server, b.buckets = server, buckets
+}
+
+// target returns the server and the buckets set by serve.
+func (b *namespaceBroker) target() (*namespaceServer, []*BucketSettings, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.server == nil {
+		return nil, nil, fmt.Errorf("the local server is not initialized")
+	}
+	return b.server, b.buckets, nil
+}
+
+// Start listens on a free port of the loopback interface, and on the same
+// port of the docker0 bridge, when there is one, for the test suites running
+// in containers. It sweeps expired namespaces.
+func (b *namespaceBroker) Start() error {
+	listeners, err := listenLocal(dockerBridgeAddress())
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /namespaces", b.serveCreate)
+	mux.HandleFunc("DELETE /namespaces/{id}", b.serveRelease)
+	b.port = listeners[0].Addr().(*net.TCPAddr).Port
+	if len(listeners) > 1 {
+		b.bridge = listeners[1].Addr().(*net.TCPAddr).IP.String()
+	}
+	b.http = &http.Server{Handler: requireBearer(b.token, mux), ReadHeaderTimeout: 10 * time.Second}
+	for _, listener := range listeners {
+		go func() {
+			_ = b.http.Serve(listener)
+		}()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b.stopSweep = cancel
+	go b.sweep(ctx, namespaceSweep)
+	return nil
+}
+
+// listenLocal listens on a free port of the loopback interface and, with a
+// bridge address, on the same port of the bridge. A port taken on the bridge
+// is given up for another one.
+func listenLocal(bridge string) ([]net.Listener, error) {
+	for attempt := 0; ; attempt++ {
+		loopback, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil || bridge == "" {
+			return []net.Listener{loopback}, err
+		}
+		port := strconv.Itoa(loopback.Addr().(*net.TCPAddr).Port)
+		onBridge, err := net.Listen("tcp", net.JoinHostPort(bridge, port))
+		if err == nil {
+			return []net.Listener{loopback, onBridge}, nil
+		}
+		_ = loopback.Close()
+		if attempt == 2 {
+			return nil, err
+		}
+	}
+}
+
+// URL of the broker for a host name of the local network instance. Other
+// hosts than the loopback one are containers: they reach the broker on the
+// bridge.
+func (b *namespaceBroker) URL(hostname string) string {
+	if b.bridge != "" && hostname != "localhost" && hostname != "127.0.0.1" {
+		hostname = b.bridge
+	}
+	return fmt.Sprintf("http://%s:%d/namespaces", hostname, b.port)
+}
+
+func (b *namespaceBroker) serveCreate(w http.ResponseWriter, r *http.Request) {
+	created, err := b.create(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(created)
+}
+
+func (b *namespaceBroker) serveRelease(w http.ResponseWriter, r *http.Request) {
+	found, err := b.release(r.Context(), r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// create makes a namespace of every declared bucket. A namespace half
+// created is removed.
+func (b *namespaceBroker) create(ctx context.Context) (*namespace, error) {
+	server, declared, err := b.target()
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 4)
+	if _, err = rand.Read(id); err != nil {
+		return nil, err
+	}
+	created := &namespace{
+		ID:       hex.EncodeToString(id),
+		Buckets:  make(map[string]string),
+		Endpoint: server.endpoint,
+		Region:   server.region,
+		Scoped:   server.scoped,
+		Expires:  b.now().Add(b.lease).UTC(),
+	}
+	var buckets []*BucketSettings
+	for _, settings := range declared {
+		bucket := *settings
+		bucket.Name = namespaceBucketName(settings.Name, created.ID)
+		created.Buckets[settings.Name] = bucket.Name
+		buckets = append(buckets, &bucket)
+	}
+	err = server.create(ctx, buckets)
+	if err == nil {
+		var value credentials.Value
+		value, err = server.credentials(ctx, created.bucketNames(), b.lease)
+		created.AccessKey, created.SecretKey, created.SessionToken = value.AccessKeyID, value.SecretAccessKey, value.SessionToken
+	}
+	if err != nil {
+		if removeErr := server.remove(ctx, created.bucketNames()); removeErr != nil {
+			b.logger.Warn("cannot remove the namespace", wool.Field("namespace", created.ID), wool.ErrField(removeErr))
+		}
+		return nil, err
+	}
+	b.mu.Lock()
+	b.namespaces[created.ID] = created
+	b.mu.Unlock()
+	b.logger.Info("created a namespace", wool.Field("namespace", created.ID), wool.Field("expires", created.Expires))
+	return created, nil
+}
+
+// release tears a namespace down, and tells whether it existed.
+func (b *namespaceBroker) release(ctx context.Context, id string) (bool, error) {
+	b.mu.Lock()
+	found, ok := b.namespaces[id]
+	delete(b.namespaces, id)
+	b.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	server, _, err := b.target()
+	if err == nil {
+		err = server.remove(ctx, found.bucketNames())
+	}
+	if err != nil {
+		return true, fmt.Errorf("cannot remove namespace %s: %w", id, err)
+	}
+	b.logger.Info("released a namespace", wool.Field("namespace", id))
+	return true, nil
+}
+
+// releaseAll tears down the namespaces left, or the expired ones only.
+func (b *namespaceBroker) releaseAll(ctx context.Context, expiredOnly bool) error {
+	now := b.now()
+	var ids []string
+	b.mu.Lock()
+	for id, n := range b.namespaces {
+		if !expiredOnly || !now.Before(n.Expires) {
+			ids = append(ids, id)
+		}
+	}
+	b.mu.Unlock()
+	sort.Strings(ids)
+	var errs []string
+	for _, id := range ids {
+		if _, err := b.release(ctx, id); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (b *namespaceBroker) sweep(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		if err := b.releaseAll(ctx, true); err != nil && ctx.Err() == nil {
+			b.logger.Warn("cannot tear down expired namespaces", wool.ErrField(err))
+		}
+	}
+}
+
+// Count of the namespaces handed out.
+func (b *namespaceBroker) Count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.namespaces)
+}
+
+// Close stops handing out namespaces. The namespaces left are not torn down.
+func (b *namespaceBroker) Close() error {
+	if b.stopSweep != nil {
+		b.stopSweep()
+	}
+	if b.http == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return b.http.Shutdown(ctx)
+}
+
+// startNamespaces starts the broker of the namespaces setting.
+func (s *Runtime) startNamespaces() error {
+	if s.Settings.Namespaces == nil {
+		return nil
+	}
+	lease, err := namespaceLease(s.Settings.Namespaces)
+	if err != nil {
+		return err
+	}
+	broker, err := newNamespaceBroker(s.Wool, lease)
+	if err != nil {
+		return err
+	}
+	if err = broker.Start(); err != nil {
+		return s.Wool.Wrapf(err, "cannot start the namespaces broker")
+	}
+	// Docker Desktop forwards host.docker.internal to the loopback interface.
+	if broker.bridge == "" && runtime.GOOS == "linux" {
+		s.Wool.Warn("without the docker0 bridge, test suites in containers cannot reach the namespaces broker")
+	}
+	s.namespaces = broker
+	return nil
+}
+
+// serveNamespaces hands the broker the local server once Init runs it. It
+// is called with the lifecycle held: the broker keeps what it reads.
+func (s *Runtime) serveNamespaces() error {
+	if s.namespaces == nil {
+		return nil
+	}
+	server, err := s.namespaceServer()
+	if err != nil {
+		return s.Wool.Wrapf(err, "cannot reach the server of the namespaces")
+	}
+	s.namespaces.serve(server, s.bucketSettings())
+	return nil
+}
+
+// namespaceServer is the local server: the fake backend has no STS.
+func (s *Runtime) namespaceServer() (*namespaceServer, error) {
+	client, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	return &namespaceServer{
+		client:    client,
+		endpoint:  s.hostReady,
+		region:    s.region(),
+		accessKey: s.accessKey,
+		secretKey: s.secretKey,
+		scoped:    s.backend != FakeBackend,
+	}, nil
+}
+
+// releaseNamespaces tears down the namespaces left by the tests.
+func (s *Runtime) releaseNamespaces(ctx context.Context) {
+	if s.namespaces == nil {
+		return
+	}
+	if err := s.namespaces.releaseAll(ctx, false); err != nil {
+		s.Wool.Warn("cannot tear down the namespaces", wool.ErrField(err))
+	}
+}
+
+// stopNamespaces tears down the namespaces and stops the broker.
+func (s *Runtime) stopNamespaces(ctx context.Context) {
+	if s.namespaces == nil {
+		return
+	}
+	s.releaseNamespaces(ctx)
+	if err := s.namespaces.Close(); err != nil {
+		s.Wool.Warn("cannot stop the namespaces broker", wool.ErrField(err))
+	}
+	s.namespaces = nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestNamespaceLease(t *testing.T) {
+	for _, test := range []struct {
+		settings *NamespaceSettings
+		expected time.Duration
+	}{
+		{nil, defaultNamespaceLease},
+		{&NamespaceSettings{}, defaultNamespaceLease},
+		{&NamespaceSettings{Lease: "1h"}, time.Hour},
+	} {
+		if lease, err := namespaceLease(test.settings); err != nil || lease != test.expected {
+			t.Errorf("%+v: %s, %v", test.settings, lease, err)
+		}
+	}
+	for _, lease := range []string{"soon", "5m", "24h"} {
+		if _, err := namespaceLease(&NamespaceSettings{Lease: lease}); err == nil {
+			t.Errorf("%s: expected an invalid lease error", lease)
+		}
+	}
+}
+
+func TestNamespaceBucketName(t *testing.T) {
+	if got := namespaceBucketName("uploads", "0a1b2c3d"); got != "uploads-0a1b2c3d" {
+		t.Errorf("name = %s", got)
+	}
+	long := namespaceBucketName(strings.Repeat("a", 53)+"."+strings.Repeat("b", 9), "0a1b2c3d")
+	if long != strings.Repeat("a", 53)+"-0a1b2c3d" {
+		t.Errorf("long name = %s", long)
+	}
+}
+
+func TestNamespacePolicy(t *testing.T) {
+	document, err := namespacePolicy([]string{"uploads-0a1b2c3d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":["s3:*"],"Resource":["arn:aws:s3:::uploads-0a1b2c3d","arn:aws:s3:::uploads-0a1b2c3d/*"]}]}`
+	if document != expected {
+		t.Errorf("policy = %s", document)
+	}
+}
+
+// testBroker hands out namespaces of the fake backend.
+func testBroker(t *testing.T, buckets ...*BucketSettings) (*namespaceBroker, *minio.Client) {
+	t.Helper()
+	client := fakeClient(t)
+	address := client.EndpointURL().Host
+	server := &namespaceServer{client: client, endpoint: address, region: DefaultRegion, accessKey: "codefly", secretKey: "codefly-secret"}
+	broker, err := newNamespaceBroker(&syncRecorder{}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker.serve(server, buckets)
+	if err = broker.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = broker.Close() })
+	return broker, client
+}
+
+func brokerRequest(t *testing.T, broker *namespaceBroker, method string, url string, token string) *http.Response {
+	t.Helper()
+	request, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = response.Body.Close() })
+	return response
+}
+
+func TestNamespaceBroker(t *testing.T) {
+	ctx := context.Background()
+	broker, client := testBroker(t, &BucketSettings{Name: "uploads", Versioning: true}, &BucketSettings{Name: "reports"})
+	url := broker.URL("localhost")
+
+	if response := brokerRequest(t, broker, http.MethodPost, url, "wrong"); response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong token: %s", response.Status)
+	}
+
+	var namespaces []*namespace
+	for i := 0; i < 2; i++ {
+		response := brokerRequest(t, broker, http.MethodPost, url, broker.token)
+		if response.StatusCode != http.StatusCreated {
+			t.Fatalf("create: %s", response.Status)
+		}
+		var created namespace
+		if err := json.NewDecoder(response.Body).Decode(&created); err != nil {
+			t.Fatal(err)
+		}
+		namespaces = append(namespaces, &created)
+	}
+	first, second := namespaces[0], namespaces[1]
+	if first.ID == second.ID || first.Buckets["uploads"] == second.Buckets["uploads"] {
+		t.Fatalf("namespaces are not isolated: %+v %+v", first, second)
+	}
+	if first.Buckets["uploads"] != "uploads-"+first.ID || first.AccessKey != "codefly" || first.Scoped {
+		t.Errorf("namespace of the fake = %+v", first)
+	}
+	versioning, err := client.GetBucketVersioning(ctx, first.Buckets["uploads"])
+	if err != nil || !versioning.Enabled() {
+		t.Errorf("the namespace bucket is not configured as the declared one: %+v, %v", versioning, err)
+	}
+	if _, err = client.PutObject(ctx, first.Buckets["reports"], "a/b.txt", strings.NewReader("b"), 1, minio.PutObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if broker.Count() != 2 {
+		t.Errorf("count = %d", broker.Count())
+	}
+
+	if response := brokerRequest(t, broker, http.MethodDelete, url+"/"+first.ID, broker.token); response.StatusCode != http.StatusNoContent {
+		t.Fatalf("release: %s", response.Status)
+	}
+	for _, bucket := range first.bucketNames() {
+		if exists, err := client.BucketExists(ctx, bucket); err != nil || exists {
+			t.Errorf("%s is left: %v", bucket, err)
+		}
+	}
+	if response := brokerRequest(t, broker, http.MethodDelete, url+"/"+first.ID, broker.token); response.StatusCode != http.StatusNotFound {
+		t.Errorf("second release: %s", response.Status)
+	}
+	if exists, err := client.BucketExists(ctx, second.Buckets["uploads"]); err != nil || !exists {
+		t.Errorf("releasing a namespace removed another one: %v", err)
+	}
+}
+
+func TestNamespacesExpire(t *testing.T) {
+	ctx := context.Background()
+	broker, client := testBroker(t, &BucketSettings{Name: "uploads"})
+	kept, err := broker.create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	expired, err := broker.create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker.now = func() time.Time { return time.Now().Add(90 * time.Minute) }
+	if err = broker.releaseAll(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := client.BucketExists(ctx, kept.Buckets["uploads"]); exists {
+		t.Error("an expired namespace is left")
+	}
+	if exists, _ := client.BucketExists(ctx, expired.Buckets["uploads"]); !exists {
+		t.Error("a namespace was torn down before its lease expired")
+	}
+
+	// The end of Runtime.Test tears down the others.
+	if err = broker.releaseAll(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	if broker.Count() != 0 {
+		t.Errorf("count = %d", broker.Count())
+	}
+}
+
+func TestNamespaceBrokerWaitsForTheServer(t *testing.T) {
+	broker, err := newNamespaceBroker(&syncRecorder{}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = broker.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = broker.Close() })
+	response := brokerRequest(t, broker, http.MethodPost, broker.URL("localhost"), broker.token)
+	if response.StatusCode != http.StatusInternalServerError {
+		t.Errorf("create before Init: %s", response.Status)
+	}
+
+	broker.bridge = "172.17.0.1"
+	if url := broker.URL("host.docker.internal"); !strings.HasPrefix(url, "http://172.17.0.1:") {
+		t.Errorf("url for containers = %s", url)
+	}
+	if url := broker.URL("localhost"); !strings.HasPrefix(url, "http://localhost:") {
+		t.Errorf("url for the host = %s", url)
+	}
+}
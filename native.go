@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+// nativeServer runs the container definition with a local minio binary: the
+// host ports are bound directly and the data mount is a host directory.
+type nativeServer struct {
+	binary    string
+	arguments []string
+	env       []string
+	// pidFile lets Destroy stop a server started by a former agent process.
+	// It records the binary next to the pid, so that a stale pid reused by
+	// another process is never signalled.
+	pidFile string
+	// scratch is the data directory of an ephemeral server, removed on
+	// shutdown.
+	scratch string
+	// tail also receives the output, if set.
+	tail io.Writer
+}
+
+// nativeArguments translates the container command: the container data path
+// becomes the host directory and container ports become host ports.
+func nativeArguments(definition *containerDefinition, dataDir string) ([]string, error) {
+	hostPorts := make(map[uint16]uint16)
+	for _, port := range definition.Ports {
+		hostPorts[port.Container] = port.Host
+	}
+	host, ok := hostPorts[minioPort]
+	if !ok {
+		return nil, fmt.Errorf("no host port for the S3 API")
+	}
+	var arguments []string
+	for _, argument := range definition.Command {
+		switch {
+		case argument == "/data":
+			argument = dataDir
+		case strings.HasPrefix(argument, ":"):
+			container, err := strconv.ParseUint(argument[1:], 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("cannot translate address %s: %w", argument, err)
+			}
+			port, ok := hostPorts[uint16(container)]
+			if !ok {
+				return nil, fmt.Errorf("no host port for container port %d", container)
+			}
+			argument = fmt.Sprintf(":%d", port)
+		}
+		arguments = append(arguments, argument)
+	}
+	return append(arguments, "--address", fmt.Sprintf(":%d", host)), nil
+}
+
+// nativeServer prepares the native server of a definition.
+func (s *Runtime) nativeServer(definition *containerDefinition) (*nativeServer, error) {
+	binary := s.Settings.MinIOBinary
+	if binary == "" {
+		binary = "minio"
+	}
+	binary, err := exec.LookPath(binary)
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "native backend needs a minio server binary: install it or set minio-binary")
+	}
+	server := &nativeServer{binary: binary, pidFile: s.nativePidFile()}
+	dataDir := ""
+	for _, mount := range definition.Mounts {
+		if mount.Target == "/data" {
+			dataDir = mount.Source
+		}
+	}
+	if dataDir == "" {
+		server.scratch, err = os.MkdirTemp("", "codefly-minio-")
+		if err != nil {
+			return nil, s.Wool.Wrapf(err, "cannot create ephemeral data directory")
+		}
+		dataDir = server.scratch
+	}
+	server.arguments, err = nativeArguments(definition, dataDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, env := range definition.Environment {
+		server.env = append(server.env, fmt.Sprintf("%s=%v", env.Key, env.Value))
+	}
+	return server, nil
+}
+
+// nativePidFile is kept in the workspace, next to the local data.
+func (s *Service) nativePidFile() string {
+	return filepath.Join(s.runDirectory(), "minio.pid")
+}
+
+// warnVersion warns when the binary is not the pinned release: the native
+// backend runs whatever is installed.
+func (n *nativeServer) warnVersion(ctx context.Context, w *wool.Wool, release string) {
+	out, err := exec.CommandContext(ctx, n.binary, "--version").Output()
+	if err != nil {
+		w.Warn("cannot read the version of the minio binary", wool.Field("binary", n.binary), wool.ErrField(err))
+		return
+	}
+	if !strings.Contains(string(out), release) {
+		w.Warn("the minio binary is not the pinned release", wool.Field("binary", n.binary), wool.Field("pinned", release),
+			wool.Field("version", strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])))
+	}
+}
+
+// Start replaces a server left running by a former agent process, like the
+// Docker backend replaces its container.
+func (n *nativeServer) Start(ctx context.Context, w *wool.Wool) error {
+	if err := stopNativeServer(n.pidFile); err != nil {
+		return err
+	}
+	cmd := exec.Command(n.binary, n.arguments...)
+	cmd.Env = append(os.Environ(), n.env...)
+	var logs io.Writer = newMinIOLogWriter(w)
+	if n.tail != nil {
+		logs = io.MultiWriter(logs, n.tail)
+	}
+	cmd.Stdout = logs
+	cmd.Stderr = logs
+	if err := cmd.Start(); err != nil {
+		return w.Wrapf(err, "cannot start %s", n.binary)
+	}
+	if err := writePidFile(n.pidFile, cmd.Process.Pid, n.binary); err != nil {
+		return err
+	}
+	// Reap the process when it exits, so that it does not linger as a zombie.
+	go func() {
+		_ = cmd.Wait()
+	}()
+	w.Debug("started native minio", wool.Field("pid", cmd.Process.Pid), wool.Field("arguments", n.arguments))
+	return nil
+}
+
+// Pause freezes the server, Resume thaws it.
+func (n *nativeServer) Pause() error {
+	process, err := serverProcess(n.pidFile)
+	if err != nil {
+		return err
+	}
+	return pauseProcess(process)
+}
+
+func (n *nativeServer) Resume() error {
+	process, err := serverProcess(n.pidFile)
+	if err != nil {
+		return err
+	}
+	return resumeProcess(process)
+}
+
+// Stop stops the server and keeps its data, even ephemeral: Start runs it
+// again.
+func (n *nativeServer) Stop() error {
+	return stopNativeServer(n.pidFile)
+}
+
+// Shutdown stops the server and removes the data of an ephemeral one.
+func (n *nativeServer) Shutdown() error {
+	if err := stopNativeServer(n.pidFile); err != nil {
+		return err
+	}
+	if n.scratch != "" {
+		return os.RemoveAll(n.scratch)
+	}
+	return nil
+}
+
+// writePidFile records the pid of a server and the binary it runs.
+func writePidFile(pidFile string, pid int, binary string) error {
+	if err := os.MkdirAll(filepath.Dir(pidFile), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n%s\n", pid, binary)), 0o644)
+}
+
+// errStalePidFile is returned for a pid file whose process is gone or runs
+// another binary.
+var errStalePidFile = errors.New("stale pid file")
+
+// serverProcess is the running server of a pid file.
+func serverProcess(pidFile string) (*os.Process, error) {
+	content, err := os.ReadFile(pidFile)
+	if err != nil {
+		return nil, err
+	}
+	pidLine, binary, _ := strings.Cut(strings.TrimSpace(string(content)), "\n")
+	pid, err := strconv.Atoi(strings.TrimSpace(pidLine))
+	if err != nil || binary == "" || !runsBinary(pid, strings.TrimSpace(binary)) {
+		return nil, fmt.Errorf("%s: %w", pidFile, errStalePidFile)
+	}
+	return os.FindProcess(pid)
+}
+
+// stopNativeServer stops the server of a pid file, if any, and tolerates a
+// server that is already gone.
+func stopNativeServer(pidFile string) error {
+	process, err := serverProcess(pidFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil && !errors.Is(err, errStalePidFile) {
+		return err
+	}
+	if process != nil {
+		terminateProcess(process, 10*time.Second)
+	}
+	return os.Remove(pidFile)
+}
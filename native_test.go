@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNativeArguments(t *testing.T) {
+	definition := &containerDefinition{
+		Command: []string{"server", "/data", "--console-address", ":9001"},
+		Ports:   []*portMapping{{Host: 31000, Container: minioPort}, {Host: 31001, Container: consolePort}},
+	}
+	arguments, err := nativeArguments(definition, "/workspace/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(arguments, " "); got != "server /workspace/data --console-address :31001 --address :31000" {
+		t.Errorf("arguments = %s", got)
+	}
+
+	if _, err = nativeArguments(&containerDefinition{Command: []string{"server", "/data"}}, "/data"); err == nil {
+		t.Error("expected a missing port error")
+	}
+}
+
+func TestStopNativeServer(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "minio.pid")
+	if err := stopNativeServer(pidFile); err != nil {
+		t.Fatalf("missing pid file: %v", err)
+	}
+
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("no sleep binary")
+	}
+	cmd := exec.Command(sleep, "30")
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	// A pid file of another binary is stale: the process is left alone.
+	if err = writePidFile(pidFile, cmd.Process.Pid, "/nonexistent/minio"); err != nil {
+		t.Fatal(err)
+	}
+	if err = stopNativeServer(pidFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Errorf("stale pid file kept: %v", err)
+	}
+	select {
+	case err = <-exited:
+		t.Fatalf("process of a stale pid file was signalled: %v", err)
+	default:
+	}
+
+	if err = writePidFile(pidFile, cmd.Process.Pid, sleep); err != nil {
+		t.Fatal(err)
+	}
+	if err = stopNativeServer(pidFile); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-exited; err == nil {
+		t.Error("process was not signalled")
+	}
+	if _, err = os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Errorf("pid file kept: %v", err)
+	}
+	// The server is gone: stopping again is a no-op.
+	if err = stopNativeServer(pidFile); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPauseAndStopNativeServer(t *testing.T) {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("no sleep binary")
+	}
+	if _, err = os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("no procfs")
+	}
+	cmd := exec.Command(sleep, "30")
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	server := &nativeServer{pidFile: filepath.Join(t.TempDir(), "minio.pid")}
+	if err = writePidFile(server.pidFile, cmd.Process.Pid, sleep); err != nil {
+		t.Fatal(err)
+	}
+	state := func() string {
+		stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(cmd.Process.Pid), "stat"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// pid (comm) state ...
+		return strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))[0]
+	}
+	waitFor := func(expected string) {
+		deadline := time.Now().Add(2 * time.Second)
+		for state() != expected && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := state(); got != expected {
+			t.Fatalf("process state = %s, want %s", got, expected)
+		}
+	}
+
+	if err = server.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("T")
+	if err = server.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("S")
+
+	// A paused server still stops on SIGTERM, without waiting for the kill.
+	if err = server.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("T")
+	started := time.Now()
+	if err = server.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("stopping a paused server took %s", elapsed)
+	}
+	<-exited
+}
@@ -0,0 +1,59 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runsBinary tells whether a process runs a binary, from procfs or, where
+// there is none, from ps.
+func runsBinary(pid int, binary string) bool {
+	expected, err := filepath.EvalSymlinks(binary)
+	if err != nil {
+		return false
+	}
+	exe, err := os.Readlink(filepath.Join("/proc", strconv.Itoa(pid), "exe"))
+	if err == nil {
+		return strings.TrimSuffix(exe, " (deleted)") == expected
+	}
+	if _, statErr := os.Stat("/proc/self"); statErr == nil {
+		// The process is gone.
+		return false
+	}
+	out, err := exec.Command("ps", "-o", "comm=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return false
+	}
+	command := strings.TrimSpace(string(out))
+	return command == binary || command == expected || filepath.Base(command) == filepath.Base(binary)
+}
+
+func pauseProcess(process *os.Process) error {
+	return process.Signal(syscall.SIGSTOP)
+}
+
+func resumeProcess(process *os.Process) error {
+	return process.Signal(syscall.SIGCONT)
+}
+
+// terminateProcess asks the process to stop and kills it after a grace
+// period.
+func terminateProcess(process *os.Process, grace time.Duration) {
+	_ = process.Signal(syscall.SIGTERM)
+	// A paused server only handles the signal once resumed.
+	_ = process.Signal(syscall.SIGCONT)
+	deadline := time.Now().Add(grace)
+	for time.Now().Before(deadline) && process.Signal(syscall.Signal(0)) == nil {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if process.Signal(syscall.Signal(0)) == nil {
+		_ = process.Kill()
+	}
+}
@@ -0,0 +1,41 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runsBinary tells whether a process runs a binary, from its image name.
+func runsBinary(pid int, binary string) bool {
+	out, err := exec.Command("tasklist", "/FI", fmt.Sprintf("PID eq %d", pid), "/FO", "CSV", "/NH").Output()
+	if err != nil {
+		return false
+	}
+	fields := strings.Split(strings.TrimSpace(string(out)), ",")
+	if len(fields) < 2 || strings.Trim(fields[1], `"`) != strconv.Itoa(pid) {
+		return false
+	}
+	return strings.EqualFold(strings.Trim(fields[0], `"`), filepath.Base(binary))
+}
+
+// Windows has no signal to suspend a process.
+func pauseProcess(*os.Process) error {
+	return fmt.Errorf("pausing a native server is not supported on windows: use stop-mode stop")
+}
+
+func resumeProcess(*os.Process) error {
+	return fmt.Errorf("resuming a native server is not supported on windows")
+}
+
+// terminateProcess kills the process: Windows has no graceful signal.
+func terminateProcess(process *os.Process, _ time.Duration) {
+	_ = process.Kill()
+	_, _ = process.Wait()
+}
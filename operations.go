@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+// operationsServer runs the one-shot operations of the local runtime when
+// they are asked for, never as a side effect of an RPC:
+//
+//	GET  /snapshots                 lists the snapshots
+//	POST /snapshots/{name}          snapshots the data directory
+//	POST /snapshots/{name}/restore  restores the latest snapshot of a name
+//	POST /reset                     resets the buckets to their seed
+//	POST /compose                   exports the container to {"file": ...}
+//
+// It listens on the loopback interface, and requests authenticate with a
+// generated bearer token. Both are written to the operations file of the run
+// directory, readable by the owner only.
+type operationsServer struct {
+	token string
+	file  string
+	url   string
+	http  *http.Server
+}
+
+// operationsAccess is the content of the operations file.
+type operationsAccess struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+func newOperationsServer(file string, routes http.Handler) (*operationsServer, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	o := &operationsServer{
+		token: hex.EncodeToString(token),
+		file:  file,
+		url:   "http://" + listener.Addr().String(),
+	}
+	content, err := json.MarshalIndent(&operationsAccess{URL: o.url, Token: o.token}, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(file), 0o755)
+	}
+	if err == nil {
+		err = os.WriteFile(file, content, 0o600)
+	}
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	o.http = &http.Server{Handler: requireBearer(o.token, routes), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		_ = o.http.Serve(listener)
+	}()
+	return o, nil
+}
+
+// Close stops the server and removes the operations file.
+func (o *operationsServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := o.http.Shutdown(ctx)
+	if removeErr := os.Remove(o.file); removeErr != nil && !os.IsNotExist(removeErr) && err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// requireBearer answers 401 to requests without the token.
+func requireBearer(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Service) operationsFile() string {
+	return filepath.Join(s.runDirectory(), "operations.json")
+}
+
+// startOperations serves the operations once the server is initialized. The
+// server outlives Stop and a new Init, and is closed by Destroy.
+func (s *Runtime) startOperations() error {
+	if s.operations != nil {
+		return nil
+	}
+	server, err := newOperationsServer(s.operationsFile(), s.operationRoutes())
+	if err != nil {
+		return s.Wool.Wrapf(err, "cannot start the operations server")
+	}
+	s.operations = server
+	s.Wool.Debug("serving operations", wool.Field("url", server.url), wool.Field("file", server.file))
+	return nil
+}
+
+func (s *Runtime) stopOperations() {
+	if s.operations == nil {
+		return
+	}
+	if err := s.operations.Close(); err != nil {
+		s.Wool.Warn("cannot close the operations server", wool.ErrField(err))
+	}
+	s.operations = nil
+}
+
+func (s *Runtime) operationRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /snapshots", s.serveSnapshots)
+	mux.HandleFunc("POST /snapshots/{name}", s.serveSnapshot)
+	mux.HandleFunc("POST /snapshots/{name}/restore", s.serveRestore)
+	mux.HandleFunc("POST /reset", s.serveReset)
+	mux.HandleFunc("POST /compose", s.serveCompose)
+	return mux
+}
+
+// initializedStates are the states of a service with a server.
+var initializedStates = []lifecycleState{stateInitialized, stateRunning, stateStopped}
+
+// operation runs like an RPC: after the running one, and only in the allowed
+// states. Its result is answered as JSON.
+func (s *Runtime) operation(w http.ResponseWriter, r *http.Request, name string, allowed []lifecycleState, run func(ctx context.Context) (any, error)) {
+	if err := s.lifecycle.enter(name, allowed...); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	result, err := func() (any, error) {
+		defer s.leave()
+		return run(s.Wool.Inject(r.Context()))
+	}()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// pauseWatching stops the watcher while an operation stops the server, and
+// returns the function that watches it again if the service is running.
+func (s *Runtime) pauseWatching() func() {
+	s.stopWatcher()
+	return func() {
+		if s.lifecycle.State() == stateRunning && s.serverState == serverRunning {
+			s.startWatcher()
+		}
+	}
+}
+
+func (s *Runtime) serveSnapshots(w http.ResponseWriter, r *http.Request) {
+	s.operation(w, r, "snapshots", initializedStates, func(context.Context) (any, error) {
+		snapshots, err := listSnapshots(s.snapshotDirectory())
+		if snapshots == nil {
+			snapshots = []snapshot{}
+		}
+		return snapshots, err
+	})
+}
+
+func (s *Runtime) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := validateSnapshotName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.operation(w, r, "snapshot", initializedStates, func(ctx context.Context) (any, error) {
+		defer s.pauseWatching()()
+		return s.takeSnapshot(ctx, name)
+	})
+}
+
+func (s *Runtime) serveRestore(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := validateSnapshotName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.operation(w, r, "restore", initializedStates, func(ctx context.Context) (any, error) {
+		defer s.pauseWatching()()
+		restored, err := s.restoreSnapshot(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if s.lifecycle.State() != stateRunning {
+			// Start runs the server again.
+			return restored, nil
+		}
+		if err = s.restartAfterRestore(ctx); err != nil {
+			return nil, fmt.Errorf("restored %s, but: %w", restored, err)
+		}
+		return restored, nil
+	})
+}
+
+func (s *Runtime) serveReset(w http.ResponseWriter, r *http.Request) {
+	s.operation(w, r, "reset", []lifecycleState{stateRunning}, func(ctx context.Context) (any, error) {
+		return s.resetBuckets(ctx)
+	})
+}
+
+func (s *Runtime) serveCompose(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		File string `json:"file"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.File == "" {
+		http.Error(w, `expected {"file": <path of the compose file>}`, http.StatusBadRequest)
+		return
+	}
+	s.operation(w, r, "compose", initializedStates, func(context.Context) (any, error) {
+		return s.exportCompose(request.File)
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOperationsServer(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "run", "operations.json")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ping", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("pong"))
+	})
+	server, err := newOperationsServer(file, mux)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("operations file mode = %v", info.Mode().Perm())
+	}
+	var access operationsAccess
+	if err = json.Unmarshal([]byte(readFile(t, file)), &access); err != nil {
+		t.Fatal(err)
+	}
+	if access.URL != server.url || access.Token != server.token || len(access.Token) != 32 {
+		t.Errorf("access = %+v", access)
+	}
+
+	for token, expected := range map[string]int{access.Token: http.StatusOK, "wrong": http.StatusUnauthorized} {
+		request, _ := http.NewRequest(http.MethodGet, access.URL+"/ping", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = response.Body.Close()
+		if response.StatusCode != expected {
+			t.Errorf("token %q: status %d, want %d", token, response.StatusCode, expected)
+		}
+	}
+
+	if err = server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("operations file left: %v", err)
+	}
+}
+
+func TestOperationsWaitForAnInitializedServer(t *testing.T) {
+	runtime := NewRuntime()
+	routes := runtime.operationRoutes()
+	recorder := httptest.NewRecorder()
+	routes.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/compose", strings.NewReader(`{"file":"compose.yaml"}`)))
+	if recorder.Code != http.StatusConflict {
+		t.Errorf("POST /compose: status %d", recorder.Code)
+	}
+	recorder = httptest.NewRecorder()
+	routes.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/compose", strings.NewReader(`{}`)))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("POST /compose without a file: status %d", recorder.Code)
+	}
+	for path, expected := range map[string]int{
+		"/snapshots/good":          http.StatusConflict,
+		"/snapshots/good/restore":  http.StatusConflict,
+		"/snapshots/..bad/restore": http.StatusBadRequest,
+		"/reset":                   http.StatusConflict,
+	} {
+		recorder := httptest.NewRecorder()
+		routes.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, nil))
+		if recorder.Code != expected {
+			t.Errorf("POST %s: status %d, want %d", path, recorder.Code, expected)
+		}
+	}
+}
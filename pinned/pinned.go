@@ -0,0 +1,67 @@
+// Package pinned lists the vetted MinIO releases, pinned by digest. The agent
+// runs and deploys them, and the miniotest helper starts them, so tests run
+// the exact image that is deployed.
+package pinned
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/codefly-dev/core/resources"
+)
+
+// Release is a vetted MinIO release, pinned by digest.
+type Release struct {
+	Tag string
+	// Digest of the multi-architecture index. Deployments use it, whatever
+	// the architecture of the cluster nodes.
+	Digest string
+	// Platforms are the digests of the single-architecture images, keyed by
+	// GOARCH. The local runtime uses the one of its architecture, and falls
+	// back to the index digest.
+	Platforms map[string]string
+}
+
+// ImageName is the repository of the MinIO images.
+const ImageName = "minio/minio"
+
+// Releases are the vetted MinIO releases, newest first. A service runs the
+// release of its settings, or the newest one. Every release lists the images
+// of amd64 and arm64: go run ./pinned/resolve <tag> prints its entry.
+var Releases = []*Release{
+	{
+		Tag:    "RELEASE.2025-09-07T16-13-09Z",
+		Digest: "sha256:14cea493d9a34af32f524e538b8346cf79f3321eff8e708c1e2960462bd8936e",
+	},
+}
+
+// Find returns the vetted release of a tag, the newest one when the tag is
+// empty.
+func Find(tag string) (*Release, error) {
+	if tag == "" {
+		return Releases[0], nil
+	}
+	var tags []string
+	for _, release := range Releases {
+		if release.Tag == tag {
+			return release, nil
+		}
+		tags = append(tags, release.Tag)
+	}
+	return nil, fmt.Errorf("release %s is not a vetted MinIO release (expected one of %s)", tag, strings.Join(tags, ", "))
+}
+
+// DeploymentImage is pinned to the multi-architecture index.
+func (r *Release) DeploymentImage() *resources.DockerImage {
+	return &resources.DockerImage{Name: ImageName, Tag: r.Tag, Digest: r.Digest}
+}
+
+// RuntimeImage is pinned to the image of an architecture when the release
+// lists it.
+func (r *Release) RuntimeImage(arch string) *resources.DockerImage {
+	digest, ok := r.Platforms[arch]
+	if !ok {
+		digest = r.Digest
+	}
+	return &resources.DockerImage{Name: ImageName, Tag: r.Tag, Digest: digest}
+}
@@ -0,0 +1,127 @@
+// Command resolve prints the pinned entries of MinIO releases, read from the
+// Docker Hub registry: the digest of the multi-architecture index and of the
+// linux image of each architecture the agent runs on.
+//
+//	go run ./pinned/resolve RELEASE.2025-09-07T16-13-09Z
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/codefly-dev/service-minio/pinned"
+)
+
+// architectures the release must have an image of.
+var architectures = []string{"amd64", "arm64"}
+
+const (
+	registry = "https://registry-1.docker.io"
+	auth     = "https://auth.docker.io/token"
+)
+
+type index struct {
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			Architecture string `json:"architecture"`
+			OS           string `json:"os"`
+			Variant      string `json:"variant"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: resolve <release tag>...")
+		os.Exit(2)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	token, err := pullToken(client)
+	if err != nil {
+		fail(err)
+	}
+	for _, tag := range os.Args[1:] {
+		release, err := resolve(client, token, tag)
+		if err != nil {
+			fail(err)
+		}
+		fmt.Printf("\t{\n\t\tTag:    %q,\n\t\tDigest: %q,\n\t\tPlatforms: map[string]string{\n", release.Tag, release.Digest)
+		for _, arch := range architectures {
+			fmt.Printf("\t\t\t%q: %q,\n", arch, release.Platforms[arch])
+		}
+		fmt.Printf("\t\t},\n\t},\n")
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// pullToken is an anonymous token to pull the MinIO images.
+func pullToken(client *http.Client) (string, error) {
+	query := url.Values{"service": {"registry.docker.io"}, "scope": {"repository:" + pinned.ImageName + ":pull"}}
+	response, err := client.Get(auth + "?" + query.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token: %s", response.Status)
+	}
+	var token struct {
+		Token string `json:"token"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	return token.Token, nil
+}
+
+// resolve reads the index of a tag and the digests of its linux images.
+func resolve(client *http.Client, token string, tag string) (*pinned.Release, error) {
+	request, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v2/%s/manifests/%s", registry, pinned.ImageName, tag), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Accept", strings.Join([]string{
+		"application/vnd.oci.image.index.v1+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+	}, ", "))
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return nil, fmt.Errorf("%s: %s: %s", tag, response.Status, strings.TrimSpace(string(message)))
+	}
+	release := &pinned.Release{Tag: tag, Digest: response.Header.Get("Docker-Content-Digest"), Platforms: make(map[string]string)}
+	if release.Digest == "" {
+		return nil, fmt.Errorf("%s: the registry sent no digest", tag)
+	}
+	var manifests index
+	if err = json.NewDecoder(response.Body).Decode(&manifests); err != nil {
+		return nil, fmt.Errorf("%s: %w", tag, err)
+	}
+	for _, manifest := range manifests.Manifests {
+		if manifest.Platform.OS == "linux" && manifest.Platform.Variant == "" {
+			release.Platforms[manifest.Platform.Architecture] = manifest.Digest
+		}
+	}
+	for _, arch := range architectures {
+		if release.Platforms[arch] == "" {
+			return nil, fmt.Errorf("%s: no linux/%s image in the index", tag, arch)
+		}
+	}
+	return release, nil
+}
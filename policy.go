@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+// ConsumerPolicy is the canned MinIO policy that grants one consumer the
+// access it claimed. It is attached to User, the MinIO user of the consumer.
+type ConsumerPolicy struct {
+	Consumer string
+	Name     string
+	User     string
+	Document string
+}
+
+type policyDocument struct {
+	Version   string             `json:"Version"`
+	Statement []*policyStatement `json:"Statement"`
+}
+
+type policyStatement struct {
+	Effect    string                    `json:"Effect"`
+	Action    []string                  `json:"Action"`
+	Resource  []string                  `json:"Resource"`
+	Condition map[string]map[string]any `json:"Condition,omitempty"`
+}
+
+var (
+	listActions      = []string{"s3:ListBucket", "s3:GetBucketLocation"}
+	readOnlyActions  = []string{"s3:GetObject"}
+	readWriteActions = []string{"s3:GetObject", "s3:PutObject", "s3:DeleteObject", "s3:AbortMultipartUpload", "s3:ListMultipartUploadParts"}
+)
+
+// PolicyName is the canned policy name of a consumer, e.g. backend/api is
+// granted backend-api-buckets.
+func PolicyName(consumer string) string {
+	return strings.ReplaceAll(consumer, "/", "-") + "-buckets"
+}
+
+// maxUserLength is the longest access key MinIO accepts for a user.
+const maxUserLength = 20
+
+// ConsumerUser is the access key of the MinIO user of a consumer, e.g.
+// backend/api signs in as backend-api. Long uniques are shortened with a hash.
+func ConsumerUser(consumer string) string {
+	user := strings.ReplaceAll(consumer, "/", "-")
+	if len(user) <= maxUserLength {
+		return user
+	}
+	hash := sha256.Sum256([]byte(consumer))
+	return user[:maxUserLength-7] + "-" + hex.EncodeToString(hash[:])[:6]
+}
+
+// consumerSecretKey derives the secret key of the user of a consumer from the
+// root secret key: the runtime and every deployment render compute the same
+// key without storing it, and it cannot be guessed without the root key.
+func consumerSecretKey(rootSecretKey string, consumer string) string {
+	mac := hmac.New(sha256.New, []byte(rootSecretKey))
+	mac.Write([]byte("consumer:" + consumer))
+	return hex.EncodeToString(mac.Sum(nil))[:40]
+}
+
+// rootKeyConsumers are the dependents that get the root credentials: all of
+// them as long as none claims buckets. Once one does, the root keys are kept
+// back and dependents without claims get no keys.
+func rootKeyConsumers(consumers []*ConsumerClaims) []string {
+	var names []string
+	for _, consumer := range consumers {
+		if len(consumer.Claims) > 0 {
+			return nil
+		}
+		names = append(names, consumer.Consumer)
+	}
+	return names
+}
+
+// consumerPolicies builds one canned policy per consumer from its claims.
+func consumerPolicies(consumers []*ConsumerClaims) ([]*ConsumerPolicy, error) {
+	var policies []*ConsumerPolicy
+	for _, consumer := range consumers {
+		if len(consumer.Claims) == 0 {
+			continue
+		}
+		document := &policyDocument{Version: "2012-10-17"}
+		for _, claim := range consumer.Claims {
+			document.Statement = append(document.Statement, claimStatements(claim)...)
+		}
+		content, err := json.Marshal(document)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, &ConsumerPolicy{
+			Consumer: consumer.Consumer,
+			Name:     PolicyName(consumer.Consumer),
+			User:     ConsumerUser(consumer.Consumer),
+			Document: string(content),
+		})
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+	return policies, nil
+}
+
+func claimStatements(claim *BucketClaim) []*policyStatement {
+	list := &policyStatement{
+		Effect:   "Allow",
+		Action:   listActions,
+		Resource: []string{"arn:aws:s3:::" + claim.Name},
+	}
+	if claim.Prefix != "" {
+		list.Condition = map[string]map[string]any{
+			"StringLike": {"s3:prefix": []string{claim.Prefix + "*"}},
+		}
+	}
+	objects := &policyStatement{
+		Effect:   "Allow",
+		Action:   readOnlyActions,
+		Resource: []string{"arn:aws:s3:::" + claim.Name + "/" + claim.Prefix + "*"},
+	}
+	if claim.Access == ReadWrite {
+		objects.Action = readWriteActions
+	}
+	return []*policyStatement{list, objects}
+}
+
+// provisionConsumers creates the policy and the user of every consumer that
+// claims buckets, with the policy attached. The fake backend has no policies:
+// it only accepts the credentials of the users.
+func (s *Runtime) provisionConsumers(ctx context.Context) error {
+	policies, err := consumerPolicies(s.claims)
+	if err != nil {
+		return s.Wool.Wrapf(err, "cannot build the consumer policies")
+	}
+	if s.backend == FakeBackend {
+		for _, policy := range policies {
+			s.fake.AddUser(policy.User, consumerSecretKey(s.secretKey, policy.Consumer))
+		}
+		return nil
+	}
+	admin := &adminClient{
+		endpoint:  &url.URL{Scheme: "http", Host: s.hostReady},
+		accessKey: s.accessKey,
+		secretKey: s.secretKey,
+		region:    s.region(),
+		http:      http.DefaultClient,
+	}
+	for _, policy := range policies {
+		if err = admin.addCannedPolicy(ctx, policy.Name, policy.Document); err != nil {
+			return s.Wool.Wrapf(err, "cannot create policy %s", policy.Name)
+		}
+		if err = admin.addUser(ctx, policy.User, consumerSecretKey(s.secretKey, policy.Consumer)); err != nil {
+			return s.Wool.Wrapf(err, "cannot create the user of %s", policy.Consumer)
+		}
+		if err = admin.setUserPolicy(ctx, policy.User, policy.Name); err != nil {
+			return s.Wool.Wrapf(err, "cannot attach policy %s", policy.Name)
+		}
+		s.Wool.Debug("provisioned consumer", wool.Field("consumer", policy.Consumer), wool.Field("user", policy.User))
+	}
+	return nil
+}
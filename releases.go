@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+
+	"github.com/codefly-dev/core/resources"
+
+	"github.com/codefly-dev/service-minio/pinned"
+)
+
+// MinIORelease is a vetted MinIO release, pinned by digest.
+type MinIORelease = pinned.Release
+
+// Releases are the vetted MinIO releases, newest first. A service runs the
+// release of its settings, or the newest one.
+var Releases = pinned.Releases
+
+// image is the deployed image of the newest release.
+var image = Releases[0].DeploymentImage()
+
+// FindRelease returns the vetted release of a tag, the newest one when the
+// tag is empty.
+func FindRelease(tag string) (*MinIORelease, error) {
+	return pinned.Find(tag)
+}
+
+var (
+	mirrorPattern     = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]*[a-z0-9])?(:[0-9]+)?(/[a-z0-9]+([._-][a-z0-9]+)*)*$`)
+	pullSecretPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]*[a-z0-9])?$`)
+)
+
+// mirrored pulls the image from a registry mirror. Mirrors serve the same
+// content, so the digest still pins the image.
+func mirrored(image *resources.DockerImage, mirror string) *resources.DockerImage {
+	if mirror == "" {
+		return image
+	}
+	return &resources.DockerImage{Name: mirror + "/" + image.Name, Tag: image.Tag, Digest: image.Digest}
+}
+
+// validateImageSettings checks the mirror and the image pull secret.
+func validateImageSettings(settings *Settings) error {
+	if settings.Mirror != "" && !mirrorPattern.MatchString(settings.Mirror) {
+		return fmt.Errorf("mirror %q must be a registry host with an optional path, without scheme, tag or digest", settings.Mirror)
+	}
+	if settings.ImagePullSecret != "" && (len(settings.ImagePullSecret) > 253 || !pullSecretPattern.MatchString(settings.ImagePullSecret)) {
+		return fmt.Errorf("image-pull-secret %q is not a Kubernetes Secret name", settings.ImagePullSecret)
+	}
+	return nil
+}
+
+// release is the MinIO release chosen in the settings.
+func (s *Service) release() (*MinIORelease, error) {
+	if err := validateImageSettings(s.Settings); err != nil {
+		return nil, s.Wool.Wrapf(err, "invalid image settings")
+	}
+	release, err := FindRelease(s.Settings.Release)
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "invalid release setting")
+	}
+	return release, nil
+}
+
+// runtimeImage is the image the local runtime runs.
+func (s *Service) runtimeImage() (*resources.DockerImage, error) {
+	release, err := s.release()
+	if err != nil {
+		return nil, err
+	}
+	return mirrored(release.RuntimeImage(runtime.GOARCH), s.Settings.Mirror), nil
+}
+
+// deploymentImage is the image the manifests render, of the same release as
+// the runtime image.
+func (s *Service) deploymentImage() (*resources.DockerImage, error) {
+	release, err := s.release()
+	if err != nil {
+		return nil, err
+	}
+	return mirrored(release.DeploymentImage(), s.Settings.Mirror), nil
+}
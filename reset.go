@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+// validateSeed checks that the seed of a bucket is a directory of the service.
+func validateSeed(bucket *BucketSettings) error {
+	if bucket.Seed == "" {
+		return nil
+	}
+	if !filepath.IsLocal(bucket.Seed) {
+		return fmt.Errorf("bucket %s: seed %q must be a directory of the service", bucket.Name, bucket.Seed)
+	}
+	return nil
+}
+
+// seedBucket uploads the files of a directory, keyed by their path in it, and
+// returns how many.
+func seedBucket(ctx context.Context, client *minio.Client, bucket string, dir string) (int, error) {
+	count := 0
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		key, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if _, err = client.FPutObject(ctx, bucket, filepath.ToSlash(key), path, minio.PutObjectOptions{}); err != nil {
+			return fmt.Errorf("cannot upload %s: %w", key, err)
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// abortUploads aborts the multipart uploads left in a bucket.
+func abortUploads(ctx context.Context, client *minio.Client, bucket string) error {
+	for upload := range client.ListIncompleteUploads(ctx, bucket, "", true) {
+		if upload.Err != nil {
+			return upload.Err
+		}
+		if err := client.RemoveIncompleteUpload(ctx, bucket, upload.Key); err != nil {
+			return fmt.Errorf("cannot abort the upload of %s: %w", upload.Key, err)
+		}
+	}
+	return nil
+}
+
+// resetBucket brings a bucket back to its settings and seed: a missing bucket
+// is created, the others are emptied and their configuration drift applied.
+// It returns how many objects were seeded.
+func resetBucket(ctx context.Context, client *minio.Client, bucket *BucketSettings, region string, seed string) (int, error) {
+	exists, err := client.BucketExists(ctx, bucket.Name)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		if err = client.MakeBucket(ctx, bucket.Name, minio.MakeBucketOptions{Region: region}); err != nil {
+			return 0, err
+		}
+		if err = configureNewBucket(ctx, client, bucket); err != nil {
+			return 0, err
+		}
+	} else {
+		if err = abortUploads(ctx, client, bucket.Name); err != nil {
+			return 0, err
+		}
+		if err = emptyBucket(ctx, client, bucket.Name); err != nil {
+			return 0, err
+		}
+		state, err := readBucketState(ctx, client, bucket.Name)
+		if err != nil {
+			return 0, err
+		}
+		drifts, err := compareBucket(bucket, state)
+		if err != nil {
+			return 0, err
+		}
+		for _, drift := range drifts {
+			if err = applyBucketProperty(ctx, client, bucket, drift.Property); err != nil {
+				return 0, fmt.Errorf("cannot set %s: %w", drift.Property, err)
+			}
+		}
+	}
+	if seed == "" {
+		return 0, nil
+	}
+	return seedBucket(ctx, client, bucket.Name, seed)
+}
+
+// seedDirectory of a bucket in the service sources, empty without seed.
+func (s *Service) seedDirectory(bucket *BucketSettings) string {
+	if bucket.Seed == "" {
+		return ""
+	}
+	return s.Local(bucket.Seed)
+}
+
+// resetReport tells how many objects each reset bucket was seeded with.
+type resetReport struct {
+	Seeded map[string]int `json:"seeded"`
+}
+
+// resetBuckets resets the declared and claimed buckets of the local server.
+// Namespaces handed out to tests are left alone.
+func (s *Runtime) resetBuckets(ctx context.Context) (*resetReport, error) {
+	client, err := s.client()
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot create minio client")
+	}
+	started := time.Now()
+	report := &resetReport{Seeded: make(map[string]int)}
+	buckets := s.bucketSettings()
+	for _, bucket := range buckets {
+		seeded, err := resetBucket(ctx, client, bucket, s.region(), s.seedDirectory(bucket))
+		if err != nil {
+			return nil, s.Wool.Wrapf(err, "cannot reset bucket %s", bucket.Name)
+		}
+		report.Seeded[bucket.Name] = seeded
+	}
+	s.Wool.Info("reset the buckets", wool.Field("seeded", report.Seeded), wool.Field("duration", time.Since(started)))
+	return report, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/policy"
+
+	"github.com/codefly-dev/service-minio/s3fake"
+)
+
+func fakeClient(t *testing.T) *minio.Client {
+	t.Helper()
+	fake := s3fake.New("codefly", "codefly-secret", DefaultRegion)
+	address, err := fake.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fake.Close() })
+	client, err := minio.New(address, &minio.Options{Creds: credentials.NewStaticV4("codefly", "codefly-secret", ""), Region: DefaultRegion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func objectKeys(t *testing.T, client *minio.Client, bucket string) []string {
+	t.Helper()
+	var keys []string
+	for object := range client.ListObjects(context.Background(), bucket, minio.ListObjectsOptions{Recursive: true}) {
+		if object.Err != nil {
+			t.Fatal(object.Err)
+		}
+		keys = append(keys, object.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestValidateSeed(t *testing.T) {
+	for _, seed := range []string{"", "seed", "testdata/uploads"} {
+		if err := validateSeed(&BucketSettings{Name: "uploads", Seed: seed}); err != nil {
+			t.Errorf("%q: %v", seed, err)
+		}
+	}
+	for _, seed := range []string{"/etc", "../other-service"} {
+		err := validateSeed(&BucketSettings{Name: "uploads", Seed: seed})
+		if err == nil || !strings.Contains(err.Error(), "uploads") {
+			t.Errorf("%q: %v", seed, err)
+		}
+	}
+}
+
+func TestResetBucket(t *testing.T) {
+	ctx := context.Background()
+	client := fakeClient(t)
+	seed := t.TempDir()
+	writeFiles(t, seed, map[string]string{"images/cat.png": "cat", "readme.txt": "seeded"})
+	bucket := &BucketSettings{Name: "uploads", Versioning: true}
+
+	// A missing bucket is created and seeded.
+	seeded, err := resetBucket(ctx, client, bucket, DefaultRegion, seed)
+	if err != nil || seeded != 2 {
+		t.Fatalf("seeded %d: %v", seeded, err)
+	}
+	if got := strings.Join(objectKeys(t, client, "uploads"), ","); got != "images/cat.png,readme.txt" {
+		t.Errorf("objects = %s", got)
+	}
+
+	// A test run changes objects and configuration.
+	if _, err = client.PutObject(ctx, "uploads", "run/1.txt", strings.NewReader("1"), 1, minio.PutObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.PutObject(ctx, "uploads", "readme.txt", strings.NewReader("changed"), 7, minio.PutObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = (&minio.Core{Client: client}).NewMultipartUpload(ctx, "uploads", "big.bin", minio.PutObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	document, err := anonymousPolicyDocument("uploads", policy.BucketPolicyReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.SetBucketPolicy(ctx, "uploads", document); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.SuspendVersioning(ctx, "uploads"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = resetBucket(ctx, client, bucket, DefaultRegion, seed); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(objectKeys(t, client, "uploads"), ","); got != "images/cat.png,readme.txt" {
+		t.Errorf("objects after reset = %s", got)
+	}
+	object, err := client.GetObject(ctx, "uploads", "readme.txt", minio.GetObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(object)
+	if err != nil || string(content) != "seeded" {
+		t.Errorf("readme.txt = %q, %v", content, err)
+	}
+	for upload := range client.ListIncompleteUploads(ctx, "uploads", "", true) {
+		t.Errorf("upload left: %+v", upload)
+	}
+	state, err := readBucketState(ctx, client, "uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drifts, err := compareBucket(bucket, state); err != nil || len(drifts) > 0 {
+		t.Errorf("configuration after reset: %v, %v", drifts, err)
+	}
+}
+
+func TestResetBucketWithoutSeed(t *testing.T) {
+	ctx := context.Background()
+	client := fakeClient(t)
+	bucket := &BucketSettings{Name: "scratch"}
+	if err := client.MakeBucket(ctx, "scratch", minio.MakeBucketOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.PutObject(ctx, "scratch", "tmp.txt", strings.NewReader("x"), 1, minio.PutObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resetBucket(ctx, client, bucket, DefaultRegion, ""); err != nil {
+		t.Fatal(err)
+	}
+	if keys := objectKeys(t, client, "scratch"); len(keys) > 0 {
+		t.Errorf("objects after reset = %v", keys)
+	}
+	if _, err := resetBucket(ctx, client, bucket, DefaultRegion, filepath.Join(t.TempDir(), "missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing seed: %v", err)
+	}
+}
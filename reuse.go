@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+// containerFingerprint is what a container runs with. Init reuses the
+// running container whose fingerprint is the wanted one. Fingerprints are
+// only compared in memory, and changes name the environment variables
+// without their values.
+type containerFingerprint struct {
+	Image       string
+	Command     []string
+	Ports       []string
+	Mounts      []string
+	Environment map[string]string
+}
+
+func fingerprint(definition *containerDefinition) *containerFingerprint {
+	f := &containerFingerprint{
+		Image:       definition.Image.FullName(),
+		Command:     definition.Command,
+		Environment: make(map[string]string),
+	}
+	for _, port := range definition.Ports {
+		f.Ports = append(f.Ports, fmt.Sprintf("%d:%d", port.Host, port.Container))
+	}
+	for _, mount := range definition.Mounts {
+		f.Mounts = append(f.Mounts, mount.Source+":"+mount.Target)
+	}
+	sort.Strings(f.Ports)
+	sort.Strings(f.Mounts)
+	for _, env := range definition.Environment {
+		f.Environment[env.Key] = fmt.Sprintf("%v", env.Value)
+	}
+	return f
+}
+
+// changes explain why a container of the fingerprint does not match the
+// wanted one, empty when it does.
+func (f *containerFingerprint) changes(wanted *containerFingerprint) []string {
+	var changes []string
+	if f.Image != wanted.Image {
+		changes = append(changes, fmt.Sprintf("image %s is now %s", f.Image, wanted.Image))
+	}
+	if !slices.Equal(f.Command, wanted.Command) {
+		changes = append(changes, fmt.Sprintf("command %v is now %v", f.Command, wanted.Command))
+	}
+	if !slices.Equal(f.Ports, wanted.Ports) {
+		changes = append(changes, fmt.Sprintf("ports %v are now %v", f.Ports, wanted.Ports))
+	}
+	if !slices.Equal(f.Mounts, wanted.Mounts) {
+		changes = append(changes, fmt.Sprintf("mounts %v are now %v", f.Mounts, wanted.Mounts))
+	}
+	keys := make(map[string]bool)
+	for key := range f.Environment {
+		keys[key] = true
+	}
+	for key := range wanted.Environment {
+		keys[key] = true
+	}
+	var environment []string
+	for key := range keys {
+		if f.Environment[key] != wanted.Environment[key] {
+			environment = append(environment, key)
+		}
+	}
+	sort.Strings(environment)
+	for _, key := range environment {
+		_, had := f.Environment[key]
+		_, has := wanted.Environment[key]
+		switch {
+		case !had:
+			changes = append(changes, key+" is new")
+		case !has:
+			changes = append(changes, key+" is removed")
+		default:
+			changes = append(changes, key+" changed")
+		}
+	}
+	return changes
+}
+
+// dockerContainer is an inspected container.
+type dockerContainer struct {
+	container.InspectResponse
+}
+
+// fingerprint of the container, without the environment its image sets.
+func (c *dockerContainer) fingerprint(imageEnvironment []string) *containerFingerprint {
+	f := &containerFingerprint{Environment: make(map[string]string)}
+	if c.Config != nil {
+		f.Image = c.Config.Image
+		f.Command = c.Config.Cmd
+	}
+	if c.ContainerJSONBase != nil && c.HostConfig != nil {
+		for port, bindings := range c.HostConfig.PortBindings {
+			for _, binding := range bindings {
+				f.Ports = append(f.Ports, binding.HostPort+":"+port.Port())
+			}
+		}
+	}
+	for _, mount := range c.Mounts {
+		f.Mounts = append(f.Mounts, mount.Source+":"+mount.Destination)
+	}
+	sort.Strings(f.Ports)
+	sort.Strings(f.Mounts)
+	if c.Config != nil {
+		for _, env := range c.Config.Env {
+			if slices.Contains(imageEnvironment, env) {
+				continue
+			}
+			key, value, _ := strings.Cut(env, "=")
+			f.Environment[key] = value
+		}
+	}
+	return f
+}
+
+// withoutImageEnvironment drops the variables set to the default of the
+// image, which a container fingerprint cannot tell apart.
+func (f *containerFingerprint) withoutImageEnvironment(imageEnvironment []string) *containerFingerprint {
+	copied := *f
+	copied.Environment = make(map[string]string)
+	for key, value := range f.Environment {
+		if !slices.Contains(imageEnvironment, key+"="+value) {
+			copied.Environment[key] = value
+		}
+	}
+	return &copied
+}
+
+// dockerClient talks to the Docker daemon of the environment, like the
+// runner does.
+func dockerClient() (*client.Client, error) {
+	return client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+}
+
+// invalidContainerName matches the characters Docker refuses in a container
+// name.
+var invalidContainerName = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// containerName is the name the runner gives the container of the service.
+func containerName(unique string) string {
+	return invalidContainerName.ReplaceAllString(unique, "-")
+}
+
+// runningContainer is the running container of the name, nil without one.
+// Docker filters names as patterns, so the name is anchored.
+func runningContainer(ctx context.Context, docker *client.Client, name string) (*dockerContainer, error) {
+	containers, err := docker.ContainerList(ctx, container.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("name", "^/"+regexp.QuoteMeta(name)+"$")),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list the containers: %w", err)
+	}
+	if len(containers) == 0 {
+		return nil, nil
+	}
+	inspected, err := docker.ContainerInspect(ctx, containers[0].ID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot inspect container %s: %w", name, err)
+	}
+	return &dockerContainer{InspectResponse: inspected}, nil
+}
+
+// imageEnvironment is the environment an image gives its containers.
+func imageEnvironment(ctx context.Context, docker *client.Client, image string) ([]string, error) {
+	inspected, err := docker.ImageInspect(ctx, image)
+	if err != nil {
+		return nil, fmt.Errorf("cannot inspect image %s: %w", image, err)
+	}
+	if inspected.Config == nil {
+		return nil, nil
+	}
+	return inspected.Config.Env, nil
+}
+
+// reusableContainer is the running container of a definition, nil when there
+// is none or it runs with another configuration.
+func (s *Runtime) reusableContainer(ctx context.Context, definition *containerDefinition) *dockerContainer {
+	docker, err := dockerClient()
+	if err != nil {
+		s.Wool.Warn("cannot connect to docker", wool.ErrField(err))
+		return nil
+	}
+	defer docker.Close()
+	running, err := runningContainer(ctx, docker, containerName(s.UniqueWithWorkspace()))
+	if err == nil && running != nil {
+		var env []string
+		if env, err = imageEnvironment(ctx, docker, running.Image); err == nil {
+			changes := running.fingerprint(env).changes(fingerprint(definition).withoutImageEnvironment(env))
+			if len(changes) == 0 {
+				return running
+			}
+			s.Wool.Info("recreating the minio container: its configuration changed", wool.Field("changes", changes))
+			return nil
+		}
+	}
+	if err != nil {
+		s.Wool.Warn("cannot inspect the running container", wool.ErrField(err))
+	}
+	return nil
+}
+
+// followContainerLogs sends the output of a reused container to a writer,
+// like the runner does for the containers it starts.
+func (s *Runtime) followContainerLogs(id string, output io.Writer) {
+	s.stopContainerLogs()
+	docker, err := dockerClient()
+	if err != nil {
+		s.Wool.Warn("cannot follow the logs of the container", wool.ErrField(err))
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	logs, err := docker.ContainerLogs(ctx, id, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Since:      time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		cancel()
+		_ = docker.Close()
+		s.Wool.Warn("cannot follow the logs of the container", wool.ErrField(err))
+		return
+	}
+	go func() {
+		defer docker.Close()
+		defer logs.Close()
+		// The runner creates the container without a TTY: the stream is
+		// multiplexed.
+		_, _ = stdcopy.StdCopy(output, output, logs)
+	}()
+	s.containerLogs = cancel
+}
+
+// removeReusedContainer removes the container Init reused, which the runner
+// did not create and does not know about.
+func (s *Runtime) removeReusedContainer(ctx context.Context) error {
+	if s.reusedContainer == "" {
+		return nil
+	}
+	docker, err := dockerClient()
+	if err != nil {
+		return err
+	}
+	defer docker.Close()
+	err = docker.ContainerRemove(ctx, s.reusedContainer, container.RemoveOptions{Force: true})
+	if err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("cannot remove the container: %w", err)
+	}
+	s.reusedContainer = ""
+	return nil
+}
+
+func (s *Runtime) stopContainerLogs() {
+	if s.containerLogs != nil {
+		s.containerLogs()
+		s.containerLogs = nil
+	}
+}
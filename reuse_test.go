@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/codefly-dev/core/resources"
+)
+
+func testDefinition() *containerDefinition {
+	return &containerDefinition{
+		Image:   &resources.DockerImage{Name: "minio/minio", Tag: "RELEASE.2025-09-07T16-13-09Z"},
+		Command: []string{"server", "--json", "/data"},
+		Ports:   []*portMapping{{Host: 31000, Container: minioPort}},
+		Mounts:  []*mountPoint{{Source: "/workspace/.codefly/data", Target: "/data"}},
+		Environment: []*resources.EnvironmentVariable{
+			resources.Env(AccessKeyField.EnvironmentKey(), "minio"),
+			resources.Env(SecretKeyField.EnvironmentKey(), "very-secret-password"),
+		},
+	}
+}
+
+func TestContainerFingerprint(t *testing.T) {
+	var containers []*dockerContainer
+	err := json.Unmarshal([]byte(`[{
+		"Id": "4f1c",
+		"Image": "sha256:9a1d",
+		"Config": {
+			"Image": "IMAGE",
+			"Cmd": ["server", "--json", "/data"],
+			"Env": ["MINIO_ROOT_USER=minio", "MINIO_ROOT_PASSWORD=very-secret-password", "MINIO_ACCESS_KEY=minio",
+				"MINIO_SECRET_KEY=very-secret-password", "PATH=/usr/bin", "MINIO_CONFIG_ENV_FILE=config.env"]
+		},
+		"HostConfig": {"PortBindings": {"9000/tcp": [{"HostIp": "", "HostPort": "31000"}]}},
+		"Mounts": [{"Source": "/workspace/.codefly/data", "Destination": "/data"}]
+	}]`), &containers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := containers[0]
+	// The image as the runner created the container.
+	container.Config.Image = testDefinition().Image.FullName()
+	env := []string{"PATH=/usr/bin", "MINIO_CONFIG_ENV_FILE=config.env"}
+	definition := testDefinition()
+	definition.Environment = append(definition.Environment,
+		resources.Env("MINIO_ROOT_USER", "minio"), resources.Env("MINIO_ROOT_PASSWORD", "very-secret-password"))
+	wanted := fingerprint(definition).withoutImageEnvironment(env)
+	if changes := container.fingerprint(env).changes(wanted); len(changes) != 0 {
+		t.Errorf("same definition changed: %v", changes)
+	}
+	definition.Environment = append(definition.Environment, resources.Env("MINIO_CONFIG_ENV_FILE", "config.env"))
+	if changes := container.fingerprint(env).changes(fingerprint(definition).withoutImageEnvironment(env)); len(changes) != 0 {
+		t.Errorf("variable set to the image default changed: %v", changes)
+	}
+	definition.Environment[1] = resources.Env(SecretKeyField.EnvironmentKey(), "rotated-password")
+	changes := container.fingerprint(env).changes(fingerprint(definition).withoutImageEnvironment(env))
+	if strings.Join(changes, ",") != "MINIO_SECRET_KEY changed" || strings.Contains(strings.Join(changes, ","), "password") {
+		t.Errorf("changes = %q", changes)
+	}
+}
+
+func TestContainerName(t *testing.T) {
+	if name := containerName("workspace/store/storage"); name != "workspace-store-storage" {
+		t.Errorf("name = %s", name)
+	}
+}
+
+func TestFingerprintExplainsChanges(t *testing.T) {
+	previous := fingerprint(testDefinition())
+	definition := testDefinition()
+	definition.Ports[0].Host = 32000
+	definition.Environment[1] = resources.Env(SecretKeyField.EnvironmentKey(), "rotated-password")
+	definition.Environment = append(definition.Environment, resources.Env("MINIO_SITE_REGION", "eu-west-1"))
+	changes := previous.changes(fingerprint(definition))
+	expected := []string{
+		"ports [31000:9000] are now [32000:9000]",
+		"MINIO_SECRET_KEY changed",
+		"MINIO_SITE_REGION is new",
+	}
+	if strings.Join(changes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("changes = %q", changes)
+	}
+}
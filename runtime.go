@@ -2,6 +2,11 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/minio/minio-go/v7"
@@ -18,6 +23,8 @@ import (
 	dockerrun "github.com/codefly-dev/core/runners/dockerrun"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	_ "github.com/lib/pq"
+
+	"github.com/codefly-dev/service-minio/s3fake"
 )
 
 type Runtime struct {
@@ -26,38 +33,99 @@ type Runtime struct {
 
 	// internal
 	runnerEnvironment *dockerrun.DockerEnvironment
-
-	minioPort uint16
+	// definition of the server Init ran, exported to compose on demand
+	definition *containerDefinition
+	// operations serves the one-shot operations on the server
+	operations *operationsServer
+	// nativeServer replaces the Docker runner in a native runtime context
+	native *nativeServer
+	// fake serves S3 from the agent process with the fake backend
+	fake        *s3fake.Server
+	fakeAddress string
+	// backend Init ran the server with, and the state of the server
+	backend     string
+	serverState string
+	// logTail keeps the last lines of the server, watcher reports crashes
+	logTail *logTail
+	// reusedContainer is the ID of the running container Init reused
+	reusedContainer string
+	// containerLogs stops following the logs of a reused container
+	containerLogs context.CancelFunc
+	watcher       *healthWatcher
+	stopWatching  context.CancelFunc
+	watcherDone   chan struct{}
+	// auditCollector writes the audit log when no endpoint receives it
+	auditCollector *auditCollector
 
 	// For ready check
 	hostReady string
+
+	// lifecycle orders the RPCs, which mutate the fields above
+	lifecycle runtimeLifecycle
 }
 
 func NewRuntime() *Runtime {
 	return &Runtime{
 		Service: NewService(),
+		logTail: &logTail{},
 	}
 }
 
 func (s *Runtime) Load(ctx context.Context, req *runtimev0.LoadRequest) (*runtimev0.LoadResponse, error) {
+	if err := s.lifecycle.enter("Load", stateUnloaded, stateLoaded, stateStopped, stateDestroyed); err != nil {
+		return nil, err
+	}
+	defer s.leave()
 	defer s.Wool.Catch()
 
-	return s.Runtime.LoadService(ctx, req, services.RuntimeLoad{
+	s.environment = req.GetEnvironment().GetName()
+
+	response, err := s.Runtime.LoadService(ctx, req, services.RuntimeLoad{
 		Settings:     s.Settings,
 		Requirements: requirements,
 		ResolveEndpoints: func(ctx context.Context, endpoints []*basev0.Endpoint) error {
 			s.Wool.Debug("endpoints", wool.Field("endpoints", resources.MakeManyEndpointSummary(endpoints)))
-			endpoint, err := resources.FindTCPEndpoint(ctx, endpoints)
-			if err != nil {
-				return err
-			}
-			s.TcpEndpoint = endpoint
-			return nil
+			return s.resolveEndpoints(ctx, endpoints)
 		},
 	})
+	if err != nil {
+		return response, err
+	}
+	if _, err = s.release(); err != nil {
+		return s.Runtime.LoadError(err)
+	}
+	if err = validateBackend(s.Settings.Backend); err != nil {
+		return s.Runtime.LoadError(s.Wool.Wrapf(err, "invalid backend setting"))
+	}
+	if err = validateAuditLog(s.Settings.AuditLog); err != nil {
+		return s.Runtime.LoadError(s.Wool.Wrapf(err, "invalid audit-log setting"))
+	}
+	if err = validateStopMode(s.Settings.StopMode); err != nil {
+		return s.Runtime.LoadError(s.Wool.Wrapf(err, "invalid stop-mode setting"))
+	}
+	if _, err = namespaceLease(s.Settings.Namespaces); err != nil {
+		return s.Runtime.LoadError(s.Wool.Wrapf(err, "invalid namespaces setting"))
+	}
+	for _, bucket := range s.Settings.Buckets {
+		if err = validateSeed(bucket); err != nil {
+			return s.Runtime.LoadError(s.Wool.Wrapf(err, "invalid bucket setting"))
+		}
+	}
+	if err = validateBucketLifecycles(s.Settings.Buckets); err != nil {
+		return s.Runtime.LoadError(s.Wool.Wrapf(err, "invalid bucket setting"))
+	}
+	if err = s.loadBucketClaims(); err != nil {
+		return s.Runtime.LoadError(err)
+	}
+	s.lifecycle.set(stateLoaded)
+	return response, nil
 }
 
 func (s *Runtime) Init(ctx context.Context, req *runtimev0.InitRequest) (*runtimev0.InitResponse, error) {
+	if err := s.lifecycle.enter("Init", stateLoaded, stateInitialized, stateStopped, stateDestroyed); err != nil {
+		return nil, err
+	}
+	defer s.leave()
 	defer s.Wool.Catch()
 	ctx = s.Wool.Inject(ctx)
 
@@ -75,6 +143,11 @@ func (s *Runtime) Init(ctx context.Context, req *runtimev0.InitRequest) (*runtim
 		return s.Runtime.InitError(err)
 	}
 
+	err = ValidateBucketClaims(s.claims)
+	if err != nil {
+		return s.Runtime.InitError(err)
+	}
+
 	net, err := resources.FindNetworkMapping(ctx, s.NetworkMappings, s.TcpEndpoint)
 	if err != nil {
 		return s.Runtime.InitError(err)
@@ -96,9 +169,14 @@ func (s *Runtime) Init(ctx context.Context, req *runtimev0.InitRequest) (*runtim
 	w.Debug("tcp network instance", wool.Field("instance", instance))
 
 	s.Infof("will run on %s", instance.Host)
-	s.minioPort = 9000
 
-	// Create configuration
+	s.stopNamespaces(ctx)
+	if err = s.startNamespaces(); err != nil {
+		return s.Runtime.InitError(err)
+	}
+
+	// Create configuration, replacing the one of a former Init
+	s.Runtime.RuntimeConfigurations = nil
 	for _, inst := range net.Instances {
 		conf, errConn := s.CreateCredentialsConfiguration(ctx, configuration, inst)
 		if errConn != nil {
@@ -109,6 +187,16 @@ func (s *Runtime) Init(ctx context.Context, req *runtimev0.InitRequest) (*runtim
 	}
 	s.Wool.Debug("sending runtime configuration", wool.Field("conf", resources.MakeManyConfigurationSummary(s.Runtime.RuntimeConfigurations)))
 
+	// The exported configuration goes to every dependent: each consumer gets
+	// its keys in a file of its own.
+	consumers, err := s.consumerCredentials()
+	if err != nil {
+		return s.Runtime.InitError(err)
+	}
+	if err = writeConsumerCredentials(s.consumerCredentialsDirectory(), consumers); err != nil {
+		return s.Runtime.InitError(w.Wrapf(err, "cannot write the consumer credentials"))
+	}
+
 	w.Debug("setting up connection string for migrations")
 
 	// Setup a connection string for migration
@@ -118,69 +206,214 @@ func (s *Runtime) Init(ctx context.Context, req *runtimev0.InitRequest) (*runtim
 	}
 	s.hostReady = hostInstance.Host
 
-	// Docker
-	runner, err := dockerrun.NewDockerHeadlessEnvironment(ctx, image, s.UniqueWithWorkspace())
+	// Container, or native server
+	definition, err := s.containerDefinition(ctx, uint16(instance.Port))
 	if err != nil {
 		return s.Runtime.InitError(err)
 	}
+	kind := backend(s.Settings.Backend, req.GetRuntimeContext())
+	mappings := append(append([]*basev0.NetworkMapping{}, s.NetworkMappings...), req.GetDependenciesNetworkMappings()...)
+	if err = s.configureAuditLog(ctx, mappings, definition, kind); err != nil {
+		return s.Runtime.InitError(err)
+	}
 
-	runner.WithOutput(s.Wool)
-	runner.WithCommand("server", "/data")
-	runner.WithPortMapping(ctx, uint16(instance.Port), s.minioPort)
-
-	runner.WithEnvironmentVariables(
-		ctx,
-		resources.Env("MINIO_ACCESS_KEY", s.accessKey),
-		resources.Env("MINIO_SECRET_KEY", s.secretKey),
-	)
-
-	s.runnerEnvironment = runner
-
-	w.Debug("init for runner environment: will start container")
-	err = s.runnerEnvironment.Init(ctx)
+	switch kind {
+	case FakeBackend:
+		err = s.initFake(ctx, definition)
+	case NativeBackend:
+		err = s.initNative(ctx, definition)
+	default:
+		err = s.initDocker(ctx, definition)
+	}
 	if err != nil {
 		return s.Runtime.InitError(err)
 	}
+	s.definition = definition
+	s.backend = kind
+	s.serverState = serverRunning
+	if err = s.serveNamespaces(); err != nil {
+		return s.Runtime.InitError(err)
+	}
+	if err = s.startOperations(); err != nil {
+		return s.Runtime.InitError(err)
+	}
 
+	s.lifecycle.set(stateInitialized)
 	s.Wool.Debug("init successful")
 	return s.Runtime.InitResponse()
 }
 
+// initDocker runs the definition in a container.
+func (s *Runtime) initDocker(ctx context.Context, definition *containerDefinition) error {
+	runner, err := dockerrun.NewDockerHeadlessEnvironment(ctx, definition.Image, s.UniqueWithWorkspace())
+	if err != nil {
+		return err
+	}
+
+	output := io.MultiWriter(newMinIOLogWriter(s.Wool), s.logTail)
+	runner.WithOutput(output)
+	for _, port := range definition.Ports {
+		runner.WithPortMapping(ctx, port.Host, port.Container)
+	}
+	for _, mount := range definition.Mounts {
+		runner.WithMount(mount.Source, mount.Target)
+	}
+	runner.WithCommand(definition.Command...)
+	runner.WithEnvironmentVariables(ctx, definition.Environment...)
+
+	s.runnerEnvironment = runner
+
+	// A running container of the same definition is reused; any other is
+	// replaced, so that an orphan does not hold the ports.
+	s.stopContainerLogs()
+	s.reusedContainer = ""
+	if container := s.reusableContainer(ctx, definition); container != nil {
+		s.Wool.Info("reusing the running minio container")
+		s.reusedContainer = container.ID
+		s.followContainerLogs(container.ID, output)
+		return nil
+	}
+	if err = runner.Shutdown(ctx); err != nil {
+		s.Wool.Debug("no container to replace", wool.ErrField(err))
+	}
+
+	s.Wool.Debug("init for runner environment: will start container")
+	return s.runnerEnvironment.Init(ctx)
+}
+
+// initNative runs the definition with the local minio binary.
+func (s *Runtime) initNative(ctx context.Context, definition *containerDefinition) error {
+	server, err := s.nativeServer(definition)
+	if err != nil {
+		return err
+	}
+	server.warnVersion(ctx, s.Wool, definition.Image.Tag)
+	server.tail = s.logTail
+	s.native = server
+	s.Wool.Debug("init for native environment: will start minio")
+	return server.Start(ctx, s.Wool)
+}
+
 func (s *Runtime) WaitForReady(ctx context.Context) error {
 	defer s.Wool.Catch()
 	ctx = s.Wool.Inject(ctx)
 
 	s.Wool.Debug("waiting for ready")
 
-	minioClient, err := minio.New(s.hostReady, &minio.Options{
-		Creds: credentials.NewStaticV4(s.accessKey, s.secretKey, ""),
-	})
+	minioClient, err := s.client()
 	if err != nil {
 		return s.Wool.Wrapf(err, "cannot create minio client")
 	}
 
-	// Set a timeout for the operation
+	probe := func(ctx context.Context) error {
+		_, err := minioClient.ListBuckets(ctx)
+		return err
+	}
+	if err = waitUntilReady(ctx, readyTimeout, probe); err != nil {
+		return s.Wool.Wrapf(err, "minio is not ready")
+	}
+	return nil
+}
 
-	maxRetry := 5
-	for retry := 0; retry < maxRetry; retry++ {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+// Readiness polling: the first retry waits readyBackoff, doubled at each
+// attempt up to maxReadyBackoff, until readyTimeout.
+const (
+	readyTimeout    = time.Minute
+	readyBackoff    = 100 * time.Millisecond
+	maxReadyBackoff = 2 * time.Second
+)
 
-		// List buckets
-		_, err = minioClient.ListBuckets(ctx)
+// waitUntilReady probes until it succeeds, backing off between attempts, and
+// returns the last probe error once the timeout is reached.
+func waitUntilReady(ctx context.Context, timeout time.Duration, probe func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	delay := readyBackoff
+	for {
+		attempt, cancelAttempt := context.WithTimeout(ctx, 5*time.Second)
+		err := probe(attempt)
+		cancelAttempt()
 		if err == nil {
 			return nil
 		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxReadyBackoff)
 	}
-	return s.Wool.NewError("database is not ready")
+}
+
+// containerDefinition is the container Init runs, the S3 API published on
+// the port of the network instance.
+func (s *Runtime) containerDefinition(ctx context.Context, port uint16) (*containerDefinition, error) {
+	image, err := s.runtimeImage()
+	if err != nil {
+		return nil, err
+	}
+	definition := &containerDefinition{
+		Image:   image,
+		Command: []string{"server", "--json", "/data"},
+		Ports:   []*portMapping{{Host: port, Container: minioPort}},
+		Environment: []*resources.EnvironmentVariable{
+			resources.Env(AccessKeyField.EnvironmentKey(), s.accessKey),
+			resources.Env(SecretKeyField.EnvironmentKey(), s.secretKey),
+			resources.Env("MINIO_SITE_REGION", s.region()),
+		},
+	}
+	if !s.Settings.Ephemeral {
+		dataDir, err := s.dataDirectory()
+		if err != nil {
+			return nil, err
+		}
+		definition.Mounts = append(definition.Mounts, &mountPoint{Source: dataDir, Target: "/data"})
+	}
+	if s.ConsoleEndpoint != nil {
+		console, err := resources.FindNetworkInstanceInNetworkMappings(ctx, s.NetworkMappings, s.ConsoleEndpoint, s.Runtime.NetworkAccess())
+		if err != nil {
+			return nil, err
+		}
+		if console == nil {
+			return nil, s.Wool.NewError("console network instance is nil")
+		}
+		definition.Ports = append(definition.Ports, &portMapping{Host: uint16(console.Port), Container: consolePort})
+		definition.Command = append(definition.Command, "--console-address", fmt.Sprintf(":%d", consolePort))
+	}
+	return definition, nil
+}
+
+// dataDirectory creates the local data directory of a persistent service.
+func (s *Runtime) dataDirectory() (string, error) {
+	dir := s.localDataPath()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", s.Wool.Wrapf(err, "cannot create data directory")
+	}
+	return dir, nil
+}
+
+// client connects to the local server with the root credentials.
+func (s *Runtime) client() (*minio.Client, error) {
+	return minio.New(s.hostReady, &minio.Options{
+		Creds:  credentials.NewStaticV4(s.accessKey, s.secretKey, ""),
+		Region: s.region(),
+	})
 }
 
 func (s *Runtime) Start(ctx context.Context, req *runtimev0.StartRequest) (*runtimev0.StartResponse, error) {
+	if err := s.lifecycle.enter("Start", stateInitialized, stateRunning, stateStopped); err != nil {
+		return nil, err
+	}
+	defer s.leave()
 	defer s.Wool.Catch()
 	ctx = s.Wool.Inject(ctx)
 
 	s.Wool.Debug("starting")
 
+	if err := s.resumeServer(ctx); err != nil {
+		return s.Runtime.StartError(err)
+	}
+
 	s.Wool.Debug("waiting for ready")
 
 	err := s.WaitForReady(ctx)
@@ -188,42 +421,195 @@ func (s *Runtime) Start(ctx context.Context, req *runtimev0.StartRequest) (*runt
 		return s.Runtime.StartError(err)
 	}
 
+	if err = s.provisionBuckets(ctx); err != nil {
+		return s.Runtime.StartError(err)
+	}
+	if err = s.provisionConsumers(ctx); err != nil {
+		return s.Runtime.StartError(err)
+	}
+
+	s.lifecycle.set(stateRunning)
+	s.startWatcher()
 	s.Wool.Debug("start done")
 	return s.Runtime.StartResponse()
 }
 
+// Information does not enter the lifecycle: it reports the status the last
+// RPC published, and answers while another RPC runs.
 func (s *Runtime) Information(ctx context.Context, req *runtimev0.InformationRequest) (*runtimev0.InformationResponse, error) {
-	return s.Runtime.InformationResponse(ctx, req)
+	response, err := s.Runtime.InformationResponse(ctx, req)
+	if err != nil || response == nil {
+		return response, err
+	}
+	response.Details = s.informationDetails()
+	return response, nil
+}
+
+// leave publishes the status of the RPC and lets the next one in.
+func (s *Runtime) leave() {
+	s.lifecycle.publish(s.status())
+	s.lifecycle.leave()
+}
+
+func (s *Runtime) status() runtimeStatus {
+	status := runtimeStatus{
+		backend:    s.backend,
+		server:     s.serverState,
+		watcher:    s.watcher,
+		watching:   s.stopWatching != nil,
+		namespaces: s.namespaces,
+	}
+	if s.operations != nil {
+		status.operations = s.operations.file
+	}
+	if s.Base != nil && s.Identity != nil {
+		status.snapshots = s.snapshotDirectory()
+	}
+	return status
+}
+
+// informationDetails report the lifecycle and the local server.
+func (s *Runtime) informationDetails() map[string]string {
+	status := s.lifecycle.Status()
+	details := map[string]string{
+		"lifecycle": s.lifecycle.State().String(),
+		"server":    serverNotStarted,
+	}
+	if status.backend != "" {
+		details["backend"] = status.backend
+	}
+	if status.server != "" {
+		details["server"] = status.server
+	}
+	details["health"] = healthDetached
+	if status.watcher != nil {
+		for key, value := range status.watcher.details() {
+			details[key] = value
+		}
+		if !status.watching {
+			details["health"] = healthDetached
+		}
+	}
+	if status.namespaces != nil {
+		details["namespaces"] = strconv.Itoa(status.namespaces.Count())
+	}
+	if snapshots := snapshotsDetail(status.snapshots); snapshots != "" {
+		details["snapshots"] = snapshots
+	}
+	if status.operations != "" {
+		details["operations"] = status.operations
+	}
+	return details
 }
 
 func (s *Runtime) Stop(ctx context.Context, req *runtimev0.StopRequest) (*runtimev0.StopResponse, error) {
+	if err := s.lifecycle.enter("Stop", stateInitialized, stateRunning, stateStopped); err != nil {
+		return nil, err
+	}
+	defer s.leave()
 	defer s.Wool.Catch()
+	ctx = s.Wool.Inject(ctx)
 
-	s.Wool.Debug("nothing to stop: keep environment alive")
+	s.stopWatcher()
+	if err := s.stopServer(ctx); err != nil {
+		return s.Runtime.StopError(err)
+	}
 
+	s.lifecycle.set(stateStopped)
 	return s.Runtime.StopResponse()
 }
 
 func (s *Runtime) Destroy(ctx context.Context, req *runtimev0.DestroyRequest) (*runtimev0.DestroyResponse, error) {
+	if err := s.lifecycle.enter("Destroy", stateLoaded, stateInitialized, stateRunning, stateStopped, stateDestroyed); err != nil {
+		return nil, err
+	}
+	defer s.leave()
 	defer s.Wool.Catch()
 	ctx = s.Wool.Inject(ctx)
 
 	s.Wool.Debug("Destroying")
 
-	// Get the runner environment
-	runner, err := dockerrun.NewDockerHeadlessEnvironment(ctx, image, s.UniqueWithWorkspace())
-	if err != nil {
+	s.stopWatcher()
+	s.stopNamespaces(ctx)
+	s.stopOperations()
+	if err := s.destroy(ctx); err != nil {
 		return s.Runtime.DestroyError(err)
 	}
-
-	err = runner.Shutdown(ctx)
-	if err != nil {
+	if err := writeConsumerCredentials(s.consumerCredentialsDirectory(), nil); err != nil {
 		return s.Runtime.DestroyError(err)
 	}
+	s.lifecycle.set(stateDestroyed)
 	return s.Runtime.DestroyResponse()
 }
 
+// destroy stops the server of the backend Init ran, or the one a former
+// agent process left.
+func (s *Runtime) destroy(ctx context.Context) error {
+	s.closeAuditCollector()
+
+	if s.fake != nil {
+		if err := s.fake.Close(); err != nil {
+			return err
+		}
+		s.fake = nil
+		return nil
+	}
+	if s.native != nil {
+		if err := s.native.Shutdown(); err != nil {
+			return err
+		}
+		s.native = nil
+		return nil
+	}
+	// A native server started by a former agent process is found from its
+	// pid file.
+	if _, err := os.Stat(s.nativePidFile()); err == nil {
+		return stopNativeServer(s.nativePidFile())
+	}
+
+	s.stopContainerLogs()
+	if err := s.removeReusedContainer(ctx); err != nil {
+		return err
+	}
+	runner := s.runnerEnvironment
+	if runner == nil {
+		// The container of a former agent process
+		image, err := s.runtimeImage()
+		if err != nil {
+			return err
+		}
+		runner, err = dockerrun.NewDockerHeadlessEnvironment(ctx, image, s.UniqueWithWorkspace())
+		if err != nil {
+			return err
+		}
+	}
+	if err := runner.Shutdown(ctx); err != nil {
+		if !containerGone(err) {
+			return err
+		}
+		s.Wool.Debug("the container is already gone", wool.ErrField(err))
+	}
+	s.runnerEnvironment = nil
+	return nil
+}
+
+// containerGone tells whether Docker failed on a container that does not
+// exist anymore.
+func containerGone(err error) bool {
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "no such container") || strings.Contains(message, "not found")
+}
+
 func (s *Runtime) Test(ctx context.Context, req *runtimev0.TestRequest) (*runtimev0.TestResponse, error) {
+	if err := s.lifecycle.enter("Test", stateRunning); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	defer s.Wool.Catch()
+	ctx = s.Wool.Inject(ctx)
+
+	// The namespaces of the tests do not outlive them.
+	defer s.releaseNamespaces(ctx)
 	return s.Runtime.TestResponse()
 }
 
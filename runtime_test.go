@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitUntilReadyBacksOff(t *testing.T) {
+	var attempts []time.Time
+	probe := func(ctx context.Context) error {
+		attempts = append(attempts, time.Now())
+		if len(attempts) < 4 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	if err := waitUntilReady(context.Background(), time.Minute, probe); err != nil {
+		t.Fatal(err)
+	}
+	// 100ms, 200ms and 400ms between the attempts
+	if waited := attempts[len(attempts)-1].Sub(attempts[0]); waited < 700*time.Millisecond {
+		t.Errorf("%d attempts in %s", len(attempts), waited)
+	}
+}
+
+func TestWaitUntilReadyGivesUpAtTheTimeout(t *testing.T) {
+	refused := errors.New("connection refused")
+	start := time.Now()
+	err := waitUntilReady(context.Background(), 300*time.Millisecond, func(ctx context.Context) error { return refused })
+	if !errors.Is(err, refused) {
+		t.Errorf("err = %v", err)
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("gave up after %s", waited)
+	}
+}
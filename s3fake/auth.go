@@ -0,0 +1,212 @@
+package s3fake
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+)
+
+const (
+	signatureAlgorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat      = "20060102T150405Z"
+	unsignedPayload    = "UNSIGNED-PAYLOAD"
+	// maxClockSkew is the tolerated difference between the request date and
+	// the clock of the server.
+	maxClockSkew = 15 * time.Minute
+)
+
+// signature is the signature version 4 of a request, from its Authorization
+// header or from its query for presigned URLs.
+type signature struct {
+	accessKey     string
+	scope         string
+	date          string
+	region        string
+	signedHeaders []string
+	signature     string
+	amzDate       time.Time
+	// expires is only set for presigned URLs.
+	expires time.Duration
+}
+
+// parseSignature reads the signature of a request.
+func parseSignature(r *http.Request) (*signature, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		return parseAuthorization(header, r.Header.Get("X-Amz-Date"))
+	}
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") == "" {
+		return nil, errAccessDenied
+	}
+	if query.Get("X-Amz-Algorithm") != signatureAlgorithm {
+		return nil, errSignatureMismatch
+	}
+	sig, err := parseCredential(query.Get("X-Amz-Credential"))
+	if err != nil {
+		return nil, err
+	}
+	sig.signedHeaders = strings.Split(query.Get("X-Amz-SignedHeaders"), ";")
+	sig.signature = query.Get("X-Amz-Signature")
+	seconds, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || seconds <= 0 || seconds > 7*24*3600 {
+		return nil, errAuthorizationQuery
+	}
+	sig.expires = time.Duration(seconds) * time.Second
+	sig.amzDate, err = time.Parse(amzDateFormat, query.Get("X-Amz-Date"))
+	if err != nil {
+		return nil, errAuthorizationQuery
+	}
+	return sig, nil
+}
+
+// parseAuthorization reads `AWS4-HMAC-SHA256 Credential=..., SignedHeaders=...,
+// Signature=...`.
+func parseAuthorization(header string, amzDate string) (*signature, error) {
+	algorithm, fields, ok := strings.Cut(header, " ")
+	if !ok || algorithm != signatureAlgorithm {
+		return nil, errSignatureMismatch
+	}
+	values := make(map[string]string)
+	for _, field := range strings.Split(fields, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, errAuthorizationHeader
+		}
+		values[key] = value
+	}
+	sig, err := parseCredential(values["Credential"])
+	if err != nil {
+		return nil, err
+	}
+	sig.signedHeaders = strings.Split(values["SignedHeaders"], ";")
+	sig.signature = values["Signature"]
+	sig.amzDate, err = time.Parse(amzDateFormat, amzDate)
+	if err != nil {
+		return nil, errAuthorizationHeader
+	}
+	return sig, nil
+}
+
+// parseCredential reads `<access key>/<date>/<region>/s3/aws4_request`.
+func parseCredential(credential string) (*signature, error) {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[3] != "s3" || parts[4] != "aws4_request" {
+		return nil, errAuthorizationHeader
+	}
+	return &signature{
+		accessKey: parts[0],
+		date:      parts[1],
+		region:    parts[2],
+		scope:     strings.Join(parts[1:], "/"),
+	}, nil
+}
+
+// verify checks the signature of a request against the credentials of the
+// server or of a user. The payload hash is the one the client signed; the body itself is
+// checked by the handlers.
+func (s *Server) verify(r *http.Request, now time.Time) error {
+	sig, err := parseSignature(r)
+	if err != nil {
+		return err
+	}
+	secretKey, ok := s.secretKeyOf(sig.accessKey)
+	if !ok {
+		return errInvalidAccessKey
+	}
+	if sig.region != s.region {
+		return fmt.Errorf("%w: region %s, server is in %s", errAuthorizationHeader, sig.region, s.region)
+	}
+	if sig.expires > 0 {
+		if now.After(sig.amzDate.Add(sig.expires)) {
+			return errExpiredPresign
+		}
+	} else if now.Sub(sig.amzDate) > maxClockSkew || sig.amzDate.Sub(now) > maxClockSkew {
+		return errRequestTimeTooSkewed
+	}
+
+	payload := r.Header.Get("X-Amz-Content-Sha256")
+	if sig.expires > 0 {
+		payload = unsignedPayload
+	} else if payload == "" {
+		return errAuthorizationHeader
+	}
+	canonical := strings.Join([]string{
+		r.Method,
+		s3utils.EncodePath(r.URL.Path),
+		canonicalQuery(r.URL.Query()),
+		canonicalHeaders(r, sig.signedHeaders),
+		strings.Join(sig.signedHeaders, ";"),
+		payload,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := strings.Join([]string{
+		signatureAlgorithm,
+		sig.amzDate.Format(amzDateFormat),
+		sig.scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+	key := hmacSHA256([]byte("AWS4"+secretKey), sig.date)
+	key = hmacSHA256(key, sig.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	expected := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(sig.signature)) {
+		return errSignatureMismatch
+	}
+	return nil
+}
+
+func (s *Server) secretKeyOf(accessKey string) (string, bool) {
+	if accessKey == s.accessKey {
+		return s.secretKey, true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secretKey, ok := s.users[accessKey]
+	return secretKey, ok
+}
+
+func canonicalQuery(query url.Values) string {
+	query.Del("X-Amz-Signature")
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+func canonicalHeaders(r *http.Request, signed []string) string {
+	var lines []string
+	sorted := append([]string(nil), signed...)
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		var value string
+		switch name {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = strconv.FormatInt(r.ContentLength, 10)
+		case "transfer-encoding":
+			value = strings.Join(r.TransferEncoding, ",")
+		default:
+			var values []string
+			for _, v := range r.Header.Values(name) {
+				values = append(values, strings.Join(strings.Fields(v), " "))
+			}
+			value = strings.Join(values, ",")
+		}
+		lines = append(lines, name+":"+value+"\n")
+	}
+	return strings.Join(lines, "")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,77 @@
+package s3fake
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+)
+
+// s3Error is an S3 error code and the HTTP status it is served with.
+type s3Error struct {
+	Code    string
+	Status  int
+	Message string
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var (
+	errAccessDenied         = &s3Error{"AccessDenied", http.StatusForbidden, "Access Denied."}
+	errInvalidAccessKey     = &s3Error{"InvalidAccessKeyId", http.StatusForbidden, "The access key ID you provided does not exist in our records."}
+	errSignatureMismatch    = &s3Error{"SignatureDoesNotMatch", http.StatusForbidden, "The request signature we calculated does not match the signature you provided."}
+	errAuthorizationHeader  = &s3Error{"AuthorizationHeaderMalformed", http.StatusBadRequest, "The authorization header is malformed."}
+	errAuthorizationQuery   = &s3Error{"AuthorizationQueryParametersError", http.StatusBadRequest, "The presigned URL parameters are malformed."}
+	errExpiredPresign       = &s3Error{"AccessDenied", http.StatusForbidden, "Request has expired."}
+	errRequestTimeTooSkewed = &s3Error{"RequestTimeTooSkewed", http.StatusForbidden, "The difference between the request time and the server's time is too large."}
+	errBadDigest            = &s3Error{"XAmzContentSHA256Mismatch", http.StatusBadRequest, "The provided 'x-amz-content-sha256' header does not match what was computed."}
+	errInvalidBucketName    = &s3Error{"InvalidBucketName", http.StatusBadRequest, "The specified bucket is not valid."}
+	errNoSuchBucket         = &s3Error{"NoSuchBucket", http.StatusNotFound, "The specified bucket does not exist."}
+	errBucketExists         = &s3Error{"BucketAlreadyOwnedByYou", http.StatusConflict, "Your previous request to create the named bucket succeeded and you already own it."}
+	errBucketNotEmpty       = &s3Error{"BucketNotEmpty", http.StatusConflict, "The bucket you tried to delete is not empty."}
+	errNoSuchKey            = &s3Error{"NoSuchKey", http.StatusNotFound, "The specified key does not exist."}
+	errNoSuchUpload         = &s3Error{"NoSuchUpload", http.StatusNotFound, "The specified multipart upload does not exist."}
+	errInvalidPart          = &s3Error{"InvalidPart", http.StatusBadRequest, "One or more of the specified parts could not be found."}
+	errInvalidPartOrder     = &s3Error{"InvalidPartOrder", http.StatusBadRequest, "The list of parts was not in ascending order."}
+	errInvalidRange         = &s3Error{"InvalidRange", http.StatusRequestedRangeNotSatisfiable, "The requested range is not satisfiable."}
+	errMalformedXML         = &s3Error{"MalformedXML", http.StatusBadRequest, "The XML you provided was not well-formed."}
+	errIncompleteBody       = &s3Error{"IncompleteBody", http.StatusBadRequest, "The request body is incomplete."}
+	errNotImplemented       = &s3Error{"NotImplemented", http.StatusNotImplemented, "The fake S3 server does not implement this operation."}
+)
+
+// notFound is the error of a missing bucket sub-resource, e.g.
+// NoSuchBucketPolicy.
+func notFound(code string) *s3Error {
+	return &s3Error{code, http.StatusNotFound, "The specified configuration does not exist."}
+}
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestID string   `xml:"RequestId"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var s3err *s3Error
+	if !errors.As(err, &s3err) {
+		s3err = &s3Error{"InternalError", http.StatusInternalServerError, err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(s3err.Status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	message := s3err.Message
+	if s3err.Error() != err.Error() {
+		message = err.Error()
+	}
+	_ = xml.NewEncoder(w).Encode(errorResponse{
+		Code:      s3err.Code,
+		Message:   message,
+		Resource:  r.URL.Path,
+		RequestID: w.Header().Get("X-Amz-Request-Id"),
+	})
+}
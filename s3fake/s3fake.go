@@ -0,0 +1,829 @@
+// Package s3fake is an in-process S3 server for tests that cannot run MinIO.
+// It serves the endpoint and credential contract of the codefly minio
+// service: path-style requests signed with signature version 4, by headers or
+// presigned URLs. It keeps buckets, objects and multipart uploads in memory.
+// Bucket configurations (versioning, policy, lifecycle, notifications,
+// encryption, tagging) are stored and served back, not enforced: objects
+// are listed as their single null version.
+package s3fake
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is an in-memory S3 server with root credentials, and users that
+// are granted the same access: there are no policies.
+type Server struct {
+	accessKey string
+	secretKey string
+	region    string
+
+	mu sync.Mutex
+	// users are the secret keys of the other credentials, by access key.
+	users   map[string]string
+	buckets map[string]*bucket
+	uploads map[string]*upload
+	// now is the clock of the signature checks.
+	now func() time.Time
+
+	http     *http.Server
+	listener net.Listener
+}
+
+type bucket struct {
+	created time.Time
+	objects map[string]*object
+	// configurations are the sub-resources stored as sent, by name.
+	configurations map[string][]byte
+}
+
+type object struct {
+	data         []byte
+	etag         string
+	contentType  string
+	metadata     http.Header
+	lastModified time.Time
+}
+
+type upload struct {
+	bucket      string
+	key         string
+	contentType string
+	metadata    http.Header
+	initiated   time.Time
+	parts       map[int]*object
+}
+
+// New creates a server accepting requests signed with the credentials for
+// the region.
+func New(accessKey string, secretKey string, region string) *Server {
+	return &Server{
+		accessKey: accessKey,
+		secretKey: secretKey,
+		region:    region,
+		users:     make(map[string]string),
+		buckets:   make(map[string]*bucket),
+		uploads:   make(map[string]*upload),
+		now:       time.Now,
+	}
+}
+
+// AddUser accepts requests signed with other credentials, e.g. those a MinIO
+// server has for a user. Their access is not limited.
+func (s *Server) AddUser(accessKey string, secretKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[accessKey] = secretKey
+}
+
+// Start serves on an address, e.g. ":9000" or "127.0.0.1:0", and returns the
+// address listened on.
+func (s *Server) Start(address string) (string, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return "", err
+	}
+	s.listener = listener
+	s.http = &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		_ = s.http.Serve(listener)
+	}()
+	return listener.Addr().String(), nil
+}
+
+// Close stops serving. The data is kept: Start serves it again.
+func (s *Server) Close() error {
+	if s.http == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.http.Shutdown(ctx)
+	s.http = nil
+	return err
+}
+
+// Reset drops every bucket and upload.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buckets = make(map[string]*bucket)
+	s.uploads = make(map[string]*upload)
+}
+
+// bucketConfigurations are the bucket sub-resources stored as sent, with the
+// error of a bucket without it.
+var bucketConfigurations = map[string]string{
+	"versioning":   "",
+	"policy":       "NoSuchBucketPolicy",
+	"lifecycle":    "NoSuchLifecycleConfiguration",
+	"notification": "",
+	"encryption":   "ServerSideEncryptionConfigurationNotFoundError",
+	"tagging":      "NoSuchTagSet",
+	"object-lock":  "ObjectLockConfigurationNotFoundError",
+	"replication":  "ReplicationConfigurationNotFoundError",
+}
+
+// emptyConfigurations answer sub-resources that always exist.
+var emptyConfigurations = map[string]string{
+	"versioning":   `<VersioningConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></VersioningConfiguration>`,
+	"notification": `<NotificationConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></NotificationConfiguration>`,
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Amz-Request-Id", requestID())
+	w.Header().Set("Server", "s3fake")
+	if strings.HasPrefix(r.URL.Path, "/minio/health/") {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	// The MinIO admin API has no fake.
+	if strings.HasPrefix(r.URL.Path, "/minio/") {
+		writeError(w, r, errNotImplemented)
+		return
+	}
+	if err := s.verify(r, s.now()); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	name, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	switch {
+	case name == "":
+		if r.Method != http.MethodGet {
+			writeError(w, r, errNotImplemented)
+			return
+		}
+		s.listBuckets(w)
+	case key == "":
+		s.serveBucket(w, r, name, query, body)
+	default:
+		s.serveObject(w, r, name, key, query, body)
+	}
+}
+
+func (s *Server) serveBucket(w http.ResponseWriter, r *http.Request, name string, query map[string][]string, body []byte) {
+	has := func(key string) bool {
+		_, ok := query[key]
+		return ok
+	}
+	for configuration := range bucketConfigurations {
+		if has(configuration) {
+			s.serveConfiguration(w, r, name, configuration, body)
+			return
+		}
+	}
+	switch {
+	case r.Method == http.MethodPut:
+		s.createBucket(w, r, name)
+	case r.Method == http.MethodHead:
+		s.withBucket(w, r, name, func(*bucket) { w.WriteHeader(http.StatusOK) })
+	case r.Method == http.MethodDelete:
+		s.deleteBucket(w, r, name)
+	case r.Method == http.MethodGet && has("location"):
+		s.withBucket(w, r, name, func(*bucket) {
+			writeXML(w, http.StatusOK, locationConstraint{Location: s.region})
+		})
+	case r.Method == http.MethodGet && has("uploads"):
+		s.listUploads(w, r, name)
+	case r.Method == http.MethodGet && has("versions"):
+		s.listVersions(w, r, name)
+	case r.Method == http.MethodGet:
+		s.listObjects(w, r, name)
+	case r.Method == http.MethodPost && has("delete"):
+		s.deleteObjects(w, r, name, body)
+	default:
+		writeError(w, r, errNotImplemented)
+	}
+}
+
+func (s *Server) serveObject(w http.ResponseWriter, r *http.Request, name string, key string, query map[string][]string, body []byte) {
+	_, uploads := query["uploads"]
+	uploadID := ""
+	if values := query["uploadId"]; len(values) > 0 {
+		uploadID = values[0]
+	}
+	switch {
+	case r.Method == http.MethodPost && uploads:
+		s.createUpload(w, r, name, key)
+	case r.Method == http.MethodPut && uploadID != "":
+		s.uploadPart(w, r, uploadID, body)
+	case r.Method == http.MethodPost && uploadID != "":
+		s.completeUpload(w, r, name, key, uploadID, body)
+	case r.Method == http.MethodDelete && uploadID != "":
+		s.abortUpload(w, r, uploadID)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.copyObject(w, r, name, key)
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, name, key, body)
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		s.getObject(w, r, name, key)
+	case r.Method == http.MethodDelete:
+		s.deleteObject(w, r, name, key)
+	default:
+		writeError(w, r, errNotImplemented)
+	}
+}
+
+// withBucket runs f with the bucket, locked.
+func (s *Server) withBucket(w http.ResponseWriter, r *http.Request, name string, f func(*bucket)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[name]
+	if !ok {
+		writeError(w, r, errNoSuchBucket)
+		return
+	}
+	f(b)
+}
+
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+func (s *Server) createBucket(w http.ResponseWriter, r *http.Request, name string) {
+	if !bucketNamePattern.MatchString(name) || strings.Contains(name, "..") {
+		writeError(w, r, errInvalidBucketName)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[name]; ok {
+		writeError(w, r, errBucketExists)
+		return
+	}
+	s.buckets[name] = &bucket{
+		created:        s.now().UTC(),
+		objects:        make(map[string]*object),
+		configurations: make(map[string][]byte),
+	}
+	w.Header().Set("Location", "/"+name)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) deleteBucket(w http.ResponseWriter, r *http.Request, name string) {
+	s.withBucket(w, r, name, func(b *bucket) {
+		if len(b.objects) > 0 {
+			writeError(w, r, errBucketNotEmpty)
+			return
+		}
+		delete(s.buckets, name)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (s *Server) serveConfiguration(w http.ResponseWriter, r *http.Request, name string, configuration string, body []byte) {
+	s.withBucket(w, r, name, func(b *bucket) {
+		switch r.Method {
+		case http.MethodPut:
+			b.configurations[configuration] = body
+			w.WriteHeader(http.StatusOK)
+		case http.MethodDelete:
+			delete(b.configurations, configuration)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			content, ok := b.configurations[configuration]
+			if !ok {
+				if empty, always := emptyConfigurations[configuration]; always {
+					content = []byte(empty)
+				} else {
+					writeError(w, r, notFound(bucketConfigurations[configuration]))
+					return
+				}
+			}
+			if configuration == "policy" {
+				w.Header().Set("Content-Type", "application/json")
+			} else {
+				w.Header().Set("Content-Type", "application/xml")
+			}
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(content)
+		default:
+			writeError(w, r, errNotImplemented)
+		}
+	})
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, name string, key string, body []byte) {
+	if err := checkContentMD5(r, body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.withBucket(w, r, name, func(b *bucket) {
+		stored := newObject(body, r.Header, s.now())
+		b.objects[key] = stored
+		w.Header().Set("ETag", quote(stored.etag))
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, name string, key string) {
+	source := strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/")
+	if unescaped, err := unescapePath(source); err == nil {
+		source = unescaped
+	}
+	sourceBucket, sourceKey, _ := strings.Cut(source, "/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	from, ok := s.buckets[sourceBucket]
+	if !ok {
+		writeError(w, r, errNoSuchBucket)
+		return
+	}
+	original, ok := from.objects[sourceKey]
+	if !ok {
+		writeError(w, r, errNoSuchKey)
+		return
+	}
+	to, ok := s.buckets[name]
+	if !ok {
+		writeError(w, r, errNoSuchBucket)
+		return
+	}
+	copied := &object{
+		data:         original.data,
+		etag:         original.etag,
+		contentType:  original.contentType,
+		metadata:     original.metadata,
+		lastModified: s.now().UTC(),
+	}
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		replaced := newObject(original.data, r.Header, s.now())
+		copied.contentType, copied.metadata = replaced.contentType, replaced.metadata
+	}
+	to.objects[key] = copied
+	writeXML(w, http.StatusOK, copyObjectResult{ETag: quote(copied.etag), LastModified: copied.lastModified.Format(time.RFC3339)})
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, name string, key string) {
+	s.withBucket(w, r, name, func(b *bucket) {
+		stored, ok := b.objects[key]
+		if !ok {
+			writeError(w, r, errNoSuchKey)
+			return
+		}
+		if match := r.Header.Get("If-None-Match"); match != "" && strings.Trim(match, `"`) == stored.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		header := w.Header()
+		for k, v := range stored.metadata {
+			header[k] = v
+		}
+		header.Set("ETag", quote(stored.etag))
+		header.Set("Last-Modified", stored.lastModified.Format(http.TimeFormat))
+		header.Set("Content-Type", stored.contentType)
+		header.Set("Accept-Ranges", "bytes")
+		data := stored.data
+		status := http.StatusOK
+		if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+			start, end, err := parseRange(rangeHeader, int64(len(data)))
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			data = data[start : end+1]
+			status = http.StatusPartialContent
+		}
+		header.Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	})
+}
+
+func (s *Server) deleteObject(w http.ResponseWriter, r *http.Request, name string, key string) {
+	s.withBucket(w, r, name, func(b *bucket) {
+		delete(b.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (s *Server) deleteObjects(w http.ResponseWriter, r *http.Request, name string, body []byte) {
+	var request deleteRequest
+	if err := xml.Unmarshal(body, &request); err != nil {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+	s.withBucket(w, r, name, func(b *bucket) {
+		result := deleteResult{}
+		for _, item := range request.Objects {
+			delete(b.objects, item.Key)
+			if !request.Quiet {
+				result.Deleted = append(result.Deleted, deletedObject{Key: item.Key})
+			}
+		}
+		writeXML(w, http.StatusOK, result)
+	})
+}
+
+func (s *Server) listBuckets(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := listAllMyBucketsResult{Owner: owner{ID: s.accessKey, DisplayName: s.accessKey}}
+	for name, b := range s.buckets {
+		result.Buckets = append(result.Buckets, bucketEntry{Name: name, CreationDate: b.created.Format(time.RFC3339)})
+	}
+	sort.Slice(result.Buckets, func(i, j int) bool { return result.Buckets[i].Name < result.Buckets[j].Name })
+	writeXML(w, http.StatusOK, result)
+}
+
+// listObjects serves ListObjects V1 and V2. Continuation tokens are the last
+// key returned.
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, name string) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	maxKeys := 1000
+	if value := query.Get("max-keys"); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 && parsed < maxKeys {
+			maxKeys = parsed
+		}
+	}
+	v2 := query.Get("list-type") == "2"
+	after := query.Get("marker")
+	if v2 {
+		after = query.Get("start-after")
+		if token := query.Get("continuation-token"); token != "" {
+			after = token
+		}
+	}
+	s.withBucket(w, r, name, func(b *bucket) {
+		var keys []string
+		for key := range b.objects {
+			if strings.HasPrefix(key, prefix) && key > after {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		result := listBucketResult{Name: name, Prefix: prefix, Delimiter: delimiter, MaxKeys: maxKeys}
+		seen := make(map[string]bool)
+		last := ""
+		count := 0
+		for _, key := range keys {
+			if count == maxKeys {
+				result.IsTruncated = true
+				break
+			}
+			if delimiter != "" {
+				if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+					common := key[:len(prefix)+i+len(delimiter)]
+					if !seen[common] {
+						seen[common] = true
+						result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: common})
+						count++
+					}
+					last = key
+					continue
+				}
+			}
+			stored := b.objects[key]
+			result.Contents = append(result.Contents, objectEntry{
+				Key:          key,
+				LastModified: stored.lastModified.Format(time.RFC3339),
+				ETag:         quote(stored.etag),
+				Size:         int64(len(stored.data)),
+				StorageClass: "STANDARD",
+			})
+			last = key
+			count++
+		}
+		if v2 {
+			result.KeyCount = count
+			result.ContinuationToken = query.Get("continuation-token")
+			result.StartAfter = query.Get("start-after")
+			if result.IsTruncated {
+				result.NextContinuationToken = last
+			}
+		} else {
+			result.Marker = query.Get("marker")
+			if result.IsTruncated {
+				result.NextMarker = last
+			}
+		}
+		writeXML(w, http.StatusOK, result)
+	})
+}
+
+// listVersions serves ListObjectVersions: the fake has no versioning, every
+// object is its single null version.
+func (s *Server) listVersions(w http.ResponseWriter, r *http.Request, name string) {
+	query := r.URL.Query()
+	if query.Get("delimiter") != "" {
+		writeError(w, r, errNotImplemented)
+		return
+	}
+	prefix := query.Get("prefix")
+	after := query.Get("key-marker")
+	maxKeys := 1000
+	if value := query.Get("max-keys"); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 && parsed < maxKeys {
+			maxKeys = parsed
+		}
+	}
+	s.withBucket(w, r, name, func(b *bucket) {
+		var keys []string
+		for key := range b.objects {
+			if strings.HasPrefix(key, prefix) && key > after {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		result := listVersionsResult{Name: name, Prefix: prefix, KeyMarker: after, MaxKeys: maxKeys}
+		if len(keys) > maxKeys {
+			keys = keys[:maxKeys]
+			result.IsTruncated = true
+			result.NextKeyMarker = keys[len(keys)-1]
+			result.NextVersionIDMarker = nullVersion
+		}
+		for _, key := range keys {
+			stored := b.objects[key]
+			result.Versions = append(result.Versions, versionEntry{
+				Key:          key,
+				VersionID:    nullVersion,
+				IsLatest:     true,
+				LastModified: stored.lastModified.Format(time.RFC3339),
+				ETag:         quote(stored.etag),
+				Size:         int64(len(stored.data)),
+				StorageClass: "STANDARD",
+			})
+		}
+		writeXML(w, http.StatusOK, result)
+	})
+}
+
+func (s *Server) createUpload(w http.ResponseWriter, r *http.Request, name string, key string) {
+	s.withBucket(w, r, name, func(*bucket) {
+		id := requestID()
+		template := newObject(nil, r.Header, s.now())
+		s.uploads[id] = &upload{
+			bucket:      name,
+			key:         key,
+			contentType: template.contentType,
+			metadata:    template.metadata,
+			initiated:   s.now().UTC(),
+			parts:       make(map[int]*object),
+		}
+		writeXML(w, http.StatusOK, initiateMultipartUploadResult{Bucket: name, Key: key, UploadID: id})
+	})
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, uploadID string, body []byte) {
+	number, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || number < 1 || number > 10000 {
+		writeError(w, r, errInvalidPart)
+		return
+	}
+	if err = checkContentMD5(r, body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[uploadID]
+	if !ok {
+		writeError(w, r, errNoSuchUpload)
+		return
+	}
+	part := newObject(body, nil, s.now())
+	u.parts[number] = part
+	w.Header().Set("ETag", quote(part.etag))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) completeUpload(w http.ResponseWriter, r *http.Request, name string, key string, uploadID string, body []byte) {
+	var request completeMultipartUpload
+	if err := xml.Unmarshal(body, &request); err != nil || len(request.Parts) == 0 {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[uploadID]
+	if !ok || u.bucket != name || u.key != key {
+		writeError(w, r, errNoSuchUpload)
+		return
+	}
+	b, ok := s.buckets[name]
+	if !ok {
+		writeError(w, r, errNoSuchBucket)
+		return
+	}
+	var data bytes.Buffer
+	var sums []byte
+	previous := 0
+	for _, requested := range request.Parts {
+		if requested.PartNumber <= previous {
+			writeError(w, r, errInvalidPartOrder)
+			return
+		}
+		previous = requested.PartNumber
+		part, ok := u.parts[requested.PartNumber]
+		if !ok || strings.Trim(requested.ETag, `"`) != part.etag {
+			writeError(w, r, errInvalidPart)
+			return
+		}
+		sum, _ := hex.DecodeString(part.etag)
+		sums = append(sums, sum...)
+		data.Write(part.data)
+	}
+	total := md5.Sum(sums)
+	b.objects[key] = &object{
+		data:         data.Bytes(),
+		etag:         fmt.Sprintf("%s-%d", hex.EncodeToString(total[:]), len(request.Parts)),
+		contentType:  u.contentType,
+		metadata:     u.metadata,
+		lastModified: s.now().UTC(),
+	}
+	delete(s.uploads, uploadID)
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{
+		Location: "/" + name + "/" + key,
+		Bucket:   name,
+		Key:      key,
+		ETag:     quote(b.objects[key].etag),
+	})
+}
+
+func (s *Server) abortUpload(w http.ResponseWriter, r *http.Request, uploadID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.uploads[uploadID]; !ok {
+		writeError(w, r, errNoSuchUpload)
+		return
+	}
+	delete(s.uploads, uploadID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listUploads(w http.ResponseWriter, r *http.Request, name string) {
+	prefix := r.URL.Query().Get("prefix")
+	s.withBucket(w, r, name, func(*bucket) {
+		result := listMultipartUploadsResult{Bucket: name, Prefix: prefix, MaxUploads: 1000}
+		for id, u := range s.uploads {
+			if u.bucket == name && strings.HasPrefix(u.key, prefix) {
+				result.Uploads = append(result.Uploads, uploadEntry{Key: u.key, UploadID: id, Initiated: u.initiated.Format(time.RFC3339)})
+			}
+		}
+		sort.Slice(result.Uploads, func(i, j int) bool { return result.Uploads[i].Key < result.Uploads[j].Key })
+		writeXML(w, http.StatusOK, result)
+	})
+}
+
+// newObject keeps the content type and the user metadata of the headers.
+func newObject(data []byte, header http.Header, now time.Time) *object {
+	sum := md5.Sum(data)
+	stored := &object{
+		data:         data,
+		etag:         hex.EncodeToString(sum[:]),
+		contentType:  "binary/octet-stream",
+		metadata:     make(http.Header),
+		lastModified: now.UTC(),
+	}
+	for key, values := range header {
+		switch {
+		case strings.EqualFold(key, "Content-Type"):
+			stored.contentType = values[0]
+		case strings.HasPrefix(strings.ToLower(key), "x-amz-meta-"):
+			stored.metadata[key] = values
+		}
+	}
+	return stored
+}
+
+// readBody reads the payload, decoding aws-chunked uploads, and checks it
+// against the signed payload hash. Chunk signatures are not verified.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	defer r.Body.Close()
+	payload := r.Header.Get("X-Amz-Content-Sha256")
+	if strings.HasPrefix(payload, "STREAMING-") {
+		return decodeChunked(bufio.NewReader(r.Body))
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, errIncompleteBody
+	}
+	if len(payload) == 64 {
+		sum := sha256.Sum256(body)
+		if hex.EncodeToString(sum[:]) != payload {
+			return nil, errBadDigest
+		}
+	}
+	return body, nil
+}
+
+// decodeChunked reads `<size>[;chunk-signature=...]\r\n<data>\r\n` chunks up
+// to the empty one. Trailing headers after it are ignored.
+func decodeChunked(reader *bufio.Reader) ([]byte, error) {
+	var body bytes.Buffer
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, errIncompleteBody
+		}
+		sizeField, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeField, 16, 64)
+		if err != nil || size < 0 {
+			return nil, errIncompleteBody
+		}
+		if size == 0 {
+			_, _ = io.Copy(io.Discard, reader)
+			return body.Bytes(), nil
+		}
+		if _, err = io.CopyN(&body, reader, size); err != nil {
+			return nil, errIncompleteBody
+		}
+		if crlf, err := reader.ReadString('\n'); err != nil || strings.TrimSpace(crlf) != "" {
+			return nil, errIncompleteBody
+		}
+	}
+}
+
+func checkContentMD5(r *http.Request, body []byte) error {
+	expected := r.Header.Get("Content-Md5")
+	if expected == "" {
+		return nil
+	}
+	sum := md5.Sum(body)
+	if encodeBase64(sum[:]) != expected {
+		return &s3Error{"BadDigest", http.StatusBadRequest, "The Content-MD5 you specified did not match what we received."}
+	}
+	return nil
+}
+
+// parseRange reads a single `bytes=start-end` range.
+func parseRange(header string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, errInvalidRange
+	}
+	first, last, _ := strings.Cut(spec, "-")
+	var start, end int64
+	var err error
+	switch {
+	case first == "":
+		suffix, parseErr := strconv.ParseInt(last, 10, 64)
+		if parseErr != nil || suffix <= 0 {
+			return 0, 0, errInvalidRange
+		}
+		start, end = max(size-suffix, 0), size-1
+	default:
+		start, err = strconv.ParseInt(first, 10, 64)
+		if err != nil {
+			return 0, 0, errInvalidRange
+		}
+		end = size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil {
+				return 0, 0, errInvalidRange
+			}
+			end = min(end, size-1)
+		}
+	}
+	if start < 0 || start > end || start >= size {
+		return 0, 0, errInvalidRange
+	}
+	return start, end, nil
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func requestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+func quote(etag string) string {
+	return `"` + etag + `"`
+}
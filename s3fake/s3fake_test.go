@@ -0,0 +1,351 @@
+package s3fake
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	testAccessKey = "codefly"
+	testSecretKey = "codefly-secret-key"
+	testRegion    = "us-east-1"
+)
+
+func testClient(t *testing.T, accessKey string, secretKey string) *minio.Core {
+	t.Helper()
+	server := httptest.NewServer(New(testAccessKey, testSecretKey, testRegion))
+	t.Cleanup(server.Close)
+	client, err := minio.NewCore(strings.TrimPrefix(server.URL, "http://"), &minio.Options{
+		Creds:        credentials.NewStaticV4(accessKey, secretKey, ""),
+		Region:       testRegion,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestBucketsAndObjects(t *testing.T) {
+	ctx := context.Background()
+	client := testClient(t, testAccessKey, testSecretKey)
+
+	if err := client.MakeBucket(ctx, "uploads", minio.MakeBucketOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.MakeBucket(ctx, "uploads", minio.MakeBucketOptions{}); minio.ToErrorResponse(err).Code != "BucketAlreadyOwnedByYou" {
+		t.Errorf("creating an existing bucket: %v", err)
+	}
+	exists, err := client.BucketExists(ctx, "uploads")
+	if err != nil || !exists {
+		t.Fatalf("uploads does not exist: %v", err)
+	}
+
+	for _, key := range []string{"a/1.txt", "a/2.txt", "b/3.txt", "root.txt"} {
+		content := "content of " + key
+		_, err = client.Client.PutObject(ctx, "uploads", key, strings.NewReader(content), int64(len(content)),
+			minio.PutObjectOptions{ContentType: "text/plain", UserMetadata: map[string]string{"origin": "test"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	object, err := client.Client.GetObject(ctx, "uploads", "a/1.txt", minio.GetObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "content of a/1.txt" {
+		t.Errorf("content = %q", content)
+	}
+	info, err := client.Client.StatObject(ctx, "uploads", "a/1.txt", minio.StatObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ContentType != "text/plain" || info.UserMetadata["Origin"] != "test" {
+		t.Errorf("stat = %s %v", info.ContentType, info.UserMetadata)
+	}
+
+	ranged := minio.GetObjectOptions{}
+	if err = ranged.SetRange(3, 6); err != nil {
+		t.Fatal(err)
+	}
+	reader, _, _, err := client.GetObject(ctx, "uploads", "a/1.txt", ranged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, _ := io.ReadAll(reader)
+	if string(part) != "tent" {
+		t.Errorf("range = %q", part)
+	}
+
+	var top []string
+	for item := range client.Client.ListObjects(ctx, "uploads", minio.ListObjectsOptions{}) {
+		if item.Err != nil {
+			t.Fatal(item.Err)
+		}
+		top = append(top, item.Key)
+	}
+	sort.Strings(top)
+	if strings.Join(top, ",") != "a/,b/,root.txt" {
+		t.Errorf("delimited listing = %v", top)
+	}
+	var all []string
+	for item := range client.Client.ListObjects(ctx, "uploads", minio.ListObjectsOptions{Recursive: true, MaxKeys: 1}) {
+		if item.Err != nil {
+			t.Fatal(item.Err)
+		}
+		all = append(all, item.Key)
+	}
+	if len(all) != 4 {
+		t.Errorf("paginated listing = %v", all)
+	}
+
+	_, err = client.Client.CopyObject(ctx, minio.CopyDestOptions{Bucket: "uploads", Object: "copy.txt"},
+		minio.CopySrcOptions{Bucket: "uploads", Object: "root.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Client.RemoveBucket(ctx, "uploads"); minio.ToErrorResponse(err).Code != "BucketNotEmpty" {
+		t.Errorf("removing a bucket with objects: %v", err)
+	}
+	for removal := range client.Client.RemoveObjects(ctx, "uploads", client.Client.ListObjects(ctx, "uploads", minio.ListObjectsOptions{Recursive: true}), minio.RemoveObjectsOptions{}) {
+		t.Errorf("remove %s: %v", removal.ObjectName, removal.Err)
+	}
+	if _, err = client.Client.StatObject(ctx, "uploads", "a/1.txt", minio.StatObjectOptions{}); minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		t.Errorf("stat of a removed object: %v", err)
+	}
+	if err = client.Client.RemoveBucket(ctx, "uploads"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	client := testClient(t, testAccessKey, testSecretKey)
+	if err := client.MakeBucket(ctx, "parts", minio.MakeBucketOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	id, err := client.NewMultipartUpload(ctx, "parts", "large.bin", minio.PutObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := bytes.Repeat([]byte("a"), 5<<20)
+	second := []byte("tail")
+	var parts []minio.CompletePart
+	for number, data := range [][]byte{first, second} {
+		uploaded, err := client.PutObjectPart(ctx, "parts", "large.bin", id, number+1, bytes.NewReader(data), int64(len(data)), minio.PutObjectPartOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, minio.CompletePart{PartNumber: uploaded.PartNumber, ETag: uploaded.ETag})
+	}
+	result, err := client.CompleteMultipartUpload(ctx, "parts", "large.bin", id, parts, minio.PutObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(result.ETag, "-2") {
+		t.Errorf("multipart etag = %s", result.ETag)
+	}
+	info, err := client.Client.StatObject(ctx, "parts", "large.bin", minio.StatObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(first)+len(second)) {
+		t.Errorf("size = %d", info.Size)
+	}
+
+	aborted, err := client.NewMultipartUpload(ctx, "parts", "aborted.bin", minio.PutObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.AbortMultipartUpload(ctx, "parts", "aborted.bin", aborted); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.AbortMultipartUpload(ctx, "parts", "aborted.bin", aborted); minio.ToErrorResponse(err).Code != "NoSuchUpload" {
+		t.Errorf("aborting twice: %v", err)
+	}
+}
+
+func TestPresignedURLs(t *testing.T) {
+	ctx := context.Background()
+	client := testClient(t, testAccessKey, testSecretKey)
+	if err := client.MakeBucket(ctx, "shared", minio.MakeBucketOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	put, err := client.Client.PresignedPutObject(ctx, "shared", "report.csv", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, _ := http.NewRequest(http.MethodPut, put.String(), strings.NewReader("a,b\n"))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("presigned put = %s", response.Status)
+	}
+
+	get, err := client.Client.PresignedGetObject(ctx, "shared", "report.csv", time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err = http.Get(get.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || string(content) != "a,b\n" {
+		t.Errorf("presigned get = %s %q", response.Status, content)
+	}
+
+	tampered := strings.Replace(get.String(), "report.csv", "other.csv", 1)
+	response, err = http.Get(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("tampered presigned get = %s", response.Status)
+	}
+}
+
+func TestRejectsWrongCredentials(t *testing.T) {
+	ctx := context.Background()
+	for name, client := range map[string]*minio.Core{
+		"InvalidAccessKeyId":    testClient(t, "someone", testSecretKey),
+		"SignatureDoesNotMatch": testClient(t, testAccessKey, "wrong-secret-key"),
+	} {
+		_, err := client.ListBuckets(ctx)
+		if code := minio.ToErrorResponse(err).Code; code != name {
+			t.Errorf("error code = %q, want %s", code, name)
+		}
+	}
+}
+
+func TestAcceptsUsers(t *testing.T) {
+	ctx := context.Background()
+	fake := New(testAccessKey, testSecretKey, testRegion)
+	fake.AddUser("backend-api", "backend-api-secret-key")
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	for secretKey, code := range map[string]string{"backend-api-secret-key": "", testSecretKey: "SignatureDoesNotMatch"} {
+		client, err := minio.New(strings.TrimPrefix(server.URL, "http://"), &minio.Options{
+			Creds:  credentials.NewStaticV4("backend-api", secretKey, ""),
+			Region: testRegion,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.ListBuckets(ctx)
+		if got := minio.ToErrorResponse(err).Code; got != code {
+			t.Errorf("error code with %s = %q, want %q", secretKey, got, code)
+		}
+	}
+}
+
+func TestBucketConfigurations(t *testing.T) {
+	ctx := context.Background()
+	client := testClient(t, testAccessKey, testSecretKey)
+	if err := client.MakeBucket(ctx, "configured", minio.MakeBucketOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := client.Client.GetBucketPolicy(ctx, "configured")
+	if err != nil || policy != "" {
+		t.Errorf("default policy = %q, %v", policy, err)
+	}
+	document := `{"Version":"2012-10-17","Statement":[]}`
+	if err = client.Client.SetBucketPolicy(ctx, "configured", document); err != nil {
+		t.Fatal(err)
+	}
+	if policy, err = client.Client.GetBucketPolicy(ctx, "configured"); err != nil || policy != document {
+		t.Errorf("policy = %q, %v", policy, err)
+	}
+	versioning, err := client.Client.GetBucketVersioning(ctx, "configured")
+	if err != nil || versioning.Enabled() {
+		t.Errorf("default versioning = %+v, %v", versioning, err)
+	}
+}
+
+func TestRestartKeepsData(t *testing.T) {
+	ctx := context.Background()
+	server := New(testAccessKey, testSecretKey, testRegion)
+	address, err := server.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := minio.New(address, &minio.Options{Creds: credentials.NewStaticV4(testAccessKey, testSecretKey, ""), Region: testRegion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.MakeBucket(ctx, "kept", minio.MakeBucketOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = server.Close(); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+	if _, err = server.Start(address); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if exists, err := client.BucketExists(ctx, "kept"); err != nil || !exists {
+		t.Errorf("bucket lost on restart: %v", err)
+	}
+}
+
+func TestListVersionsAndEmptyTheBucket(t *testing.T) {
+	ctx := context.Background()
+	client := testClient(t, testAccessKey, testSecretKey)
+	if err := client.MakeBucket(ctx, "scratch", minio.MakeBucketOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		key := strings.Repeat("k", i+1)
+		if _, err := client.Client.PutObject(ctx, "scratch", key, strings.NewReader(key), int64(len(key)), minio.PutObjectOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	objects := client.Client.ListObjects(ctx, "scratch", minio.ListObjectsOptions{WithVersions: true, Recursive: true, MaxKeys: 2})
+	var versions []string
+	for object := range objects {
+		if object.Err != nil {
+			t.Fatal(object.Err)
+		}
+		versions = append(versions, object.Key+"@"+object.VersionID)
+	}
+	if got := strings.Join(versions, ","); got != "k@null,kk@null,kkk@null,kkkk@null,kkkkk@null" {
+		t.Errorf("versions = %s", got)
+	}
+
+	remove := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(remove)
+		for object := range client.Client.ListObjects(ctx, "scratch", minio.ListObjectsOptions{WithVersions: true, Recursive: true}) {
+			remove <- object
+		}
+	}()
+	for result := range client.Client.RemoveObjects(ctx, "scratch", remove, minio.RemoveObjectsOptions{}) {
+		t.Errorf("cannot remove %s: %v", result.ObjectName, result.Err)
+	}
+	if err := client.RemoveBucket(ctx, "scratch"); err != nil {
+		t.Errorf("the bucket is not empty: %v", err)
+	}
+}
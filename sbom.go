@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// SBOM formats the container SBOM may come in. The agent components are
+// merged in the same format.
+const (
+	CycloneDXFormat = "cyclonedx"
+	SPDXFormat      = "spdx"
+)
+
+// agentModule is one Go module linked into the agent binary: the agent itself
+// or a dependency. The agent handles the credentials of the service, so it is
+// part of the supply chain of the workspace.
+type agentModule struct {
+	Path    string
+	Version string
+	// Sum is the go.sum hash of the module, empty for the main module.
+	Sum string
+}
+
+func (m *agentModule) purl() string {
+	return fmt.Sprintf("pkg:golang/%s@%s", m.Path, m.Version)
+}
+
+// agentModules lists the agent first, then the modules linked into it. A
+// replaced module is reported as the module that was actually built.
+func agentModules(info *debug.BuildInfo) []*agentModule {
+	modules := []*agentModule{{Path: info.Main.Path, Version: agent.Version}}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		modules = append(modules, &agentModule{Path: dep.Path, Version: dep.Version, Sum: dep.Sum})
+	}
+	return modules
+}
+
+// sbomFormat tells the format of a JSON SBOM document.
+func sbomFormat(document map[string]any) (string, error) {
+	if format, _ := document["bomFormat"].(string); format == "CycloneDX" {
+		return CycloneDXFormat, nil
+	}
+	if version, _ := document["spdxVersion"].(string); strings.HasPrefix(version, "SPDX-") {
+		return SPDXFormat, nil
+	}
+	return "", fmt.Errorf("container SBOM is neither CycloneDX nor SPDX JSON")
+}
+
+// mergeSBOM adds the agent modules to the container SBOM, keeping everything
+// the scanner wrote. Without a container SBOM, the agent modules make a
+// CycloneDX document of their own.
+func mergeSBOM(content []byte, modules []*agentModule) ([]byte, string, error) {
+	document := map[string]any{
+		"bomFormat":   "CycloneDX",
+		"specVersion": "1.5",
+		"version":     1,
+		"metadata":    map[string]any{"timestamp": time.Now().UTC().Format(time.RFC3339)},
+	}
+	if len(content) > 0 {
+		document = make(map[string]any)
+		if err := json.Unmarshal(content, &document); err != nil {
+			return nil, "", fmt.Errorf("cannot parse container SBOM: %w", err)
+		}
+	}
+	format, err := sbomFormat(document)
+	if err != nil {
+		return nil, "", err
+	}
+	switch format {
+	case CycloneDXFormat:
+		mergeCycloneDX(document, modules)
+	case SPDXFormat:
+		mergeSPDX(document, modules)
+	}
+	merged, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, "", err
+	}
+	return merged, format, nil
+}
+
+// mergeCycloneDX adds the agent as an application depending on its modules.
+func mergeCycloneDX(document map[string]any, modules []*agentModule) {
+	components, _ := document["components"].([]any)
+	dependencies, _ := document["dependencies"].([]any)
+	var dependsOn []any
+	for i, module := range modules {
+		component := map[string]any{
+			"type":    "library",
+			"bom-ref": module.purl(),
+			"name":    module.Path,
+			"version": module.Version,
+			"purl":    module.purl(),
+		}
+		if i == 0 {
+			component["type"] = "application"
+			component["description"] = "codefly agent managing the MinIO service and its credentials"
+		} else {
+			dependsOn = append(dependsOn, module.purl())
+		}
+		if module.Sum != "" {
+			component["properties"] = []any{map[string]any{"name": "golang:sum", "value": module.Sum}}
+		}
+		components = append(components, component)
+	}
+	dependencies = append(dependencies, map[string]any{"ref": modules[0].purl(), "dependsOn": dependsOn})
+	document["components"] = components
+	document["dependencies"] = dependencies
+}
+
+// mergeSPDX adds the agent as a package described by the document and
+// depending on its modules.
+func mergeSPDX(document map[string]any, modules []*agentModule) {
+	packages, _ := document["packages"].([]any)
+	relationships, _ := document["relationships"].([]any)
+	id := func(i int) string {
+		return fmt.Sprintf("SPDXRef-codefly-agent-%d", i)
+	}
+	for i, module := range modules {
+		pkg := map[string]any{
+			"SPDXID":           id(i),
+			"name":             module.Path,
+			"versionInfo":      module.Version,
+			"downloadLocation": "NOASSERTION",
+			"filesAnalyzed":    false,
+			"licenseConcluded": "NOASSERTION",
+			"licenseDeclared":  "NOASSERTION",
+			"copyrightText":    "NOASSERTION",
+			"externalRefs": []any{map[string]any{
+				"referenceCategory": "PACKAGE-MANAGER",
+				"referenceType":     "purl",
+				"referenceLocator":  module.purl(),
+			}},
+		}
+		packages = append(packages, pkg)
+		relationship := map[string]any{"spdxElementId": id(0), "relationshipType": "DEPENDS_ON", "relatedSpdxElement": id(i)}
+		if i == 0 {
+			relationship = map[string]any{"spdxElementId": "SPDXRef-DOCUMENT", "relationshipType": "DESCRIBES", "relatedSpdxElement": id(0)}
+		}
+		relationships = append(relationships, relationship)
+	}
+	document["packages"] = packages
+	document["relationships"] = relationships
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"runtime/debug"
+	"testing"
+)
+
+var testBuildInfo = &debug.BuildInfo{
+	Main: debug.Module{Path: "github.com/codefly-dev/service-minio"},
+	Deps: []*debug.Module{
+		{Path: "github.com/minio/minio-go/v7", Version: "v7.3.0", Sum: "h1:minio"},
+		{Path: "github.com/lib/pq", Version: "v1.12.3", Sum: "h1:pq"},
+		{Path: "github.com/codefly-dev/core", Version: "v0.3.4", Replace: &debug.Module{Path: "github.com/codefly-dev/core", Version: "v0.3.5"}},
+	},
+}
+
+func TestAgentModules(t *testing.T) {
+	modules := agentModules(testBuildInfo)
+	if len(modules) != 4 || modules[0].Version != agent.Version {
+		t.Fatalf("modules = %v", modules)
+	}
+	if modules[3].Version != "v0.3.5" {
+		t.Errorf("replaced module reported as %s", modules[3].Version)
+	}
+}
+
+func TestMergeCycloneDX(t *testing.T) {
+	container := `{"bomFormat":"CycloneDX","specVersion":"1.5","components":[{"type":"operating-system","name":"ubi"}]}`
+	merged, format, err := mergeSBOM([]byte(container), agentModules(testBuildInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != CycloneDXFormat {
+		t.Errorf("format = %s", format)
+	}
+	var document struct {
+		Components   []map[string]any `json:"components"`
+		Dependencies []struct {
+			Ref       string   `json:"ref"`
+			DependsOn []string `json:"dependsOn"`
+		} `json:"dependencies"`
+	}
+	if err = json.Unmarshal(merged, &document); err != nil {
+		t.Fatal(err)
+	}
+	if len(document.Components) != 5 || document.Components[0]["name"] != "ubi" {
+		t.Errorf("components = %v", document.Components)
+	}
+	if len(document.Dependencies) != 1 || len(document.Dependencies[0].DependsOn) != 3 {
+		t.Errorf("dependencies = %v", document.Dependencies)
+	}
+}
+
+func TestMergeSPDX(t *testing.T) {
+	container := `{"spdxVersion":"SPDX-2.3","SPDXID":"SPDXRef-DOCUMENT","packages":[{"SPDXID":"SPDXRef-minio","name":"minio"}]}`
+	merged, format, err := mergeSBOM([]byte(container), agentModules(testBuildInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != SPDXFormat {
+		t.Errorf("format = %s", format)
+	}
+	var document struct {
+		Packages      []map[string]any `json:"packages"`
+		Relationships []map[string]any `json:"relationships"`
+	}
+	if err = json.Unmarshal(merged, &document); err != nil {
+		t.Fatal(err)
+	}
+	if len(document.Packages) != 5 || len(document.Relationships) != 4 {
+		t.Errorf("packages = %d, relationships = %d", len(document.Packages), len(document.Relationships))
+	}
+	if document.Relationships[0]["relationshipType"] != "DESCRIBES" {
+		t.Errorf("relationships = %v", document.Relationships)
+	}
+}
+
+func TestMergeSBOMWithoutContainerDocument(t *testing.T) {
+	merged, format, err := mergeSBOM(nil, agentModules(testBuildInfo))
+	if err != nil || format != CycloneDXFormat || len(merged) == 0 {
+		t.Fatalf("format = %s, err = %v", format, err)
+	}
+	if _, _, err = mergeSBOM([]byte(`{"unknown":true}`), nil); err == nil {
+		t.Error("expected an unknown format error")
+	}
+}
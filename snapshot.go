@@ -0,0 +1,356 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+// snapshotTimeFormat is the timestamp in the file name of a snapshot, to the
+// nanosecond so that snapshots taken in the same second keep apart.
+const snapshotTimeFormat = "20060102T150405.000000000Z"
+
+var snapshotName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+func validateSnapshotName(name string) error {
+	if !snapshotName.MatchString(name) {
+		return fmt.Errorf("snapshot name %q must be letters, digits, '.', '_' or '-'", name)
+	}
+	return nil
+}
+
+// snapshot is an archive of the data directory.
+type snapshot struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	File string    `json:"file"`
+}
+
+func (s snapshot) String() string {
+	return s.Name + "@" + s.Time.Format(time.RFC3339Nano)
+}
+
+// snapshotDirectory holds the snapshots of the service in the workspace.
+func (s *Service) snapshotDirectory() string {
+	return filepath.Join(s.Identity.WorkspacePath, ".codefly", "snapshots", s.Identity.Module, s.Identity.Name)
+}
+
+func snapshotFile(dir string, name string, at time.Time) string {
+	return filepath.Join(dir, name+"-"+at.UTC().Format(snapshotTimeFormat)+".tar.gz")
+}
+
+// listSnapshots returns the snapshots of a directory, oldest first.
+func listSnapshots(dir string) ([]snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []snapshot
+	for _, entry := range entries {
+		base, ok := strings.CutSuffix(entry.Name(), ".tar.gz")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		i := strings.LastIndex(base, "-")
+		if i <= 0 {
+			continue
+		}
+		at, err := time.Parse(snapshotTimeFormat, base[i+1:])
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshot{Name: base[:i], Time: at, File: filepath.Join(dir, entry.Name())})
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.Before(snapshots[j].Time)
+	})
+	return snapshots, nil
+}
+
+// findSnapshot returns the latest snapshot of a name.
+func findSnapshot(dir string, name string) (*snapshot, error) {
+	snapshots, err := listSnapshots(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].Name == name {
+			return &snapshots[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no snapshot %q in %s", name, dir)
+}
+
+// archiveDirectory writes the files of a directory into a gzipped tar. It
+// never replaces an existing archive.
+func archiveDirectory(dir string, file string) (err error) {
+	if _, err = os.Stat(file); err == nil {
+		return fmt.Errorf("%s already exists", file)
+	}
+	if err = os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return err
+	}
+	partial := file + ".partial"
+	out, err := os.OpenFile(partial, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = out.Close()
+			_ = os.Remove(partial)
+		}
+	}()
+	compressed := gzip.NewWriter(out)
+	archive := tar.NewWriter(compressed)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil || name == "." {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if err = archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, err = io.Copy(archive, in)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err = archive.Close(); err != nil {
+		return err
+	}
+	if err = compressed.Close(); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(partial, file)
+}
+
+// extractArchive writes the files of a gzipped tar into a new directory.
+func extractArchive(file string, dir string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	compressed, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	archive := tar.NewReader(compressed)
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.FromSlash(header.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("%s: %q is outside of the data directory", file, header.Name)
+		}
+		target := filepath.Join(dir, name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err = extractFile(archive, target, header.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func extractFile(in io.Reader, target string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// replaceDirectory replaces a directory with the content of an archive. The
+// directory is only swapped once the archive is extracted.
+func replaceDirectory(dir string, file string) error {
+	restored := dir + ".restore"
+	previous := dir + ".previous"
+	for _, stale := range []string{restored, previous} {
+		if err := os.RemoveAll(stale); err != nil {
+			return err
+		}
+	}
+	if err := extractArchive(file, restored); err != nil {
+		_ = os.RemoveAll(restored)
+		return err
+	}
+	if err := os.Rename(dir, previous); err != nil && !os.IsNotExist(err) {
+		_ = os.RemoveAll(restored)
+		return err
+	}
+	if err := os.Rename(restored, dir); err != nil {
+		_ = os.Rename(previous, dir)
+		return err
+	}
+	return os.RemoveAll(previous)
+}
+
+// snapshotData checks that the runtime has a data directory to snapshot.
+func (s *Runtime) snapshotData() error {
+	if s.backend == FakeBackend {
+		return fmt.Errorf("the fake backend keeps data in memory: no snapshot")
+	}
+	if s.Settings.Ephemeral {
+		return fmt.Errorf("an ephemeral service has no data directory: no snapshot")
+	}
+	return nil
+}
+
+// takeSnapshot archives the data directory, with the server paused while it
+// runs.
+func (s *Runtime) takeSnapshot(ctx context.Context, name string) (*snapshot, error) {
+	if err := validateSnapshotName(name); err != nil {
+		return nil, err
+	}
+	if err := s.snapshotData(); err != nil {
+		return nil, err
+	}
+	if s.serverState == serverRunning {
+		if err := s.suspendServer(ctx, PauseStop); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := s.resumeServer(ctx); err != nil {
+				s.Wool.Warn("cannot resume minio after the snapshot", wool.ErrField(err))
+			}
+		}()
+	}
+	at := time.Now()
+	taken := &snapshot{Name: name, Time: at.UTC(), File: snapshotFile(s.snapshotDirectory(), name, at)}
+	if err := archiveDirectory(s.localDataPath(), taken.File); err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot snapshot the data")
+	}
+	s.Wool.Info("took a snapshot", wool.Field("name", name), wool.Field("file", taken.File))
+	return taken, nil
+}
+
+// restoreSnapshot replaces the data directory with the latest snapshot of a
+// name. The server is stopped, not paused: it must not keep the former data
+// in memory.
+func (s *Runtime) restoreSnapshot(ctx context.Context, name string) (*snapshot, error) {
+	if err := validateSnapshotName(name); err != nil {
+		return nil, err
+	}
+	if err := s.snapshotData(); err != nil {
+		return nil, err
+	}
+	found, err := findSnapshot(s.snapshotDirectory(), name)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.haltServer(ctx); err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot stop minio to restore")
+	}
+	if err = replaceDirectory(s.localDataPath(), found.File); err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot restore snapshot %s", found)
+	}
+	s.Wool.Info("restored a snapshot", wool.Field("snapshot", found.String()))
+	return found, nil
+}
+
+// restartAfterRestore runs the server again on the restored data, and
+// provisions what the snapshot predates, as Start does.
+func (s *Runtime) restartAfterRestore(ctx context.Context) error {
+	if err := s.resumeServer(ctx); err != nil {
+		return err
+	}
+	if err := s.WaitForReady(ctx); err != nil {
+		return err
+	}
+	if err := s.provisionBuckets(ctx); err != nil {
+		return err
+	}
+	return s.provisionConsumers(ctx)
+}
+
+// haltServer stops the server, paused or not, and leaves it stopped. The
+// container is removed: a paused one would only be thawed by Init.
+func (s *Runtime) haltServer(ctx context.Context) error {
+	if s.serverState == serverNotStarted {
+		return nil
+	}
+	var err error
+	if s.backend == NativeBackend {
+		err = s.native.Stop()
+	} else {
+		err = s.runnerEnvironment.Shutdown(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	s.serverState = serverStopped
+	return nil
+}
+
+// snapshotsDetail lists the snapshots for Information.
+func snapshotsDetail(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	snapshots, err := listSnapshots(dir)
+	if err != nil {
+		return ""
+	}
+	var names []string
+	for _, snapshot := range snapshots {
+		names = append(names, snapshot.String())
+	}
+	return strings.Join(names, ", ")
+}
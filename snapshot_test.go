@@ -0,0 +1,163 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestValidateSnapshotName(t *testing.T) {
+	for _, name := range []string{"good", "before-migration", "v1.2_a"} {
+		if err := validateSnapshotName(name); err != nil {
+			t.Errorf("%q: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "-x", "../data", "a/b", "with space"} {
+		if err := validateSnapshotName(name); err == nil {
+			t.Errorf("%q: expected an invalid name error", name)
+		}
+	}
+}
+
+func TestListSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	for _, file := range []string{
+		snapshotFile(dir, "good", at.Add(time.Hour)),
+		snapshotFile(dir, "good", at),
+		snapshotFile(dir, "before-reset", at.Add(time.Minute)),
+		filepath.Join(dir, "notes.txt"),
+		filepath.Join(dir, "good-yesterday.tar.gz"),
+	} {
+		writeFiles(t, dir, map[string]string{filepath.Base(file): ""})
+	}
+	snapshots, err := listSnapshots(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, snapshot := range snapshots {
+		names = append(names, snapshot.String())
+	}
+	expected := "good@2026-10-18T12:00:00Z, before-reset@2026-10-18T12:01:00Z, good@2026-10-18T13:00:00Z"
+	if got := strings.Join(names, ", "); got != expected {
+		t.Errorf("snapshots = %s", got)
+	}
+	if same, later := snapshotFile(dir, "good", at), snapshotFile(dir, "good", at.Add(time.Millisecond)); same == later {
+		t.Errorf("snapshots in the same second share %s", same)
+	}
+	latest, err := findSnapshot(dir, "good")
+	if err != nil || !latest.Time.Equal(at.Add(time.Hour)) {
+		t.Errorf("latest good = %v, %v", latest, err)
+	}
+	if _, err = findSnapshot(dir, "missing"); err == nil {
+		t.Error("expected a missing snapshot error")
+	}
+	if snapshots, err = listSnapshots(filepath.Join(dir, "none")); err != nil || snapshots != nil {
+		t.Errorf("no directory = %v, %v", snapshots, err)
+	}
+}
+
+func TestRestoreReplacesTheDataDirectory(t *testing.T) {
+	root := t.TempDir()
+	data := filepath.Join(root, "data")
+	writeFiles(t, data, map[string]string{
+		".minio.sys/format.json":       `{"version":"1"}`,
+		"images/cat.png/xl.meta":       "cat",
+		"images/empty/.keep":           "",
+		"documents/report.pdf/xl.meta": "report",
+	})
+	file := filepath.Join(root, "snapshots", "good.tar.gz")
+	if err := archiveDirectory(data, file); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("archive = %v, %v", info, err)
+	}
+	if err := archiveDirectory(data, file); err == nil {
+		t.Fatal("expected an existing snapshot to be kept")
+	}
+
+	// The destructive experiment
+	if err := os.RemoveAll(filepath.Join(data, "images")); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, data, map[string]string{"scratch/junk/xl.meta": "junk"})
+
+	if err := replaceDirectory(data, file); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(data, "images", "cat.png", "xl.meta")); got != "cat" {
+		t.Errorf("restored object = %q", got)
+	}
+	if got := readFile(t, filepath.Join(data, ".minio.sys", "format.json")); got != `{"version":"1"}` {
+		t.Errorf("restored format = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(data, "scratch")); !os.IsNotExist(err) {
+		t.Errorf("objects written after the snapshot are kept: %v", err)
+	}
+	for _, leftover := range []string{data + ".restore", data + ".previous"} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s is left: %v", leftover, err)
+		}
+	}
+}
+
+func TestRestoreRejectsEntriesOutsideTheData(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "evil.tar.gz")
+	out, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := gzip.NewWriter(out)
+	archive := tar.NewWriter(compressed)
+	content := []byte("owned")
+	if err = archive.WriteHeader(&tar.Header{Name: "../outside", Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = archive.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	_ = archive.Close()
+	_ = compressed.Close()
+	_ = out.Close()
+
+	data := filepath.Join(root, "data")
+	writeFiles(t, data, map[string]string{"kept/xl.meta": "kept"})
+	if err = replaceDirectory(data, file); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err = os.Stat(filepath.Join(root, "outside")); !os.IsNotExist(err) {
+		t.Errorf("the archive wrote outside of the data: %v", err)
+	}
+	if got := readFile(t, filepath.Join(data, "kept", "xl.meta")); got != "kept" {
+		t.Errorf("data after a failed restore = %q", got)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+// Stop modes: what Stop does to the local server.
+const (
+	// KeepAliveStop leaves the server running, the default: the next Start is
+	// immediate.
+	KeepAliveStop = "keep-alive"
+	// PauseStop freezes the server: it keeps its memory, but uses no CPU.
+	PauseStop = "pause"
+	// StopStop stops the server and frees its memory and ports. The data
+	// volume is kept.
+	StopStop = "stop"
+)
+
+// validateStopMode checks the stop-mode setting.
+func validateStopMode(mode string) error {
+	switch mode {
+	case "", KeepAliveStop, PauseStop, StopStop:
+		return nil
+	}
+	return fmt.Errorf("stop-mode must be %s, %s or %s, got %q", KeepAliveStop, PauseStop, StopStop, mode)
+}
+
+// States of the local server, reported by Information.
+const (
+	serverNotStarted = "not started"
+	serverRunning    = "running"
+	serverPaused     = "paused"
+	serverStopped    = "stopped"
+)
+
+// stopServer applies the stop mode to the server of the backend.
+func (s *Runtime) stopServer(ctx context.Context) error {
+	mode := s.Settings.StopMode
+	if mode == "" || mode == KeepAliveStop {
+		s.Wool.Debug("nothing to stop: keep environment alive")
+		return nil
+	}
+	if err := s.suspendServer(ctx, mode); err != nil {
+		return err
+	}
+	s.Wool.Info("minio is "+s.serverState, wool.Field("backend", s.backend))
+	return nil
+}
+
+// suspendServer pauses or stops the server of the backend.
+func (s *Runtime) suspendServer(ctx context.Context, mode string) error {
+	var err error
+	switch s.backend {
+	case FakeBackend:
+		// The data of the fake is in the agent: both modes only close the
+		// listener.
+		err = s.fake.Close()
+	case NativeBackend:
+		if mode == PauseStop {
+			err = s.native.Pause()
+		} else {
+			err = s.native.Stop()
+		}
+	default:
+		if mode == PauseStop {
+			s.runnerEnvironment.WithPause()
+		}
+		err = s.runnerEnvironment.Stop(ctx)
+	}
+	if err != nil {
+		return s.Wool.Wrapf(err, "cannot %s minio", mode)
+	}
+	if mode == PauseStop {
+		s.serverState = serverPaused
+	} else {
+		s.serverState = serverStopped
+	}
+	return nil
+}
+
+// resumeServer brings a paused or stopped server back.
+func (s *Runtime) resumeServer(ctx context.Context) error {
+	if s.serverState != serverPaused && s.serverState != serverStopped {
+		return nil
+	}
+	var err error
+	switch s.backend {
+	case FakeBackend:
+		_, err = s.fake.Start(s.fakeAddress)
+	case NativeBackend:
+		if s.serverState == serverPaused {
+			err = s.native.Resume()
+		} else {
+			err = s.native.Start(ctx, s.Wool)
+		}
+	default:
+		// Init of the runner resumes a paused container and starts a stopped
+		// one.
+		err = s.runnerEnvironment.Init(ctx)
+	}
+	if err != nil {
+		return s.Wool.Wrapf(err, "cannot resume minio from %s", s.serverState)
+	}
+	s.Wool.Debug("minio resumed", wool.Field("from", s.serverState))
+	s.serverState = serverRunning
+	return nil
+}
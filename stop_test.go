@@ -0,0 +1,49 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidateStopMode(t *testing.T) {
+	for _, mode := range []string{"", KeepAliveStop, PauseStop, StopStop} {
+		if err := validateStopMode(mode); err != nil {
+			t.Errorf("%q: %v", mode, err)
+		}
+	}
+	if err := validateStopMode("hibernate"); err == nil {
+		t.Error("expected an invalid stop-mode error")
+	}
+}
+
+func TestInformationReportsTheServerState(t *testing.T) {
+	runtime := NewRuntime()
+	details := runtime.informationDetails()
+	if details["lifecycle"] != "unloaded" || details["server"] != serverNotStarted || details["backend"] != "" {
+		t.Errorf("details before Init = %v", details)
+	}
+	runtime.backend = DockerBackend
+	runtime.serverState = serverPaused
+	runtime.lifecycle.set(stateStopped)
+	// Information reports what the RPC published as it left.
+	runtime.lifecycle.publish(runtime.status())
+	details = runtime.informationDetails()
+	if details["lifecycle"] != "stopped" || details["server"] != serverPaused || details["backend"] != DockerBackend {
+		t.Errorf("details of a paused container = %v", details)
+	}
+}
+
+func TestInformationReportsTheSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	for _, file := range []string{snapshotFile(dir, "good", at), snapshotFile(dir, "good", at.Add(time.Millisecond))} {
+		writeFiles(t, dir, map[string]string{filepath.Base(file): ""})
+	}
+	runtime := NewRuntime()
+	runtime.lifecycle.publish(runtimeStatus{snapshots: dir})
+	expected := "good@2026-10-18T12:00:00Z, good@2026-10-18T12:00:00.001Z"
+	if got := runtime.informationDetails()["snapshots"]; got != expected {
+		t.Errorf("snapshots = %q", got)
+	}
+}
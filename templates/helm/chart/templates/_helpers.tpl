@@ -0,0 +1,53 @@
+{{/* Credentials come from an existing Secret, or from the Secret of the chart. */}}
+{{- define "minio.credentialsEnv" -}}
+{{- with .Values.credentials.existingSecret }}
+- name: MINIO_ACCESS_KEY
+  valueFrom:
+    secretKeyRef:
+      name: {{ required "credentials.existingSecret.accessKey.name is required" .accessKey.name }}
+      key: {{ required "credentials.existingSecret.accessKey.key is required" .accessKey.key }}
+      optional: false
+- name: MINIO_SECRET_KEY
+  valueFrom:
+    secretKeyRef:
+      name: {{ required "credentials.existingSecret.secretKey.name is required" .secretKey.name }}
+      key: {{ required "credentials.existingSecret.secretKey.key is required" .secretKey.key }}
+      optional: false
+{{- else }}
+- name: MINIO_ACCESS_KEY
+  valueFrom:
+    secretKeyRef:
+      name: {{ .Values.name }}-credentials
+      key: MINIO_ACCESS_KEY
+      optional: false
+- name: MINIO_SECRET_KEY
+  valueFrom:
+    secretKeyRef:
+      name: {{ .Values.name }}-credentials
+      key: MINIO_SECRET_KEY
+      optional: false
+{{- end }}
+{{- end }}
+
+{{- define "minio.image" -}}
+{{ .Values.image.repository }}:{{ .Values.image.tag }}{{ with .Values.image.digest }}@{{ . }}{{ end }}
+{{- end }}
+
+{{- define "minio.podSecurityContext" -}}
+runAsNonRoot: true
+runAsUser: 1000
+runAsGroup: 1000
+seccompProfile:
+  type: RuntimeDefault
+{{- end }}
+
+{{- define "minio.containerSecurityContext" -}}
+allowPrivilegeEscalation: false
+runAsNonRoot: true
+readOnlyRootFilesystem: true
+seccompProfile:
+  type: RuntimeDefault
+capabilities:
+  drop:
+    - ALL
+{{- end }}
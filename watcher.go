@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+// Health watcher defaults.
+const (
+	healthInterval = 5 * time.Second
+	// healthThreshold failed probes in a row are a crash.
+	healthThreshold = 3
+	// maxRestarts in a row before giving up, waiting restartBackoff, doubled
+	// at each attempt up to maxRestartBackoff.
+	maxRestarts       = 5
+	restartBackoff    = time.Second
+	maxRestartBackoff = time.Minute
+	// logTailLines are kept to explain a crash.
+	logTailLines = 20
+)
+
+// Health of the server, reported by Information.
+const (
+	healthUnknown  = "unknown"
+	healthHealthy  = "healthy"
+	healthCrashed  = "crashed"
+	healthGaveUp   = "gave up restarting"
+	healthDetached = "not watched"
+)
+
+// logTail keeps the last lines of the server output.
+type logTail struct {
+	mu      sync.Mutex
+	lines   []string
+	partial []byte
+}
+
+func (t *logTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimSpace(string(t.partial[:i])); line != "" {
+			t.lines = append(t.lines, line)
+			if len(t.lines) > logTailLines {
+				t.lines = t.lines[len(t.lines)-logTailLines:]
+			}
+		}
+		t.partial = t.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Lines returns a copy of the last lines.
+func (t *logTail) Lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.lines...)
+}
+
+// healthWatcher polls the liveness of the server once it is running. A crash
+// is logged with the last lines of the server, and the server is restarted
+// when restart is set.
+type healthWatcher struct {
+	logger  logger
+	probe   func(ctx context.Context) error
+	restart func(ctx context.Context) error
+	logs    *logTail
+
+	interval  time.Duration
+	threshold int
+	backoff   time.Duration
+
+	mu        sync.Mutex
+	health    string
+	crashes   int
+	restarts  int
+	lastCrash time.Time
+	lastLogs  []string
+}
+
+func newHealthWatcher(l logger, probe func(ctx context.Context) error, restart func(ctx context.Context) error, logs *logTail) *healthWatcher {
+	return &healthWatcher{
+		logger:    l,
+		probe:     probe,
+		restart:   restart,
+		logs:      logs,
+		interval:  healthInterval,
+		threshold: healthThreshold,
+		backoff:   restartBackoff,
+		health:    healthUnknown,
+	}
+}
+
+// run watches until the context is done or restarting gave up.
+func (h *healthWatcher) run(ctx context.Context) {
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(h.interval):
+		}
+		err := h.probe(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			failures = 0
+			h.healthy()
+			continue
+		}
+		failures++
+		if failures < h.threshold {
+			continue
+		}
+		h.crashed(err)
+		if h.restart == nil {
+			continue
+		}
+		if !h.restartWithBackoff(ctx) {
+			return
+		}
+		failures = 0
+	}
+}
+
+func (h *healthWatcher) healthy() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.health == healthCrashed {
+		h.logger.Info("minio is healthy again")
+	}
+	h.health = healthHealthy
+}
+
+// crashed reports a crash once, until the server is healthy again.
+func (h *healthWatcher) crashed(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.health == healthCrashed {
+		return
+	}
+	h.health = healthCrashed
+	h.crashes++
+	h.lastCrash = time.Now()
+	h.lastLogs = h.logs.Lines()
+	h.logger.Error("minio crashed", wool.ErrField(err), wool.Field("last-logs", strings.Join(h.lastLogs, "\n")))
+}
+
+// restartWithBackoff restarts until the server is healthy, and tells whether
+// it is.
+func (h *healthWatcher) restartWithBackoff(ctx context.Context) bool {
+	delay := h.backoff
+	for attempt := 1; attempt <= maxRestarts; attempt++ {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+		h.logger.Warn("restarting minio", wool.Field("attempt", attempt))
+		err := h.restart(ctx)
+		if ctx.Err() != nil {
+			return false
+		}
+		if err == nil {
+			err = h.probe(ctx)
+		}
+		if err == nil {
+			h.mu.Lock()
+			h.restarts++
+			h.mu.Unlock()
+			h.healthy()
+			return true
+		}
+		h.logger.Warn("cannot restart minio", wool.Field("attempt", attempt), wool.ErrField(err))
+		delay = min(2*delay, maxRestartBackoff)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.health = healthGaveUp
+	h.logger.Error(fmt.Sprintf("minio did not recover after %d restarts", maxRestarts))
+	return false
+}
+
+// details for Information.
+func (h *healthWatcher) details() map[string]string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	details := map[string]string{
+		"health":   h.health,
+		"crashes":  strconv.Itoa(h.crashes),
+		"restarts": strconv.Itoa(h.restarts),
+	}
+	if h.crashes > 0 {
+		details["last-crash"] = h.lastCrash.UTC().Format(time.RFC3339)
+		details["last-crash-logs"] = strings.Join(h.lastLogs, "\n")
+	}
+	return details
+}
+
+// probeLiveness checks the liveness endpoint of the local server.
+func (s *Runtime) probeLiveness(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+s.hostReady+"/minio/health/live", nil)
+	if err != nil {
+		return err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("liveness: %s", response.Status)
+	}
+	return nil
+}
+
+// restartServer runs the server again, as an RPC: it waits for the running
+// RPC, unless the watcher is stopped, and only restarts a running service.
+func (s *Runtime) restartServer(ctx context.Context) error {
+	if err := s.lifecycle.enterContext(ctx, "restart", stateRunning); err != nil {
+		return err
+	}
+	defer s.leave()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var err error
+	if s.backend == NativeBackend {
+		err = s.native.Start(ctx, s.Wool)
+	} else {
+		err = s.runnerEnvironment.Init(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	return s.WaitForReady(ctx)
+}
+
+// startWatcher watches the server Start made ready. The fake runs in the
+// agent and is not watched.
+func (s *Runtime) startWatcher() {
+	s.stopWatcher()
+	if s.backend == FakeBackend {
+		return
+	}
+	var restart func(ctx context.Context) error
+	if s.Settings.AutoRestart {
+		restart = s.restartServer
+	}
+	watcher := newHealthWatcher(s.Wool, s.probeLiveness, restart, s.logTail)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.watcher, s.stopWatching, s.watcherDone = watcher, cancel, done
+	go func() {
+		defer close(done)
+		watcher.run(ctx)
+	}()
+}
+
+// stopWatcher waits for the watcher to return. A restart waiting for the RPC
+// that stops the watcher gives up when its context is cancelled.
+func (s *Runtime) stopWatcher() {
+	if s.stopWatching != nil {
+		s.stopWatching()
+		<-s.watcherDone
+		s.stopWatching, s.watcherDone = nil, nil
+	}
+}
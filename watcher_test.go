@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+// fakeServer is healthy until it crashes, and after restarts once it accepts
+// them.
+type fakeServer struct {
+	mu              sync.Mutex
+	up              bool
+	failingRestarts int
+	restarts        int
+}
+
+func (f *fakeServer) probe(context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.up {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (f *fakeServer) restart(context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.restarts++
+	if f.restarts <= f.failingRestarts {
+		return errors.New("port is already allocated")
+	}
+	f.up = true
+	return nil
+}
+
+func (f *fakeServer) set(up bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.up = up
+}
+
+type syncRecorder struct {
+	mu sync.Mutex
+	recordingLogger
+}
+
+func (r *syncRecorder) errors() []recordedLog {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var errors []recordedLog
+	for _, log := range r.logs {
+		if log.level == "error" {
+			errors = append(errors, log)
+		}
+	}
+	return errors
+}
+
+func (r *syncRecorder) Error(msg string, fields ...*wool.LogField) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recordingLogger.Error(msg, fields...)
+}
+
+func (r *syncRecorder) Warn(msg string, fields ...*wool.LogField) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recordingLogger.Warn(msg, fields...)
+}
+
+func (r *syncRecorder) Info(msg string, fields ...*wool.LogField) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recordingLogger.Info(msg, fields...)
+}
+
+func testWatcher(server *fakeServer, restart bool) (*healthWatcher, *syncRecorder) {
+	recorder := &syncRecorder{}
+	tail := &logTail{}
+	for i := 0; i < 30; i++ {
+		fmt.Fprintf(tail, "line %d\n", i)
+	}
+	var restartFunc func(context.Context) error
+	if restart {
+		restartFunc = server.restart
+	}
+	watcher := newHealthWatcher(recorder, server.probe, restartFunc, tail)
+	watcher.interval = time.Millisecond
+	watcher.backoff = time.Millisecond
+	return watcher, recorder
+}
+
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWatcherReportsCrashWithLastLogs(t *testing.T) {
+	server := &fakeServer{up: true}
+	watcher, recorder := testWatcher(server, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.run(ctx)
+
+	eventually(t, func() bool { return watcher.details()["health"] == healthHealthy })
+	server.set(false)
+	eventually(t, func() bool { return watcher.details()["health"] == healthCrashed })
+	details := watcher.details()
+	if details["crashes"] != "1" || !strings.HasPrefix(details["last-crash-logs"], "line 10\n") || !strings.HasSuffix(details["last-crash-logs"], "line 29") {
+		t.Errorf("details = %v", details)
+	}
+	if errs := recorder.errors(); len(errs) != 1 || errs[0].message != "minio crashed" {
+		t.Errorf("errors = %+v", errs)
+	}
+	server.set(true)
+	eventually(t, func() bool { return watcher.details()["health"] == healthHealthy })
+}
+
+func TestWatcherRestartsWithBackoff(t *testing.T) {
+	server := &fakeServer{failingRestarts: 2}
+	watcher, _ := testWatcher(server, true)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.run(ctx)
+
+	eventually(t, func() bool { return watcher.details()["restarts"] == "1" })
+	if details := watcher.details(); details["health"] != healthHealthy || details["crashes"] != "1" {
+		t.Errorf("details = %v", details)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.restarts != 3 {
+		t.Errorf("restart attempts = %d", server.restarts)
+	}
+}
+
+func TestWatcherGivesUp(t *testing.T) {
+	server := &fakeServer{failingRestarts: maxRestarts + 1}
+	watcher, recorder := testWatcher(server, true)
+	done := make(chan struct{})
+	go func() {
+		watcher.run(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the watcher did not give up")
+	}
+	if health := watcher.details()["health"]; health != healthGaveUp {
+		t.Errorf("health = %s", health)
+	}
+	if errs := recorder.errors(); len(errs) != 2 {
+		t.Errorf("errors = %+v", errs)
+	}
+}
+
+func TestStopWatcherWaitsForARestartWaitingForTheRPC(t *testing.T) {
+	runtime := NewRuntime()
+	runtime.lifecycle.set(stateRunning)
+	// The RPC stopping the watcher holds the lifecycle.
+	if err := runtime.lifecycle.enter("Stop", stateRunning); err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.lifecycle.leave()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	restarted := make(chan error, 1)
+	runtime.stopWatching, runtime.watcherDone = cancel, done
+	go func() {
+		defer close(done)
+		restarted <- runtime.restartServer(ctx)
+	}()
+
+	stopped := make(chan struct{})
+	go func() {
+		runtime.stopWatcher()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopWatcher did not return")
+	}
+	if err := <-restarted; !errors.Is(err, context.Canceled) {
+		t.Errorf("restart = %v", err)
+	}
+	if runtime.stopWatching != nil || runtime.watcherDone != nil {
+		t.Error("the watcher is still set")
+	}
+}